@@ -12,6 +12,17 @@ type Config struct {
 	AI       AIConfig       `mapstructure:"ai"`
 	Xunfei   XunfeiConfig   `mapstructure:"xunfei"`
 	LocalAI  LocalAIConfig  `mapstructure:"local_ai"`
+	Progress ProgressConfig `mapstructure:"progress"`
+	Risk     RiskConfig     `mapstructure:"risk"`
+	Register RegisterConfig `mapstructure:"register"`
+	Login    LoginConfig    `mapstructure:"login"`
+	SMTP     SMTPConfig     `mapstructure:"smtp"`
+	Reset    ResetConfig    `mapstructure:"password_reset"`
+	TwoFA    TwoFAConfig    `mapstructure:"two_factor"`
+	OIDC     OIDCConfig     `mapstructure:"oidc"`
+	LDAP     LDAPConfig     `mapstructure:"ldap"`
+	Tenant   TenantConfig   `mapstructure:"tenant"`
+	Privacy  PrivacyConfig  `mapstructure:"privacy"`
 }
 
 type ServerConfig struct {
@@ -35,8 +46,10 @@ type RedisConfig struct {
 }
 
 type JWTConfig struct {
-	Secret string `mapstructure:"secret"`
-	Expire int    `mapstructure:"expire"` // 过期时间(小时)
+	Secret        string `mapstructure:"secret"`
+	Expire        int    `mapstructure:"expire"`         // 过期时间(小时)，未配置refresh_expire时作为刷新令牌有效期
+	AccessExpire  int    `mapstructure:"access_expire"`  // 访问令牌有效期(分钟)
+	RefreshExpire int    `mapstructure:"refresh_expire"` // 刷新令牌有效期(小时)
 }
 
 type AIConfig struct {
@@ -79,6 +92,111 @@ type LocalAIConfig struct {
 	Timeout     int     `mapstructure:"timeout"`
 }
 
+type ProgressConfig struct {
+	IdleTimeout int `mapstructure:"idle_timeout"` // 学习心跳间隔超过该值(秒)视为空闲，不计入学习时长
+}
+
+type RiskConfig struct {
+	Enabled        bool `mapstructure:"enabled"`
+	Interval       int  `mapstructure:"interval"`        // 预警任务执行间隔(小时)
+	InactiveDays   int  `mapstructure:"inactive_days"`   // 超过该天数未学习视为不活跃
+	AlertThreshold int  `mapstructure:"alert_threshold"` // 风险分达到该值生成预警
+}
+
+// 注册策略
+const (
+	RegisterPolicyOpen       = "open"        // 开放注册，学生可直接注册，教师需邀请码或管理员审核
+	RegisterPolicyInviteOnly = "invite_only" // 仅凭邀请码注册
+	RegisterPolicyClosed     = "closed"      // 关闭注册
+)
+
+type RegisterConfig struct {
+	Policy string `mapstructure:"policy"`
+}
+
+type LoginConfig struct {
+	Window          int `mapstructure:"window"`           // 统计登录失败次数的滑动窗口(分钟)
+	MaxAttempts     int `mapstructure:"max_attempts"`     // 窗口内同一用户名失败达到该次数后锁定
+	MaxIPAttempts   int `mapstructure:"max_ip_attempts"`  // 窗口内同一IP失败达到该次数后锁定
+	LockoutDuration int `mapstructure:"lockout_duration"` // 锁定时长(分钟)
+	DelayBase       int `mapstructure:"delay_base"`       // 失败后的首次延迟(毫秒)，此后每次失败翻倍
+	DelayMax        int `mapstructure:"delay_max"`        // 延迟上限(毫秒)
+}
+
+type SMTPConfig struct {
+	Host     string `mapstructure:"host"`
+	Port     int    `mapstructure:"port"`
+	Username string `mapstructure:"username"` // 为空时不进行认证，便于对接本地邮件捕获工具
+	Password string `mapstructure:"password"`
+	From     string `mapstructure:"from"`
+	FromName string `mapstructure:"from_name"`
+	TLS      bool   `mapstructure:"tls"` // 使用SMTPS(通常为465端口)；为false时若服务器支持则使用STARTTLS
+}
+
+type ResetConfig struct {
+	URL    string `mapstructure:"url"`    // 前端重置密码页面地址，令牌以token参数附加
+	Expire int    `mapstructure:"expire"` // 重置链接有效期(分钟)
+}
+
+type TwoFAConfig struct {
+	Issuer        string   `mapstructure:"issuer"`         // 身份验证器App中显示的名称
+	EnforcedRoles []string `mapstructure:"enforced_roles"` // 必须启用双因素认证的角色，如teacher、admin
+}
+
+type OIDCConfig struct {
+	Enabled       bool     `mapstructure:"enabled"`
+	Tenants       []string `mapstructure:"tenants"` // 使用该身份提供方的学校标识，为空时只用于默认学校
+	Issuer        string   `mapstructure:"issuer"`  // 身份提供方地址，通过/.well-known/openid-configuration自动发现
+	ClientID      string   `mapstructure:"client_id"`
+	ClientSecret  string   `mapstructure:"client_secret"`
+	RedirectURL   string   `mapstructure:"redirect_url"`   // 后端回调地址，如https://example.com/api/v1/auth/oidc/callback
+	FrontendURL   string   `mapstructure:"frontend_url"`   // 登录完成后跳转的前端页面，附带一次性code参数
+	Scopes        []string `mapstructure:"scopes"`         // 默认openid profile email
+	UsernameClaim string   `mapstructure:"username_claim"` // 默认preferred_username
+	NameClaim     string   `mapstructure:"name_claim"`     // 默认name
+	GroupsClaim   string   `mapstructure:"groups_claim"`   // 默认groups
+	AdminGroups   []string `mapstructure:"admin_groups"`   // 属于这些组的新用户创建为管理员
+	TeacherGroups []string `mapstructure:"teacher_groups"` // 属于这些组的新用户创建为教师，其余为学生
+	AutoProvision bool     `mapstructure:"auto_provision"` // 首次登录时自动创建账户
+	LinkByEmail   bool     `mapstructure:"link_by_email"`  // 按已验证的邮箱关联已有账户
+}
+
+type LDAPConfig struct {
+	Enabled            bool     `mapstructure:"enabled"`
+	Tenants            []string `mapstructure:"tenants"` // 使用该目录的学校标识，为空时只用于默认学校
+	URL                string   `mapstructure:"url"`     // 如ldap://ldap.example.edu:389或ldaps://ldap.example.edu:636
+	StartTLS           bool     `mapstructure:"start_tls"`
+	InsecureSkipVerify bool     `mapstructure:"insecure_skip_verify"`
+	BindDN             string   `mapstructure:"bind_dn"` // 用于查找用户的服务账户，为空时匿名查找
+	BindPassword       string   `mapstructure:"bind_password"`
+	BaseDN             string   `mapstructure:"base_dn"`
+	UserFilter         string   `mapstructure:"user_filter"`     // %s替换为登录用户名，默认(uid=%s)，AD可用(sAMAccountName=%s)
+	IDAttr             string   `mapstructure:"id_attr"`         // 唯一且不变的标识属性，如entryUUID、objectGUID，默认使用用户名属性
+	UsernameAttr       string   `mapstructure:"username_attr"`   // 默认uid
+	EmailAttr          string   `mapstructure:"email_attr"`      // 默认mail
+	NameAttr           string   `mapstructure:"name_attr"`       // 默认cn
+	DepartmentAttr     string   `mapstructure:"department_attr"` // 默认department
+	ClassAttr          string   `mapstructure:"class_attr"`      // 为空时不同步班级
+	GroupAttr          string   `mapstructure:"group_attr"`      // 默认memberOf
+	AdminGroups        []string `mapstructure:"admin_groups"`    // 组DN或组名，属于这些组的新用户创建为管理员
+	TeacherGroups      []string `mapstructure:"teacher_groups"`
+	AutoProvision      bool     `mapstructure:"auto_provision"`
+	LinkByEmail        bool     `mapstructure:"link_by_email"`
+	LocalRoles         []string `mapstructure:"local_roles"` // 启用LDAP后仍可使用本地密码登录的角色，默认admin和super_admin
+}
+
+type TenantConfig struct {
+	BaseDomain  string   `mapstructure:"base_domain"`  // 如example.com，访问school1.example.com时学校标识为school1
+	Default     string   `mapstructure:"default"`      // 无法从子域名或请求头确定学校时使用的学校标识
+	SuperAdmins []string `mapstructure:"super_admins"` // 启动时设为平台管理员的默认学校用户名
+}
+
+type PrivacyConfig struct {
+	ExportDir         string `mapstructure:"export_dir"`          // 个人数据导出文件保存目录，不能位于uploads下
+	ExportExpire      int    `mapstructure:"export_expire"`       // 导出文件保留时长(小时)，过期后删除
+	DeletionGraceDays int    `mapstructure:"deletion_grace_days"` // 申请注销后的冷静期(天)，期间可撤销
+}
+
 var GlobalConfig Config
 
 func LoadConfig() error {
@@ -87,6 +205,21 @@ func LoadConfig() error {
 	viper.AddConfigPath("./config")
 	viper.AddConfigPath(".")
 
+	// 默认值
+	viper.SetDefault("risk.enabled", true)
+	viper.SetDefault("register.policy", RegisterPolicyOpen)
+	viper.SetDefault("smtp.port", 25)
+	viper.SetDefault("password_reset.expire", 30)
+	viper.SetDefault("oidc.auto_provision", true)
+	viper.SetDefault("oidc.link_by_email", true)
+	viper.SetDefault("ldap.auto_provision", true)
+	viper.SetDefault("ldap.link_by_email", true)
+	viper.SetDefault("ldap.local_roles", []string{"admin", "super_admin"})
+	viper.SetDefault("tenant.default", "default")
+	viper.SetDefault("privacy.export_dir", "exports")
+	viper.SetDefault("privacy.export_expire", 72)
+	viper.SetDefault("privacy.deletion_grace_days", 7)
+
 	if err := viper.ReadInConfig(); err != nil {
 		return err
 	}
@@ -43,13 +43,39 @@ func InitDB() error {
 }
 
 func AutoMigrate() error {
-	return DB.AutoMigrate(
+	// 每题只能作答一次，创建唯一索引前删除之前重复提交的答案，保留第一次作答，并重新计算已完成练习的总分
+	if DB.Migrator().HasTable(&models.StudentAnswer{}) &&
+		!DB.Migrator().HasIndex(&models.StudentAnswer{}, "idx_record_question") {
+		if err := DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec(`DELETE a FROM student_answers a JOIN student_answers b
+				ON b.record_id = a.record_id AND b.question_id = a.question_id AND b.id < a.id`).Error; err != nil {
+				return err
+			}
+			return tx.Exec(`UPDATE exercise_records r SET r.score = (
+					SELECT COALESCE(SUM(a.score), 0) FROM student_answers a
+					WHERE a.record_id = r.id AND a.deleted_at IS NULL
+				) WHERE r.status = 'completed'`).Error
+		}); err != nil {
+			return err
+		}
+	}
+
+	err := DB.AutoMigrate(
+		&models.Tenant{},
 		&models.User{},
 		&models.UserProfile{},
+		&models.RegistrationInvite{},
+		&models.TwoFactor{},
+		&models.RecoveryCode{},
+		&models.UserIdentity{},
+		&models.Permission{},
+		&models.Role{},
 		&models.Course{},
 		&models.Chapter{},
 		&models.Knowledge{},
 		&models.CourseMaterial{},
+		&models.Enrollment{},
+		&models.CourseMember{},
 		&models.Exercise{},
 		&models.Question{},
 		&models.StudentAnswer{},
@@ -58,7 +84,67 @@ func AutoMigrate() error {
 		&models.ChatMessage{},
 		&models.KnowledgeBase{},
 		&models.LearningProgress{},
+		&models.KnowledgeProgress{},
+		&models.KnowledgeMastery{},
+		&models.Notification{},
+		&models.RiskAlert{},
+		&models.AuditLog{},
+		&models.DataExport{},
+		&models.AccountDeletion{},
+		&models.ServiceAccount{},
+		&models.APIKey{},
 	)
+	if err != nil {
+		return err
+	}
+
+	// 用户名、邮箱和外部身份改为学校内唯一，删除旧的全局唯一索引
+	legacyIndexes := []struct {
+		model interface{}
+		name  string
+	}{
+		{&models.User{}, "idx_users_username"},
+		{&models.User{}, "idx_users_email"},
+		{&models.UserIdentity{}, "idx_provider_subject"},
+	}
+	for _, index := range legacyIndexes {
+		if DB.Migrator().HasIndex(index.model, index.name) {
+			if err := DB.Migrator().DropIndex(index.model, index.name); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := backfillChatMessageParents(); err != nil {
+		return err
+	}
+	// 自动生成标题之前创建的空标题会话改用默认标题，并在下次对话后自动生成。
+	// 新会话创建时总会填写标题，按空标题判断，每次启动都可以安全执行
+	return DB.Model(&models.ChatSession{}).Where("title = ''").
+		UpdateColumns(map[string]interface{}{"title": models.DefaultChatTitle, "auto_title": true}).Error
+}
+
+// 聊天消息改为树形结构之前的会话按发送顺序把消息连成一条分支，会话的当前分支指向最后一条消息。
+// 新会话添加消息时会同时设置当前分支，有消息但没有当前分支的会话即为旧会话，只处理这些会话，
+// 每次启动都可以安全执行，中途失败下次启动会继续补齐
+func backfillChatMessageParents() error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`UPDATE chat_messages m JOIN (
+				SELECT a.id, MAX(b.id) AS parent_id FROM chat_messages a
+				JOIN chat_messages b ON b.session_id = a.session_id AND b.id < a.id
+				JOIN chat_sessions s ON s.id = a.session_id AND s.current_message_id IS NULL
+				WHERE a.parent_id IS NULL
+				GROUP BY a.id
+			) p ON p.id = m.id
+			SET m.parent_id = p.parent_id`).Error; err != nil {
+			return err
+		}
+		return tx.Exec(`UPDATE chat_sessions s JOIN (
+				SELECT session_id, MAX(id) AS last_id FROM chat_messages GROUP BY session_id
+			) m ON m.session_id = s.id
+			SET s.current_message_id = m.last_id
+			WHERE s.current_message_id IS NULL`).Error
+	})
 }
 
 func GetDB() *gorm.DB {
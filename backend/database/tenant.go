@@ -0,0 +1,32 @@
+package database
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// TenantScope 为带有TenantID字段的模型追加学校条件，其他模型不受影响，
+// 因此可以直接作用于任意查询
+func TenantScope(tenantID uint) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		model := db.Statement.Model
+		if model == nil {
+			model = db.Statement.Dest
+		}
+		if model == nil {
+			return db
+		}
+		if err := db.Statement.Parse(model); err != nil || db.Statement.Schema.LookUpField("TenantID") == nil {
+			return db
+		}
+		return db.Where(clause.Eq{
+			Column: clause.Column{Table: clause.CurrentTable, Name: "tenant_id"},
+			Value:  tenantID,
+		})
+	}
+}
+
+// ForTenant 返回限定在指定学校内的查询，可以像DB一样重复使用
+func ForTenant(tenantID uint) *gorm.DB {
+	return DB.Scopes(TenantScope(tenantID)).Session(&gorm.Session{})
+}
@@ -0,0 +1,321 @@
+package handlers
+
+import (
+	"backend/middleware"
+	"backend/models"
+	"backend/services"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type StartAdaptivePracticeRequest struct {
+	ChapterID     uint    `json:"chapter_id" binding:"required"`
+	QuestionLimit int     `json:"question_limit"`
+	TargetMastery float64 `json:"target_mastery"`
+}
+
+// 开始自适应练习
+func StartAdaptivePractice(c *gin.Context) {
+	userID := middleware.GetCurrentUserID(c)
+
+	var req StartAdaptivePracticeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "请求参数错误",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if req.QuestionLimit <= 0 {
+		req.QuestionLimit = services.DefaultAdaptiveQuestionLimit
+	}
+	if req.QuestionLimit > services.MaxAdaptiveQuestionLimit {
+		req.QuestionLimit = services.MaxAdaptiveQuestionLimit
+	}
+	if req.TargetMastery <= 0 || req.TargetMastery > 1 {
+		req.TargetMastery = services.MasteryThreshold
+	}
+
+	var chapter models.Chapter
+	if err := tenantDB(c).First(&chapter, req.ChapterID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"message": "章节不存在",
+		})
+		return
+	}
+
+	if !requireCourseAccess(c, chapter.CourseID) {
+		return
+	}
+
+	record := models.ExerciseRecord{
+		UserID:        userID,
+		Type:          models.ExerciseRecordTypeAdaptive,
+		ChapterID:     &chapter.ID,
+		QuestionLimit: req.QuestionLimit,
+		TargetMastery: req.TargetMastery,
+		StartTime:     time.Now(),
+		Status:        "ongoing",
+	}
+
+	adaptiveService := services.NewAdaptivePracticeService()
+	question, err := adaptiveService.NextQuestion(&record)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "选题失败",
+		})
+		return
+	}
+	if question == nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "该章节暂无可练习的题目或已全部掌握",
+		})
+		return
+	}
+
+	record.CurrentQuestionID = &question.ID
+	if err := tenantDB(c).Create(&record).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "开始练习失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "开始练习成功",
+		"data": gin.H{
+			"record_id": record.ID,
+			"record":    record,
+			"question":  publicQuestion(question),
+		},
+	})
+}
+
+// 提交自适应练习答案并获取下一题
+func SubmitAdaptiveAnswer(c *gin.Context) {
+	recordID := c.Param("recordId")
+	userID := middleware.GetCurrentUserID(c)
+
+	var req SubmitAnswerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "请求参数错误",
+		})
+		return
+	}
+
+	var record models.ExerciseRecord
+	if err := tenantDB(c).Where("id = ? AND user_id = ? AND type = ?", recordID, userID, models.ExerciseRecordTypeAdaptive).
+		First(&record).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"message": "练习记录不存在",
+		})
+		return
+	}
+
+	if record.Status != "ongoing" || record.CurrentQuestionID == nil || *record.CurrentQuestionID != req.QuestionID {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "不是当前待答题目",
+		})
+		return
+	}
+
+	var question models.Question
+	if err := tenantDB(c).First(&question, req.QuestionID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"message": "题目不存在",
+		})
+		return
+	}
+
+	// 评分前先占用当前题目，重复提交时只有一次能通过；评分或保存失败时恢复，学生可以重新提交
+	claim := tenantDB(c).Model(&models.ExerciseRecord{}).
+		Where("id = ? AND status = ? AND current_question_id = ?", record.ID, "ongoing", question.ID).
+		Update("current_question_id", nil)
+	if claim.Error != nil || claim.RowsAffected == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "不是当前待答题目",
+		})
+		return
+	}
+	release := func() {
+		if err := tenantDB(c).Model(&models.ExerciseRecord{}).Where("id = ?", record.ID).
+			Update("current_question_id", question.ID).Error; err != nil {
+			log.Printf("恢复练习记录%d的当前题目失败: %v", record.ID, err)
+		}
+	}
+
+	// 调用AI服务评估答案
+	aiService, err := tenantAIService(c)
+	if err != nil {
+		release()
+		respondTenantError(c, err, "AI服务暂不可用")
+		return
+	}
+	score, isCorrect, feedback, err := aiService.EvaluateAnswer(&question, req.Answer)
+	if err != nil {
+		release()
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "答案评估失败",
+		})
+		return
+	}
+
+	studentAnswer := models.StudentAnswer{
+		UserID:     userID,
+		ExerciseID: question.ExerciseID,
+		QuestionID: question.ID,
+		RecordID:   &record.ID,
+		Answer:     req.Answer,
+		Score:      score,
+		IsCorrect:  isCorrect,
+		Feedback:   feedback,
+	}
+	if err := tenantDB(c).Create(&studentAnswer).Error; err != nil {
+		release()
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "保存答案失败",
+		})
+		return
+	}
+
+	mastery, err := services.NewMasteryService().RecordAnswer(userID, &question, isCorrect)
+	if err != nil {
+		log.Printf("更新知识点掌握度失败: %v", err)
+	}
+
+	adaptiveService := services.NewAdaptivePracticeService()
+	next, err := adaptiveService.NextQuestion(&record)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "选题失败",
+		})
+		return
+	}
+
+	result := gin.H{
+		"score":      score,
+		"is_correct": isCorrect,
+		"feedback":   feedback,
+		"mastery":    mastery,
+		"finished":   next == nil,
+	}
+
+	if next != nil {
+		if err := tenantDB(c).Model(&record).Update("current_question_id", next.ID).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    500,
+				"message": "更新练习记录失败",
+			})
+			return
+		}
+		result["next_question"] = publicQuestion(next)
+	} else {
+		summary, ok := finishAdaptivePractice(c, &record)
+		if !ok {
+			return
+		}
+		for k, v := range summary {
+			result[k] = v
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "答案提交成功",
+		"data":    result,
+	})
+}
+
+// 提前结束自适应练习
+func CompleteAdaptivePractice(c *gin.Context) {
+	var record models.ExerciseRecord
+	if err := tenantDB(c).Where("id = ? AND user_id = ? AND type = ?", c.Param("recordId"), middleware.GetCurrentUserID(c), models.ExerciseRecordTypeAdaptive).
+		First(&record).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"message": "练习记录不存在",
+		})
+		return
+	}
+
+	summary, ok := finishAdaptivePractice(c, &record)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "练习完成",
+		"data":    summary,
+	})
+}
+
+// 汇总得分并结束自适应练习，只有进行中的练习可以结束；返回false时已写入错误响应
+func finishAdaptivePractice(c *gin.Context, record *models.ExerciseRecord) (gin.H, bool) {
+	var totalScore int
+	tenantDB(c).Model(&models.StudentAnswer{}).
+		Where("record_id = ?", record.ID).
+		Select("COALESCE(SUM(score), 0)").
+		Scan(&totalScore)
+
+	updates := map[string]interface{}{
+		"score":               totalScore,
+		"status":              "completed",
+		"end_time":            time.Now(),
+		"current_question_id": nil,
+	}
+	result := tenantDB(c).Model(record).Where("status = ?", "ongoing").Updates(updates)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "完成练习失败",
+		})
+		return nil, false
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "练习记录不可完成",
+		})
+		return nil, false
+	}
+
+	chapterMastery, _ := services.NewAdaptivePracticeService().ChapterMasteryAverage(record.UserID, *record.ChapterID)
+	return gin.H{
+		"total_score":     totalScore,
+		"chapter_mastery": chapterMastery,
+	}, true
+}
+
+// 返回给学生的题目信息，不包含答案和解析
+func publicQuestion(q *models.Question) gin.H {
+	return gin.H{
+		"id":           q.ID,
+		"exercise_id":  q.ExerciseID,
+		"knowledge_id": q.KnowledgeID,
+		"type":         q.Type,
+		"title":        q.Title,
+		"content":      q.Content,
+		"options":      q.Options,
+		"score":        q.Score,
+		"difficulty":   q.Difficulty,
+	}
+}
@@ -0,0 +1,663 @@
+package handlers
+
+import (
+	"backend/middleware"
+	"backend/models"
+	"backend/services"
+	"backend/utils"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 管理员重置密码时自动生成的密码长度
+const generatedPasswordLength = 10
+
+type AdminCreateUserRequest struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+	Email    string `json:"email" binding:"required,email"`
+	RealName string `json:"real_name" binding:"required"`
+	Role     string `json:"role" binding:"required"`
+	Phone    string `json:"phone"`
+}
+
+type UpdateUserRoleRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+type UpdateUserStatusRequest struct {
+	Status *int `json:"status" binding:"required,oneof=0 1"`
+}
+
+type ResetPasswordRequest struct {
+	Password string `json:"password"`
+}
+
+// 解析分页参数
+func getPagination(c *gin.Context) (int, int) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+	return page, pageSize
+}
+
+// 查找用户（可包含已删除用户），不存在时直接返回404
+func findUser(c *gin.Context, unscoped bool) (*models.User, bool) {
+	query := tenantDB(c)
+	if unscoped {
+		query = query.Unscoped()
+	}
+
+	// 学校管理员看不到也不能操作平台管理员；服务账户对应的用户通过服务账户接口管理
+	query = query.Where("role <> ?", models.RoleService)
+	if !isSuperAdmin(c) {
+		query = query.Where("role <> ?", models.RoleSuperAdmin)
+	}
+
+	var user models.User
+	if err := query.First(&user, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"message": "用户不存在",
+		})
+		return nil, false
+	}
+
+	// API密钥不能修改管理员账户，避免拥有用户管理范围的密钥借此提权
+	if middleware.IsAPIKeyRequest(c) && user.Role.IsAdmin() && c.Request.Method != http.MethodGet {
+		c.JSON(http.StatusForbidden, gin.H{
+			"code":    403,
+			"message": "API密钥不能操作管理员账户",
+		})
+		return nil, false
+	}
+	return &user, true
+}
+
+// 使用户已签发的令牌全部失效，失败时只记录日志
+func revokeUserTokens(userID uint) {
+	if err := services.NewTokenService().RevokeAll(userID); err != nil {
+		log.Printf("注销用户%d的登录会话失败: %v", userID, err)
+	}
+}
+
+// 管理员不能对自己执行禁用、降级、删除等操作
+func rejectSelf(c *gin.Context, user *models.User) bool {
+	if user.ID != middleware.GetCurrentUserID(c) {
+		return false
+	}
+	c.JSON(http.StatusBadRequest, gin.H{
+		"code":    400,
+		"message": "不能对自己执行该操作",
+	})
+	return true
+}
+
+// 用户列表（管理员）
+func AdminListUsers(c *gin.Context) {
+	page, pageSize := getPagination(c)
+
+	query := tenantDB(c).Model(&models.User{}).Where("role <> ?", models.RoleService)
+	if !isSuperAdmin(c) {
+		query = query.Where("role <> ?", models.RoleSuperAdmin)
+	}
+	if c.Query("deleted") == "1" {
+		query = query.Unscoped().Where("deleted_at IS NOT NULL")
+	}
+	if keyword := c.Query("keyword"); keyword != "" {
+		like := "%" + keyword + "%"
+		query = query.Where("username LIKE ? OR real_name LIKE ? OR email LIKE ? OR phone LIKE ?", like, like, like, like)
+	}
+	if role := c.Query("role"); role != "" {
+		query = query.Where("role = ?", role)
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "获取用户列表失败",
+		})
+		return
+	}
+
+	var users []models.User
+	if err := query.Order("id DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&users).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "获取用户列表失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "获取成功",
+		"data": gin.H{
+			"list":      users,
+			"total":     total,
+			"page":      page,
+			"page_size": pageSize,
+		},
+	})
+}
+
+// 用户详情（管理员）
+func AdminGetUser(c *gin.Context) {
+	user, ok := findUser(c, true)
+	if !ok {
+		return
+	}
+
+	var profile *models.UserProfile
+	var p models.UserProfile
+	if err := tenantDB(c).Where("user_id = ?", user.ID).First(&p).Error; err == nil {
+		profile = &p
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "获取成功",
+		"data": gin.H{
+			"user":       user,
+			"profile":    profile,
+			"deleted":    user.DeletedAt.Valid,
+			"deleted_at": user.DeletedAt,
+		},
+	})
+}
+
+// 创建用户（管理员）
+func AdminCreateUser(c *gin.Context) {
+	var req AdminCreateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "请求参数错误",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	role := models.UserRole(req.Role)
+	if !canAssignRole(c, role) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "角色无效",
+		})
+		return
+	}
+
+	var count int64
+	tenantDB(c).Unscoped().Model(&models.User{}).Where("username = ? OR email = ?", req.Username, req.Email).Count(&count)
+	if count > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "用户名或邮箱已存在",
+		})
+		return
+	}
+
+	if err := utils.ValidatePasswordStrength(req.Password, req.Username); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	hashedPassword, err := utils.HashPassword(req.Password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "密码加密失败",
+		})
+		return
+	}
+
+	if err := services.NewTenantService().CheckUserQuota(tenantID(c), 1); err != nil {
+		respondTenantError(c, err, "用户创建失败")
+		return
+	}
+
+	user := models.User{
+		TenantID: tenantID(c),
+		Username: req.Username,
+		Password: hashedPassword,
+		Email:    req.Email,
+		RealName: req.RealName,
+		Role:     role,
+		Phone:    req.Phone,
+		Status:   models.UserStatusActive,
+	}
+	if err := tenantDB(c).Create(&user).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "用户创建失败",
+		})
+		return
+	}
+
+	recordAudit(c, "user.create", "user", user.ID, gin.H{
+		"username": user.Username,
+		"role":     user.Role,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "用户创建成功",
+		"data":    user,
+	})
+}
+
+// 修改用户角色（管理员）
+func AdminUpdateUserRole(c *gin.Context) {
+	user, ok := findUser(c, false)
+	if !ok || rejectSelf(c, user) {
+		return
+	}
+
+	var req UpdateUserRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "请求参数错误",
+		})
+		return
+	}
+
+	role := models.UserRole(req.Role)
+	if !canAssignRole(c, role) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "角色无效",
+		})
+		return
+	}
+
+	oldRole := user.Role
+	if err := tenantDB(c).Model(user).Update("role", role).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "修改角色失败",
+		})
+		return
+	}
+
+	revokeUserTokens(user.ID)
+	recordAuditChange(c, "user.role_change", "user", user.ID, gin.H{"role": oldRole}, gin.H{"role": role}, nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "角色修改成功",
+		"data":    user,
+	})
+}
+
+// 启用/禁用用户（管理员）
+func AdminUpdateUserStatus(c *gin.Context) {
+	user, ok := findUser(c, false)
+	if !ok || rejectSelf(c, user) {
+		return
+	}
+
+	var req UpdateUserStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "请求参数错误",
+		})
+		return
+	}
+
+	// 待审核教师只能通过审核接口处理，不能直接启用而跳过审核
+	if user.Status == models.UserStatusPending {
+		c.JSON(http.StatusConflict, gin.H{
+			"code":    409,
+			"message": "该用户待审核，请使用审核接口处理",
+		})
+		return
+	}
+
+	oldStatus := user.Status
+	if err := tenantDB(c).Model(user).Update("status", *req.Status).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "修改状态失败",
+		})
+		return
+	}
+
+	action := "user.enable"
+	if *req.Status == models.UserStatusDisabled {
+		action = "user.disable"
+		revokeUserTokens(user.ID)
+	}
+	recordAuditChange(c, action, "user", user.ID, gin.H{"status": oldStatus}, gin.H{"status": *req.Status}, nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "状态修改成功",
+		"data":    user,
+	})
+}
+
+// 重置用户密码（管理员），未指定新密码时自动生成
+func AdminResetPassword(c *gin.Context) {
+	user, ok := findUser(c, false)
+	if !ok {
+		return
+	}
+
+	var req ResetPasswordRequest
+	c.ShouldBindJSON(&req)
+
+	password := req.Password
+	generated := password == ""
+	if generated {
+		var err error
+		if password, err = utils.GeneratePassword(generatedPasswordLength, user.Username); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    500,
+				"message": "生成密码失败",
+			})
+			return
+		}
+	} else if err := utils.ValidatePasswordStrength(password, user.Username); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	hashedPassword, err := utils.HashPassword(password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "密码加密失败",
+		})
+		return
+	}
+
+	// 重置后的密码管理员也知道，用户登录后必须修改
+	if err := tenantDB(c).Model(user).Updates(map[string]interface{}{
+		"password":             hashedPassword,
+		"must_change_password": true,
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "密码重置失败",
+		})
+		return
+	}
+
+	revokeUserTokens(user.ID)
+	recordAudit(c, "user.password_reset", "user", user.ID, gin.H{
+		"generated": generated,
+	})
+
+	data := gin.H{}
+	if generated {
+		data["password"] = password
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "密码重置成功",
+		"data":    data,
+	})
+}
+
+// 删除用户（管理员，软删除）
+func AdminDeleteUser(c *gin.Context) {
+	user, ok := findUser(c, false)
+	if !ok || rejectSelf(c, user) {
+		return
+	}
+
+	if err := tenantDB(c).Delete(user).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "删除用户失败",
+		})
+		return
+	}
+
+	revokeUserTokens(user.ID)
+	recordAudit(c, "user.delete", "user", user.ID, gin.H{
+		"username": user.Username,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "删除成功",
+	})
+}
+
+// 恢复已删除用户（管理员）
+func AdminRestoreUser(c *gin.Context) {
+	user, ok := findUser(c, true)
+	if !ok {
+		return
+	}
+
+	if !user.DeletedAt.Valid {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "用户未被删除",
+		})
+		return
+	}
+	if user.AnonymizedAt != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "该用户已注销并匿名化，无法恢复",
+		})
+		return
+	}
+
+	if err := tenantDB(c).Unscoped().Model(user).Update("deleted_at", nil).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "恢复用户失败",
+		})
+		return
+	}
+
+	recordAudit(c, "user.restore", "user", user.ID, gin.H{
+		"username": user.Username,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "恢复成功",
+	})
+}
+
+type CreateInviteRequest struct {
+	Role          string `json:"role" binding:"required,oneof=student teacher"`
+	MaxUses       int    `json:"max_uses" binding:"min=0"`
+	ExpiresInDays int    `json:"expires_in_days" binding:"min=0"` // 0表示不过期
+	Note          string `json:"note"`
+}
+
+// 注册邀请码列表（管理员）
+func AdminListInvites(c *gin.Context) {
+	var invites []models.RegistrationInvite
+	if err := tenantDB(c).Order("id DESC").Find(&invites).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "获取邀请码失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "获取成功",
+		"data":    invites,
+	})
+}
+
+// 创建注册邀请码（管理员）
+func AdminCreateInvite(c *gin.Context) {
+	var req CreateInviteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "请求参数错误",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	code, err := utils.GenerateRandomString(12)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "生成邀请码失败",
+		})
+		return
+	}
+
+	invite := models.RegistrationInvite{
+		TenantID:  tenantID(c),
+		Code:      code,
+		Role:      models.UserRole(req.Role),
+		MaxUses:   req.MaxUses,
+		CreatedBy: middleware.GetCurrentUserID(c),
+		Note:      req.Note,
+	}
+	if req.ExpiresInDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		invite.ExpiresAt = &expiresAt
+	}
+
+	if err := tenantDB(c).Create(&invite).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "创建邀请码失败",
+		})
+		return
+	}
+
+	recordAudit(c, "invite.create", "registration_invite", invite.ID, gin.H{
+		"role":     invite.Role,
+		"max_uses": invite.MaxUses,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "创建成功",
+		"data":    invite,
+	})
+}
+
+// 作废注册邀请码（管理员）
+func AdminDeleteInvite(c *gin.Context) {
+	var invite models.RegistrationInvite
+	if err := tenantDB(c).First(&invite, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"message": "邀请码不存在",
+		})
+		return
+	}
+
+	if err := tenantDB(c).Delete(&invite).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "作废邀请码失败",
+		})
+		return
+	}
+
+	recordAudit(c, "invite.delete", "registration_invite", invite.ID, nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "已作废",
+	})
+}
+
+// 待审核教师列表（管理员）
+func AdminListPendingTeachers(c *gin.Context) {
+	var users []models.User
+	if err := tenantDB(c).Where("role = ? AND status = ?", models.RoleTeacher, models.UserStatusPending).
+		Order("created_at ASC").
+		Find(&users).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "获取待审核列表失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "获取成功",
+		"data":    users,
+	})
+}
+
+// 审核教师注册申请（管理员），拒绝时账户转为学生
+func AdminReviewTeacher(c *gin.Context) {
+	user, ok := findUser(c, false)
+	if !ok {
+		return
+	}
+
+	if user.Role != models.RoleTeacher || user.Status != models.UserStatusPending {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "该用户不是待审核教师",
+		})
+		return
+	}
+
+	var req struct {
+		Approve bool `json:"approve"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "请求参数错误",
+		})
+		return
+	}
+
+	before := gin.H{"role": user.Role, "status": user.Status}
+	updates := map[string]interface{}{"status": models.UserStatusActive}
+	action := "user.teacher_approve"
+	if !req.Approve {
+		updates["role"] = models.RoleStudent
+		action = "user.teacher_reject"
+	}
+	if err := tenantDB(c).Model(user).Updates(updates).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "审核失败",
+		})
+		return
+	}
+
+	recordAuditChange(c, action, "user", user.ID, before, gin.H{"role": user.Role, "status": user.Status}, nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "审核完成",
+		"data":    user,
+	})
+}
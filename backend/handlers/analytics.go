@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"backend/services"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxAnalyticsDays 学情分析最大统计天数
+const maxAnalyticsDays = 366
+
+// 获取课程学情分析（教师）
+func GetCourseAnalytics(c *gin.Context) {
+	course, ok := findCourse(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	// 默认统计最近30天
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	start, end := today.AddDate(0, 0, -29), today
+	var err error
+	if v := c.Query("start"); v != "" {
+		if start, err = time.ParseInLocation("2006-01-02", v, time.Local); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    400,
+				"message": "开始日期格式错误",
+			})
+			return
+		}
+	}
+	if v := c.Query("end"); v != "" {
+		if end, err = time.ParseInLocation("2006-01-02", v, time.Local); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    400,
+				"message": "结束日期格式错误",
+			})
+			return
+		}
+	}
+	if end.Before(start) || end.Sub(start) > maxAnalyticsDays*24*time.Hour {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "日期范围无效",
+		})
+		return
+	}
+
+	analytics, err := services.NewAnalyticsService().CourseAnalytics(course.ID, start, end, c.Query("refresh") == "1")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "获取学情分析失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "获取成功",
+		"data":    analytics,
+	})
+}
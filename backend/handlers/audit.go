@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"backend/middleware"
+	"backend/models"
+	"backend/services"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const auditUserAgentMaxLen = 255
+
+// 构造带有当前请求信息的审计日志，操作人为当前登录用户
+func newAuditEntry(c *gin.Context, action, targetType string, targetID uint) models.AuditLog {
+	userAgent := c.Request.UserAgent()
+	for len(userAgent) > auditUserAgentMaxLen {
+		_, size := utf8.DecodeLastRuneInString(userAgent)
+		userAgent = userAgent[:len(userAgent)-size]
+	}
+	return models.AuditLog{
+		TenantID:   tenantID(c),
+		ActorID:    c.GetUint("user_id"),
+		ActorName:  c.GetString("username"),
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		IP:         c.ClientIP(),
+		UserAgent:  userAgent,
+		RequestID:  middleware.GetRequestID(c),
+	}
+}
+
+// 记录当前用户的操作审计日志
+func recordAudit(c *gin.Context, action, targetType string, targetID uint, detail interface{}) {
+	services.NewAuditService().Record(newAuditEntry(c, action, targetType, targetID), detail)
+}
+
+// 记录修改类操作，before和after对比出变更字段；没有任何变化且没有补充详情时不记录
+func recordAuditChange(c *gin.Context, action, targetType string, targetID uint, before, after, detail interface{}) {
+	auditService := services.NewAuditService()
+	changes := auditService.Diff(before, after)
+	if len(changes) == 0 && detail == nil {
+		return
+	}
+
+	entry := newAuditEntry(c, action, targetType, targetID)
+	if data, err := json.Marshal(changes); err == nil {
+		entry.Changes = string(data)
+	}
+	auditService.Record(entry, detail)
+}
+
+// 记录登录相关操作，此时请求上下文中还没有用户信息；登录失败时user为nil，只记录尝试的用户名
+func recordLoginAudit(c *gin.Context, user *models.User, username, action string, detail interface{}) {
+	entry := newAuditEntry(c, action, "user", 0)
+	entry.ActorName = username
+	if user != nil {
+		entry.ActorID = user.ID
+		entry.ActorName = user.Username
+		entry.TargetID = user.ID
+	}
+	services.NewAuditService().Record(entry, detail)
+}
+
+// 按查询参数构造审计日志筛选条件，结果限定在当前学校
+func auditLogQuery(c *gin.Context) (*gorm.DB, error) {
+	query := tenantDB(c).Model(&models.AuditLog{})
+
+	if actorID := c.Query("actor_id"); actorID != "" {
+		id, err := strconv.ParseUint(actorID, 10, 64)
+		if err != nil {
+			return nil, errors.New("actor_id格式错误")
+		}
+		query = query.Where("actor_id = ?", id)
+	}
+	if action := c.Query("action"); action != "" {
+		// 以*结尾时按前缀匹配，如 user.* 匹配所有用户相关操作
+		if prefix, ok := strings.CutSuffix(action, "*"); ok {
+			query = query.Where("action LIKE ?", prefix+"%")
+		} else {
+			query = query.Where("action = ?", action)
+		}
+	}
+	if targetType := c.Query("target_type"); targetType != "" {
+		query = query.Where("target_type = ?", targetType)
+	}
+	if targetID := c.Query("target_id"); targetID != "" {
+		id, err := strconv.ParseUint(targetID, 10, 64)
+		if err != nil {
+			return nil, errors.New("target_id格式错误")
+		}
+		query = query.Where("target_id = ?", id)
+	}
+	if requestID := c.Query("request_id"); requestID != "" {
+		query = query.Where("request_id = ?", requestID)
+	}
+	if ip := c.Query("ip"); ip != "" {
+		query = query.Where("ip = ?", ip)
+	}
+	if start := c.Query("start"); start != "" {
+		t, err := parseAuditTime(start, false)
+		if err != nil {
+			return nil, errors.New("start格式错误，应为2006-01-02或RFC3339时间")
+		}
+		query = query.Where("created_at >= ?", t)
+	}
+	if end := c.Query("end"); end != "" {
+		t, err := parseAuditTime(end, true)
+		if err != nil {
+			return nil, errors.New("end格式错误，应为2006-01-02或RFC3339时间")
+		}
+		query = query.Where("created_at < ?", t)
+	}
+	if keyword := c.Query("keyword"); keyword != "" {
+		like := "%" + keyword + "%"
+		query = query.Where("actor_name LIKE ? OR detail LIKE ? OR changes LIKE ?", like, like, like)
+	}
+	return query, nil
+}
+
+// 解析时间参数，只给日期时结束时间取当天结束
+func parseAuditTime(value string, end bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return t, err
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// 查询审计日志（管理员）
+func AdminListAuditLogs(c *gin.Context) {
+	page, pageSize := getPagination(c)
+
+	query, err := auditLogQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "获取审计日志失败",
+		})
+		return
+	}
+
+	var logs []models.AuditLog
+	if err := query.Order("id DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&logs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "获取审计日志失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "获取成功",
+		"data": gin.H{
+			"list":      logs,
+			"total":     total,
+			"page":      page,
+			"page_size": pageSize,
+		},
+	})
+}
+
+// 导出审计日志（管理员），筛选条件与查询接口相同
+func AdminExportAuditLogs(c *gin.Context) {
+	format := c.DefaultQuery("format", "csv")
+	contentTypes := map[string]string{
+		"csv":  "text/csv; charset=utf-8",
+		"xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	}
+	contentType, ok := contentTypes[format]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": services.ErrAuditExportFormat.Error(),
+		})
+		return
+	}
+
+	query, err := auditLogQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "导出审计日志失败",
+		})
+		return
+	}
+	if total > services.AuditExportLimit {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": fmt.Sprintf("符合条件的日志共%d条，单次最多导出%d条，请缩小时间范围", total, services.AuditExportLimit),
+		})
+		return
+	}
+
+	var logs []models.AuditLog
+	if err := query.Order("id DESC").Find(&logs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "导出审计日志失败",
+		})
+		return
+	}
+
+	// 导出本身也是需要留痕的操作
+	recordAudit(c, "audit.export", "audit_log", 0, gin.H{
+		"format": format,
+		"count":  len(logs),
+		"filter": c.Request.URL.RawQuery,
+	})
+
+	filename := fmt.Sprintf("audit-logs-%s.%s", time.Now().Format("20060102150405"), format)
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	if err := services.NewAuditService().Export(c.Writer, format, logs); err != nil {
+		log.Printf("导出审计日志失败: %v", err)
+	}
+}
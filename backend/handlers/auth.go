@@ -1,13 +1,20 @@
 package handlers
 
 import (
-	"backend/database"
+	"backend/config"
 	"backend/models"
+	"backend/services"
 	"backend/utils"
+	"errors"
+	"fmt"
 	"log"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 type LoginRequest struct {
@@ -15,12 +22,17 @@ type LoginRequest struct {
 	Password string `json:"password" binding:"required"`
 }
 
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
 type RegisterRequest struct {
-	Username string `json:"username" binding:"required"`
-	Password string `json:"password" binding:"required"`
-	Email    string `json:"email" binding:"required,email"`
-	RealName string `json:"real_name" binding:"required"`
-	Role     string `json:"role" binding:"required"`
+	Username   string `json:"username" binding:"required"`
+	Password   string `json:"password" binding:"required"`
+	Email      string `json:"email" binding:"required,email"`
+	RealName   string `json:"real_name" binding:"required"`
+	Role       string `json:"role"`        // 为空时注册为学生
+	InviteCode string `json:"invite_code"` // 注册邀请码，决定账户角色
 }
 
 // 用户登录
@@ -35,36 +47,95 @@ func Login(c *gin.Context) {
 		return
 	}
 
-	// 查找用户
-	var user models.User
-	if err := database.DB.Where("username = ?", req.Username).First(&user).Error; err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"code":    401,
-			"message": "用户名或密码错误",
-		})
+	// 登录失败次数过多时临时锁定，之前有失败记录的逐次增加延迟
+	guard := services.NewLoginGuardService()
+	ip := c.ClientIP()
+	if remaining := guard.LockedFor(tenantID(c), req.Username, ip); remaining > 0 {
+		respondLoginLocked(c, remaining)
 		return
 	}
+	time.Sleep(guard.Delay(tenantID(c), req.Username))
 
-	// 验证密码
-	if !utils.CheckPassword(req.Password, user.Password) {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"code":    401,
-			"message": "用户名或密码错误",
-		})
+	// 查找用户并验证密码
+	user, err := authenticatePassword(c, req.Username, req.Password)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidCredentials):
+			locked := guard.RecordFailure(tenantID(c), req.Username, ip)
+			recordLoginAudit(c, nil, req.Username, "auth.login_failed", gin.H{"reason": "invalid_credentials", "locked": locked})
+			if locked {
+				respondLoginLocked(c, guard.LockedFor(tenantID(c), req.Username, ip))
+				return
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"code":    401,
+				"message": "用户名或密码错误",
+			})
+		case errors.Is(err, services.ErrExternalAccountNotFound),
+			errors.Is(err, services.ErrExternalAccountNoEmail),
+			errors.Is(err, services.ErrExternalEmailConflict):
+			recordLoginAudit(c, nil, req.Username, "auth.login_failed", gin.H{"reason": err.Error()})
+			c.JSON(http.StatusForbidden, gin.H{
+				"code":    403,
+				"message": err.Error(),
+			})
+		default:
+			log.Printf("用户%s登录认证失败: %v", req.Username, err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"code":    503,
+				"message": "身份认证服务暂不可用，请稍后再试",
+			})
+		}
 		return
 	}
 
 	// 检查用户状态
-	if user.Status == 0 {
+	if user.Status == models.UserStatusDisabled {
+		recordLoginAudit(c, user, req.Username, "auth.login_failed", gin.H{"reason": "disabled"})
 		c.JSON(http.StatusForbidden, gin.H{
 			"code":    403,
 			"message": "账户已被禁用",
 		})
 		return
 	}
+	if user.Status == models.UserStatusPending {
+		recordLoginAudit(c, user, req.Username, "auth.login_failed", gin.H{"reason": "pending"})
+		c.JSON(http.StatusForbidden, gin.H{
+			"code":    403,
+			"message": "账户待管理员审核",
+		})
+		return
+	}
+
+	// 已启用或角色要求双因素认证时，先返回登录挑战
+	if startTwoFactorChallenge(c, user) {
+		return
+	}
+
+	// 需要双因素认证时在第二步验证通过后才清除失败记录
+	guard.Reset(tenantID(c), req.Username)
+	respondLoginSuccess(c, user, nil)
+}
+
+// 验证用户名和密码，学校使用LDAP时由目录服务验证
+func authenticatePassword(c *gin.Context, username, password string) (*models.User, error) {
+	ldapService := services.NewLDAPService()
+	if ldapService.EnabledFor(tenantID(c)) {
+		return ldapService.Login(tenantID(c), username, password)
+	}
 
-	// 生成JWT token
-	token, err := utils.GenerateToken(&user)
+	var user models.User
+	// 服务账户只能使用API密钥，不能登录
+	if err := tenantDB(c).Where("username = ? AND role <> ?", username, models.RoleService).First(&user).Error; err != nil ||
+		!utils.CheckPassword(password, user.Password) {
+		return nil, services.ErrInvalidCredentials
+	}
+	return &user, nil
+}
+
+// 创建登录会话并返回令牌和用户信息，extra中的字段会一并返回
+func respondLoginSuccess(c *gin.Context, user *models.User, extra gin.H) {
+	tokens, err := services.NewTokenService().IssueTokens(user, sessionMeta(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"code":    500,
@@ -73,20 +144,41 @@ func Login(c *gin.Context) {
 		return
 	}
 
+	data := gin.H{
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
+		"user": gin.H{
+			"id":        user.ID,
+			"username":  user.Username,
+			"email":     user.Email,
+			"real_name": user.RealName,
+			"role":      user.Role,
+			"avatar":    user.Avatar,
+		},
+	}
+	for k, v := range extra {
+		data[k] = v
+	}
+	recordLoginAudit(c, user, user.Username, "auth.login", nil)
+
 	c.JSON(http.StatusOK, gin.H{
 		"code":    200,
 		"message": "登录成功",
-		"data": gin.H{
-			"token": token,
-			"user": gin.H{
-				"id":        user.ID,
-				"username":  user.Username,
-				"email":     user.Email,
-				"real_name": user.RealName,
-				"role":      user.Role,
-				"avatar":    user.Avatar,
-			},
-		},
+		"data":    data,
+	})
+}
+
+// 登录被锁定时返回429及剩余锁定时间
+func respondLoginLocked(c *gin.Context, remaining time.Duration) {
+	minutes := int(math.Ceil(remaining.Minutes()))
+	if minutes < 1 {
+		minutes = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(remaining.Seconds()))))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"code":    429,
+		"message": fmt.Sprintf("登录失败次数过多，请%d分钟后再试", minutes),
 	})
 }
 
@@ -103,9 +195,53 @@ func Register(c *gin.Context) {
 		return
 	}
 
+	policy := config.GlobalConfig.Register.Policy
+	if policy == config.RegisterPolicyClosed {
+		c.JSON(http.StatusForbidden, gin.H{
+			"code":    403,
+			"message": "注册已关闭",
+		})
+		return
+	}
+
+	// 自助注册不允许注册管理员
+	role := models.RoleStudent
+	if req.Role != "" {
+		role = models.UserRole(req.Role)
+	}
+	if !role.IsValid() || role.IsAdmin() {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "角色无效",
+		})
+		return
+	}
+
+	// 有邀请码时以邀请码的角色为准；没有邀请码注册教师需要管理员审核
+	status := models.UserStatusActive
+	var invite models.RegistrationInvite
+	if req.InviteCode != "" {
+		if err := tenantDB(c).Where("code = ?", req.InviteCode).First(&invite).Error; err != nil || !inviteUsable(&invite) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    400,
+				"message": "邀请码无效或已过期",
+			})
+			return
+		}
+		role = invite.Role
+	} else if policy == config.RegisterPolicyInviteOnly {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "注册需要邀请码",
+		})
+		return
+	} else if role == models.RoleTeacher {
+		status = models.UserStatusPending
+	}
+
 	// 检查用户名是否已存在
 	var existingUser models.User
-	if err := database.DB.Where("username = ?", req.Username).First(&existingUser).Error; err == nil {
+	if err := tenantDB(c).Where("username = ?", req.Username).First(&existingUser).Error; err == nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"code":    400,
 			"message": "用户名已存在",
@@ -115,7 +251,7 @@ func Register(c *gin.Context) {
 	}
 
 	// 检查邮箱是否已存在
-	if err := database.DB.Where("email = ?", req.Email).First(&existingUser).Error; err == nil {
+	if err := tenantDB(c).Where("email = ?", req.Email).First(&existingUser).Error; err == nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"code":    400,
 			"message": "邮箱已存在",
@@ -123,6 +259,14 @@ func Register(c *gin.Context) {
 		return
 	}
 
+	if err := utils.ValidatePasswordStrength(req.Password, req.Username); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": err.Error(),
+		})
+		return
+	}
+
 	// 加密密码
 	hashedPassword, err := utils.HashPassword(req.Password)
 	if err != nil {
@@ -133,17 +277,47 @@ func Register(c *gin.Context) {
 		return
 	}
 
+	if err := services.NewTenantService().CheckUserQuota(tenantID(c), 1); err != nil {
+		respondTenantError(c, err, "注册失败")
+		return
+	}
+
 	// 创建用户
 	user := models.User{
+		TenantID: tenantID(c),
 		Username: req.Username,
 		Password: hashedPassword,
 		Email:    req.Email,
 		RealName: req.RealName,
-		Role:     models.UserRole(req.Role),
-		Status:   1,
+		Role:     role,
+		Status:   status,
+		// 自助注册填写的邮箱未经验证
+		EmailUnverified: true,
 	}
 
-	if err := database.DB.Create(&user).Error; err != nil {
+	err = tenantDB(c).Transaction(func(tx *gorm.DB) error {
+		if invite.ID != 0 {
+			// 并发注册时以条件更新保证邀请码不会超过使用次数
+			result := tx.Model(&models.RegistrationInvite{}).
+				Where("id = ? AND (max_uses = 0 OR used_count < max_uses)", invite.ID).
+				Update("used_count", gorm.Expr("used_count + 1"))
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return errInviteExhausted
+			}
+		}
+		return tx.Create(&user).Error
+	})
+	if errors.Is(err, errInviteExhausted) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "邀请码无效或已过期",
+		})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"code":    500,
 			"message": "用户创建失败",
@@ -151,25 +325,41 @@ func Register(c *gin.Context) {
 		return
 	}
 
+	message := "注册成功"
+	if status == models.UserStatusPending {
+		message = "注册成功，教师账户需等待管理员审核"
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"code":    200,
-		"message": "注册成功",
+		"message": message,
 		"data": gin.H{
 			"id":        user.ID,
 			"username":  user.Username,
 			"email":     user.Email,
 			"real_name": user.RealName,
 			"role":      user.Role,
+			"status":    user.Status,
 		},
 	})
 }
 
+var errInviteExhausted = errors.New("invite exhausted")
+
+// 邀请码是否仍可使用
+func inviteUsable(invite *models.RegistrationInvite) bool {
+	if invite.ExpiresAt != nil && invite.ExpiresAt.Before(time.Now()) {
+		return false
+	}
+	return invite.MaxUses == 0 || invite.UsedCount < invite.MaxUses
+}
+
 // 获取当前用户信息
 func GetCurrentUser(c *gin.Context) {
 	userID := c.GetUint("user_id")
 
 	var user models.User
-	if err := database.DB.First(&user, userID).Error; err != nil {
+	if err := tenantDB(c).First(&user, userID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{
 			"code":    404,
 			"message": "用户不存在",
@@ -177,6 +367,12 @@ func GetCurrentUser(c *gin.Context) {
 		return
 	}
 
+	var profile *models.UserProfile
+	var p models.UserProfile
+	if err := tenantDB(c).Where("user_id = ?", user.ID).First(&p).Error; err == nil {
+		profile = &p
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"code":    200,
 		"message": "获取成功",
@@ -189,6 +385,7 @@ func GetCurrentUser(c *gin.Context) {
 			"avatar":    user.Avatar,
 			"phone":     user.Phone,
 			"status":    user.Status,
+			"profile":   profile,
 		},
 	})
 }
@@ -199,7 +396,7 @@ func ChangePassword(c *gin.Context) {
 
 	var req struct {
 		OldPassword string `json:"old_password" binding:"required"`
-		NewPassword string `json:"new_password" binding:"required,min=6"`
+		NewPassword string `json:"new_password" binding:"required"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -212,7 +409,7 @@ func ChangePassword(c *gin.Context) {
 
 	// 获取用户信息
 	var user models.User
-	if err := database.DB.First(&user, userID).Error; err != nil {
+	if err := tenantDB(c).First(&user, userID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{
 			"code":    404,
 			"message": "用户不存在",
@@ -220,6 +417,14 @@ func ChangePassword(c *gin.Context) {
 		return
 	}
 
+	if services.NewLDAPService().ManagesPassword(&user) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": services.ErrDirectoryManagedPassword.Error(),
+		})
+		return
+	}
+
 	// 验证旧密码
 	if !utils.CheckPassword(req.OldPassword, user.Password) {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -229,6 +434,14 @@ func ChangePassword(c *gin.Context) {
 		return
 	}
 
+	if err := utils.ValidatePasswordStrength(req.NewPassword, user.Username); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": err.Error(),
+		})
+		return
+	}
+
 	// 加密新密码
 	hashedPassword, err := utils.HashPassword(req.NewPassword)
 	if err != nil {
@@ -239,8 +452,11 @@ func ChangePassword(c *gin.Context) {
 		return
 	}
 
-	// 更新密码
-	if err := database.DB.Model(&user).Update("password", hashedPassword).Error; err != nil {
+	// 更新密码，同时解除首次登录必须修改密码的限制
+	if err := tenantDB(c).Model(&user).Updates(map[string]interface{}{
+		"password":             hashedPassword,
+		"must_change_password": false,
+	}).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"code":    500,
 			"message": "密码更新失败",
@@ -248,8 +464,95 @@ func ChangePassword(c *gin.Context) {
 		return
 	}
 
+	recordAudit(c, "user.password_change", "user", user.ID, nil)
+
+	// 注销所有已登录会话，并为当前设备重新签发令牌
+	tokenService := services.NewTokenService()
+	if err := tokenService.RevokeAll(user.ID); err != nil {
+		// 无法确认旧会话已注销，不签发新令牌，由客户端重新登录
+		log.Printf("注销用户%d的登录会话失败: %v", user.ID, err)
+		c.JSON(http.StatusOK, gin.H{
+			"code":    200,
+			"message": "密码修改成功，请重新登录",
+		})
+		return
+	}
+	user.TokenVersion++
+	user.MustChangePassword = false
+	tokens, err := tokenService.IssueTokens(&user, sessionMeta(c))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"code":    200,
+			"message": "密码修改成功，请重新登录",
+		})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"code":    200,
 		"message": "密码修改成功",
+		"data":    tokens,
+	})
+}
+
+// 刷新访问令牌，同时轮换刷新令牌
+func RefreshToken(c *gin.Context) {
+	var req RefreshTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "请求参数错误",
+		})
+		return
+	}
+
+	tokens, err := services.NewTokenService().Refresh(req.RefreshToken, sessionMeta(c))
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidRefreshToken) || errors.Is(err, services.ErrTokenRevoked) {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"code":    401,
+				"message": err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "刷新token失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "刷新成功",
+		"data":    tokens,
 	})
 }
+
+// 退出登录，注销当前会话
+func Logout(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	sessionID := c.GetString("session_id")
+
+	if err := services.NewTokenService().RevokeSession(userID, sessionID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "退出登录失败",
+		})
+		return
+	}
+	recordAudit(c, "auth.logout", "user", userID, nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "已退出登录",
+	})
+}
+
+// 当前请求的客户端信息，记录到登录会话中
+func sessionMeta(c *gin.Context) services.SessionMeta {
+	return services.SessionMeta{
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	}
+}
@@ -1,13 +1,15 @@
 package handlers
 
 import (
-	"backend/database"
 	"backend/middleware"
 	"backend/models"
 	"backend/services"
 	"backend/utils"
+	"errors"
 	"log"
 	"net/http"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -38,16 +40,37 @@ func CreateChatSession(c *gin.Context) {
 		return
 	}
 
+	// 关联章节时以章节所属课程为准
+	if req.ChapterID != nil {
+		var chapter models.Chapter
+		if err := tenantDB(c).First(&chapter, *req.ChapterID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"code":    404,
+				"message": "章节不存在",
+			})
+			return
+		}
+		req.CourseID = &chapter.CourseID
+	}
+	if req.CourseID != nil && !requireCourseAccess(c, *req.CourseID) {
+		return
+	}
+
 	session := models.ChatSession{
 		UserID:    userID,
-		Title:     req.Title,
+		Title:     strings.TrimSpace(req.Title),
 		Type:      req.Type,
 		CourseID:  req.CourseID,
 		ChapterID: req.ChapterID,
 		Status:    1,
 	}
+	// 未填写标题时先使用默认标题，第一轮对话后自动生成
+	if session.Title == "" {
+		session.Title = models.DefaultChatTitle
+		session.AutoTitle = true
+	}
 
-	if err := database.DB.Create(&session).Error; err != nil {
+	if err := tenantDB(c).Create(&session).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"code":    500,
 			"message": "创建会话失败",
@@ -62,13 +85,21 @@ func CreateChatSession(c *gin.Context) {
 	})
 }
 
-// 获取聊天会话列表
+// 获取聊天会话列表，可按主题标签或关联的知识点筛选
 func GetChatSessions(c *gin.Context) {
 	userID := middleware.GetCurrentUserID(c)
 
+	query := tenantDB(c).Where("user_id = ?", userID)
+	if tag := strings.TrimSpace(c.Query("tag")); tag != "" {
+		query = query.Where("FIND_IN_SET(?, tags) > 0", tag)
+	}
+	if knowledgeID := c.Query("knowledge_id"); knowledgeID != "" {
+		query = query.Where("id IN (?)", tenantDB(c).Table("chat_session_knowledge").
+			Select("chat_session_id").Where("knowledge_id = ?", parseUint(knowledgeID)))
+	}
+
 	var sessions []models.ChatSession
-	if err := database.DB.Preload("Course").Preload("Chapter").
-		Where("user_id = ?", userID).
+	if err := query.Preload("Course").Preload("Chapter").Preload("Knowledge").
 		Order("updated_at DESC").
 		Find(&sessions).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -91,7 +122,7 @@ func GetChatSession(c *gin.Context) {
 	userID := middleware.GetCurrentUserID(c)
 
 	var session models.ChatSession
-	if err := database.DB.Preload("Course").Preload("Chapter").Preload("Messages").
+	if err := tenantDB(c).Preload("Course").Preload("Chapter").
 		Where("id = ? AND user_id = ?", sessionID, userID).
 		First(&session).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{
@@ -100,6 +131,9 @@ func GetChatSession(c *gin.Context) {
 		})
 		return
 	}
+	if !loadActiveMessages(c, &session) {
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"code":    200,
@@ -124,7 +158,7 @@ func SendMessage(c *gin.Context) {
 
 	// 验证会话是否存在且属于当前用户
 	var session models.ChatSession
-	if err := database.DB.Preload("Course").Preload("Chapter").
+	if err := tenantDB(c).Preload("Course").Preload("Chapter").
 		Where("id = ? AND user_id = ?", sessionID, userID).
 		First(&session).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{
@@ -134,15 +168,18 @@ func SendMessage(c *gin.Context) {
 		return
 	}
 
-	// 保存用户消息
+	if session.CourseID != nil && !requireCourseAccess(c, *session.CourseID) {
+		return
+	}
+
+	// 保存用户消息，接在当前分支末尾
 	userMessage := models.ChatMessage{
-		SessionID:   session.ID,
-		Role:        "user",
+		Role:        models.ChatRoleUser,
 		Content:     req.Content,
 		MessageType: "text",
 	}
 
-	if err := database.DB.Create(&userMessage).Error; err != nil {
+	if err := services.NewChatService().Append(&session, &userMessage); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"code":    500,
 			"message": "保存消息失败",
@@ -150,49 +187,103 @@ func SendMessage(c *gin.Context) {
 		return
 	}
 
+	aiMessage, ok := generateAIReply(c, &session, &userMessage)
+	if !ok {
+		return
+	}
+	// 第一轮对话完成后在后台生成标题和标签
+	services.NewChatService().SummarizeAsync(tenantID(c), &session, userMessage.Content, aiMessage.Content)
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "消息发送成功",
+		"data": gin.H{
+			"user_message": userMessage,
+			"ai_message":   aiMessage,
+		},
+	})
+}
+
+// 为提问生成AI回复并作为它的子消息保存，失败时直接返回错误响应
+func generateAIReply(c *gin.Context, session *models.ChatSession, question *models.ChatMessage) (*models.ChatMessage, bool) {
 	// 获取相关知识库
 	var knowledgeBase []models.KnowledgeBase
 	if session.CourseID != nil {
-		database.DB.Where("type = ? OR type = ?", "course", "general").Find(&knowledgeBase)
+		tenantDB(c).Where("type = ? OR type = ?", "course", "general").Find(&knowledgeBase)
 	} else {
-		database.DB.Where("type = ?", "general").Find(&knowledgeBase)
+		tenantDB(c).Where("type = ?", "general").Find(&knowledgeBase)
 	}
 
 	// 调用AI服务生成回复
-	aiService := services.NewAIService()
-	aiResponse, err := aiService.ChatWithAI(&session, req.Content, knowledgeBase)
+	aiService, err := tenantAIService(c)
+	if err != nil {
+		respondTenantError(c, err, "AI服务暂不可用")
+		return nil, false
+	}
+	aiResponse, err := aiService.ChatWithAI(session, question.Content, knowledgeBase)
 	if err != nil {
 		log.Printf("AI服务调用失败: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"code":    500,
 			"message": "AI回复生成失败: " + err.Error(),
 		})
-		return
+		return nil, false
 	}
 
 	// 保存AI回复
 	aiMessage := models.ChatMessage{
-		SessionID:   session.ID,
-		Role:        "assistant",
+		ParentID:    &question.ID,
+		Role:        models.ChatRoleAssistant,
 		Content:     aiResponse,
 		MessageType: "text",
 	}
 
-	if err := database.DB.Create(&aiMessage).Error; err != nil {
+	if err := services.NewChatService().Append(session, &aiMessage); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"code":    500,
 			"message": "保存AI回复失败",
 		})
+		return nil, false
+	}
+	return &aiMessage, true
+}
+
+// 当前用户会话中出现过的主题标签及对应的会话数，按会话数排序
+func GetChatTags(c *gin.Context) {
+	var tagColumns []string
+	if err := tenantDB(c).Model(&models.ChatSession{}).
+		Where("user_id = ? AND tags <> ''", middleware.GetCurrentUserID(c)).
+		Pluck("tags", &tagColumns).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "获取标签失败",
+		})
 		return
 	}
 
+	counts := map[string]int{}
+	for _, tags := range tagColumns {
+		for _, tag := range strings.Split(tags, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				counts[tag]++
+			}
+		}
+	}
+	list := make([]gin.H, 0, len(counts))
+	for tag, count := range counts {
+		list = append(list, gin.H{"tag": tag, "count": count})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i]["count"].(int) != list[j]["count"].(int) {
+			return list[i]["count"].(int) > list[j]["count"].(int)
+		}
+		return list[i]["tag"].(string) < list[j]["tag"].(string)
+	})
+
 	c.JSON(http.StatusOK, gin.H{
 		"code":    200,
-		"message": "消息发送成功",
-		"data": gin.H{
-			"user_message": userMessage,
-			"ai_message":   aiMessage,
-		},
+		"message": "获取成功",
+		"data":    list,
 	})
 }
 
@@ -203,7 +294,7 @@ func DeleteChatSession(c *gin.Context) {
 
 	// 验证会话是否存在且属于当前用户
 	var session models.ChatSession
-	if err := database.DB.Where("id = ? AND user_id = ?", sessionID, userID).First(&session).Error; err != nil {
+	if err := tenantDB(c).Where("id = ? AND user_id = ?", sessionID, userID).First(&session).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{
 			"code":    404,
 			"message": "会话不存在",
@@ -212,13 +303,14 @@ func DeleteChatSession(c *gin.Context) {
 	}
 
 	// 删除会话及其消息
-	if err := database.DB.Delete(&session).Error; err != nil {
+	if err := tenantDB(c).Delete(&session).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"code":    500,
 			"message": "删除会话失败",
 		})
 		return
 	}
+	recordAudit(c, "chat.session_delete", "chat_session", session.ID, gin.H{"title": session.Title})
 
 	c.JSON(http.StatusOK, gin.H{
 		"code":    200,
@@ -232,7 +324,7 @@ func GetLearningAdvice(c *gin.Context) {
 
 	// 获取用户学习进度
 	var progress []models.LearningProgress
-	if err := database.DB.Preload("Course").Preload("Chapter").
+	if err := tenantDB(c).Preload("Course").Preload("Chapter").
 		Where("user_id = ?", userID).
 		Find(&progress).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -244,7 +336,7 @@ func GetLearningAdvice(c *gin.Context) {
 
 	// 获取用户答题情况
 	var answers []models.StudentAnswer
-	if err := database.DB.Preload("Question").
+	if err := tenantDB(c).Preload("Question").
 		Where("user_id = ?", userID).
 		Find(&answers).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -254,9 +346,23 @@ func GetLearningAdvice(c *gin.Context) {
 		return
 	}
 
+	// 获取知识点掌握度
+	masteries, err := services.NewMasteryService().GetUserMastery(userID, 0)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "获取知识点掌握度失败",
+		})
+		return
+	}
+
 	// 调用AI服务生成学习建议
-	aiService := services.NewAIService()
-	advice, err := aiService.GenerateLearningAdvice(userID, progress, answers)
+	aiService, err := tenantAIService(c)
+	if err != nil {
+		respondTenantError(c, err, "AI服务暂不可用")
+		return
+	}
+	advice, err := aiService.GenerateLearningAdvice(userID, progress, answers, masteries)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"code":    500,
@@ -291,6 +397,12 @@ func StreamAIChat(c *gin.Context) {
 	}
 	// 2. 校验token，获取用户ID
 	claims, err := utils.ParseToken(token)
+	if err == nil {
+		err = services.NewTokenService().ValidateAccess(claims)
+	}
+	if err == nil && claims.TenantID != middleware.GetCurrentTenantID(c) {
+		err = services.ErrTokenRevoked
+	}
 	if err != nil {
 		c.SSEvent("error", "无效token")
 		c.Writer.Flush()
@@ -312,7 +424,16 @@ func StreamAIChat(c *gin.Context) {
 		return
 	}
 
-	ai := services.NewAIService()
+	ai, err := tenantAIService(c)
+	if err != nil {
+		message := "AI服务暂不可用"
+		if errors.Is(err, services.ErrAIQuotaExceeded) {
+			message = err.Error()
+		}
+		c.SSEvent("error", message)
+		c.Writer.Flush()
+		return
+	}
 	if ai.Xunfei == nil {
 		c.SSEvent("error", "AI服务未配置")
 		return
@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"backend/middleware"
+	"backend/models"
+	"backend/services"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type EditMessageRequest struct {
+	Content string `json:"content" binding:"required"`
+}
+
+type SwitchChatBranchRequest struct {
+	MessageID uint `json:"message_id" binding:"required"`
+}
+
+// 把会话的Messages替换为当前分支上的消息，失败时直接返回500
+func loadActiveMessages(c *gin.Context, session *models.ChatSession) bool {
+	messages, err := services.NewChatService().ActivePath(session)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "获取会话消息失败",
+		})
+		return false
+	}
+	session.Messages = messages
+	return true
+}
+
+// 查找当前用户自己的会话，不存在时直接返回404
+func findMyChatSession(c *gin.Context, sessionID string) (*models.ChatSession, bool) {
+	var session models.ChatSession
+	if err := tenantDB(c).Preload("Course").Preload("Chapter").
+		Where("id = ? AND user_id = ?", sessionID, middleware.GetCurrentUserID(c)).
+		First(&session).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"message": "会话不存在",
+		})
+		return nil, false
+	}
+	return &session, true
+}
+
+// 重新生成当前分支最后一条AI回复，新回复与原回复并列为同一提问下的分支
+func RegenerateReply(c *gin.Context) {
+	session, ok := findMyChatSession(c, c.Param("sessionId"))
+	if !ok {
+		return
+	}
+	if session.CourseID != nil && !requireCourseAccess(c, *session.CourseID) {
+		return
+	}
+
+	question, err := services.NewChatService().RegenerateTarget(session)
+	if err != nil {
+		if errors.Is(err, services.ErrChatNothingToRegenerate) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    400,
+				"message": err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "重新生成失败",
+		})
+		return
+	}
+
+	aiMessage, ok := generateAIReply(c, session, question)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "已重新生成",
+		"data": gin.H{
+			"user_message": question,
+			"ai_message":   aiMessage,
+		},
+	})
+}
+
+// 编辑自己的提问并重新回答，编辑后的提问作为原提问的兄弟分支，原对话保留在消息树中
+func EditMessage(c *gin.Context) {
+	var req EditMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "请求参数错误",
+		})
+		return
+	}
+
+	session, ok := findMyChatSession(c, c.Param("sessionId"))
+	if !ok {
+		return
+	}
+	if session.CourseID != nil && !requireCourseAccess(c, *session.CourseID) {
+		return
+	}
+
+	var original models.ChatMessage
+	if err := tenantDB(c).Where("id = ? AND session_id = ?", c.Param("messageId"), session.ID).
+		First(&original).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"message": "消息不存在",
+		})
+		return
+	}
+
+	userMessage, err := services.NewChatService().BranchFrom(session, &original, req.Content)
+	if err != nil {
+		if errors.Is(err, services.ErrChatMessageNotEditable) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    400,
+				"message": err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "保存消息失败",
+		})
+		return
+	}
+
+	aiMessage, ok := generateAIReply(c, session, userMessage)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "消息发送成功",
+		"data": gin.H{
+			"user_message": userMessage,
+			"ai_message":   aiMessage,
+		},
+	})
+}
+
+// 获取会话的完整消息树，包括重新生成和编辑产生的所有分支
+func GetChatMessageTree(c *gin.Context) {
+	session, ok := findMyChatSession(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	tree, err := services.NewChatService().Tree(session.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "获取消息树失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "获取成功",
+		"data": gin.H{
+			"current_message_id": session.CurrentMessageID,
+			"tree":               tree,
+		},
+	})
+}
+
+// 切换到指定消息所在的分支，当前分支延伸到该消息下最新的一条消息
+func SwitchChatBranch(c *gin.Context) {
+	var req SwitchChatBranchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "请求参数错误",
+		})
+		return
+	}
+
+	session, ok := findMyChatSession(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	var message models.ChatMessage
+	if err := tenantDB(c).Where("id = ? AND session_id = ?", req.MessageID, session.ID).First(&message).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"message": "消息不存在",
+		})
+		return
+	}
+
+	chatService := services.NewChatService()
+	leaf, err := chatService.LatestLeaf(session.ID, message.ID)
+	if err == nil {
+		err = chatService.SetCurrent(session, leaf)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "切换分支失败",
+		})
+		return
+	}
+	if !loadActiveMessages(c, session) {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "切换成功",
+		"data":    session,
+	})
+}
@@ -0,0 +1,322 @@
+package handlers
+
+import (
+	"backend/middleware"
+	"backend/models"
+	"backend/services"
+	"backend/utils"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const chatShareTokenLength = 24
+
+type ShareChatSessionRequest struct {
+	ShareWithTeacher *bool `json:"share_with_teacher"`
+	ShareLink        *bool `json:"share_link"`
+}
+
+type TeacherReplyRequest struct {
+	Content string `json:"content" binding:"required,max=5000"`
+}
+
+// 分享或取消分享聊天会话：分享给课程教师，或生成本校内可查看的只读链接
+func ShareChatSession(c *gin.Context) {
+	userID := middleware.GetCurrentUserID(c)
+
+	var req ShareChatSessionRequest
+	if err := c.ShouldBindJSON(&req); err != nil || (req.ShareWithTeacher == nil && req.ShareLink == nil) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "请求参数错误",
+		})
+		return
+	}
+
+	var session models.ChatSession
+	if err := tenantDB(c).Preload("Course").Where("id = ? AND user_id = ?", c.Param("id"), userID).
+		First(&session).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"message": "会话不存在",
+		})
+		return
+	}
+
+	updates := map[string]interface{}{}
+	notifyTeacher := false
+	if req.ShareWithTeacher != nil && *req.ShareWithTeacher != session.SharedWithTeacher {
+		if *req.ShareWithTeacher && session.Course == nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    400,
+				"message": "未关联课程的会话不能分享给教师",
+			})
+			return
+		}
+		session.SharedWithTeacher = *req.ShareWithTeacher
+		session.SharedAt = nil
+		if session.SharedWithTeacher {
+			now := time.Now()
+			session.SharedAt = &now
+			notifyTeacher = true
+		}
+		updates["shared_with_teacher"] = session.SharedWithTeacher
+		updates["shared_at"] = session.SharedAt
+	}
+	if req.ShareLink != nil {
+		switch {
+		case *req.ShareLink && session.ShareToken == nil:
+			token, err := utils.GenerateRandomString(chatShareTokenLength)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"code":    500,
+					"message": "生成分享链接失败",
+				})
+				return
+			}
+			updates["share_token"] = token
+			session.ShareToken = &token
+		case !*req.ShareLink && session.ShareToken != nil:
+			updates["share_token"] = nil
+			session.ShareToken = nil
+		}
+	}
+
+	if len(updates) > 0 {
+		// 只修改分享状态，不改变会话在列表中的排序
+		if err := tenantDB(c).Model(&session).UpdateColumns(updates).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    500,
+				"message": "更新分享设置失败",
+			})
+			return
+		}
+		recordAudit(c, "chat.share", "chat_session", session.ID, gin.H{
+			"shared_with_teacher": session.SharedWithTeacher,
+			"share_link":          session.ShareToken != nil,
+		})
+	}
+	if notifyTeacher {
+		services.NewNotificationService().Notify(session.Course.TeacherID, "chat_share", "学生分享了AI对话",
+			fmt.Sprintf("%s在课程《%s》中分享了AI对话「%s」，请查看并指导。", c.GetString("username"), session.Course.Name, session.Title),
+			fmt.Sprintf("/courses/%d/chat-sessions/%d", session.Course.ID, session.ID))
+	}
+
+	data := gin.H{
+		"shared_with_teacher": session.SharedWithTeacher,
+		"shared_at":           session.SharedAt,
+		"share_token":         "",
+		"chat_visibility":     "",
+		"chat_visible_since":  nil,
+	}
+	if session.ShareToken != nil {
+		data["share_token"] = *session.ShareToken
+	}
+	if session.Course != nil {
+		data["chat_visibility"] = session.Course.ChatVisibility
+		data["chat_visible_since"] = session.Course.ChatVisibleSince
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "分享设置已更新",
+		"data":    data,
+	})
+}
+
+// 通过分享链接查看会话，只有本校登录用户可以查看，不返回分享人的联系方式
+func GetSharedChatSession(c *gin.Context) {
+	var session models.ChatSession
+	if err := tenantDB(c).Preload("User").Preload("Course").
+		Where("share_token = ?", c.Param("token")).First(&session).Error; err != nil ||
+		session.User.TenantID != tenantID(c) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"message": "分享链接不存在或已关闭",
+		})
+		return
+	}
+	if !loadActiveMessages(c, &session) {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "获取成功",
+		"data": gin.H{
+			"id":         session.ID,
+			"title":      session.Title,
+			"type":       session.Type,
+			"course":     session.Course,
+			"owner_name": session.User.RealName,
+			"messages":   sharedMessages(session.Messages),
+			"created_at": session.CreatedAt,
+		},
+	})
+}
+
+// 分享链接中的消息只保留教师回复人的姓名
+func sharedMessages(messages []models.ChatMessage) []gin.H {
+	list := make([]gin.H, 0, len(messages))
+	for _, m := range messages {
+		item := gin.H{
+			"id":         m.ID,
+			"role":       m.Role,
+			"content":    m.Content,
+			"created_at": m.CreatedAt,
+		}
+		if m.Sender != nil {
+			item["sender_name"] = m.Sender.RealName
+		}
+		list = append(list, item)
+	}
+	return list
+}
+
+// 课程内教师可以查看的会话：学生分享的会话，课程设置为all时包括改为all之后新建的会话
+func reviewableSessions(c *gin.Context, course *models.Course) *gorm.DB {
+	query := tenantDB(c).Model(&models.ChatSession{}).Where("course_id = ?", course.ID)
+	if course.ChatVisibility == models.ChatVisibilityAll && course.ChatVisibleSince != nil {
+		return query.Where("shared_with_teacher = ? OR created_at >= ?", true, *course.ChatVisibleSince)
+	}
+	return query.Where("shared_with_teacher = ?", true)
+}
+
+// 查找教师可以查看的会话，不存在或不可见时直接返回404
+func findReviewableSession(c *gin.Context, course *models.Course) (*models.ChatSession, bool) {
+	var session models.ChatSession
+	if err := reviewableSessions(c, course).Preload("User").First(&session, c.Param("sessionId")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"message": "会话不存在或学生未分享",
+		})
+		return nil, false
+	}
+	return &session, true
+}
+
+// 课程内学生的AI对话列表（教师）
+func GetCourseChatSessions(c *gin.Context) {
+	course, ok := findCourse(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	page, pageSize := getPagination(c)
+
+	query := reviewableSessions(c, course)
+	if userID := c.Query("user_id"); userID != "" {
+		query = query.Where("user_id = ?", parseUint(userID))
+	}
+	if c.Query("shared") == "1" {
+		query = query.Where("shared_with_teacher = ?", true)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "获取会话列表失败",
+		})
+		return
+	}
+
+	var sessions []models.ChatSession
+	if err := query.Preload("User").Preload("Chapter").Order("updated_at DESC").
+		Offset((page - 1) * pageSize).Limit(pageSize).Find(&sessions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "获取会话列表失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "获取成功",
+		"data": gin.H{
+			"list":            sessions,
+			"total":           total,
+			"page":            page,
+			"page_size":       pageSize,
+			"chat_visibility": course.ChatVisibility,
+		},
+	})
+}
+
+// 查看课程内学生的AI对话（教师），查看记录写入审计日志
+func GetCourseChatSession(c *gin.Context) {
+	course, ok := findCourse(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	session, ok := findReviewableSession(c, course)
+	if !ok || !loadActiveMessages(c, session) {
+		return
+	}
+	recordAudit(c, "chat.session_review", "chat_session", session.ID, gin.H{
+		"course_id":  course.ID,
+		"student_id": session.UserID,
+		"shared":     session.SharedWithTeacher,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "获取成功",
+		"data":    session,
+	})
+}
+
+// 教师在学生的AI对话中人工回复，消息角色为teacher并通知学生
+func ReplyCourseChatSession(c *gin.Context) {
+	course, ok := findCourse(c, c.Param("courseId"))
+	if !ok {
+		return
+	}
+
+	var req TeacherReplyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "请填写回复内容",
+		})
+		return
+	}
+
+	session, ok := findReviewableSession(c, course)
+	if !ok {
+		return
+	}
+
+	teacherID := middleware.GetCurrentUserID(c)
+	message := models.ChatMessage{
+		Role:        models.ChatRoleTeacher,
+		Content:     req.Content,
+		MessageType: "text",
+		SenderID:    &teacherID,
+	}
+	// 回复接在学生当前分支的末尾
+	if err := services.NewChatService().Append(session, &message); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "回复失败",
+		})
+		return
+	}
+	recordAudit(c, "chat.teacher_reply", "chat_session", session.ID, gin.H{
+		"course_id":  course.ID,
+		"message_id": message.ID,
+	})
+	services.NewNotificationService().Notify(session.UserID, "chat_reply", "老师回复了你的AI对话",
+		fmt.Sprintf("课程《%s》的老师在对话「%s」中给出了回复。", course.Name, session.Title),
+		fmt.Sprintf("/chat/%d", session.ID))
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "回复成功",
+		"data":    message,
+	})
+}
@@ -1,25 +1,32 @@
 package handlers
 
 import (
-	"backend/database"
 	"backend/middleware"
 	"backend/models"
 	"backend/services"
+	"fmt"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
+const (
+	materialUploadDir = "uploads/tenants/%d/materials" // 按学校分目录保存
+	legacyMaterialDir = "uploads/materials"
+)
+
 type CreateCourseRequest struct {
-	Name        string `json:"name" binding:"required"`
-	Description string `json:"description"`
-	Subject     string `json:"subject" binding:"required"`
-	Grade       string `json:"grade"`
-	CoverImage  string `json:"cover_image"`
+	Name           string `json:"name" binding:"required"`
+	Description    string `json:"description"`
+	Subject        string `json:"subject" binding:"required"`
+	Grade          string `json:"grade"`
+	CoverImage     string `json:"cover_image"`
+	ChatVisibility string `json:"chat_visibility" binding:"omitempty,oneof=shared all"` // 不传时新建课程为shared，更新时保持不变
 }
 
 // 创建课程
@@ -36,17 +43,40 @@ func CreateCourse(c *gin.Context) {
 		return
 	}
 
+	if err := services.NewTenantService().CheckCourseQuota(tenantID(c)); err != nil {
+		respondTenantError(c, err, "课程创建失败")
+		return
+	}
+
+	inviteCode, err := services.NewEnrollmentService().GenerateInviteCode()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "生成邀请码失败",
+		})
+		return
+	}
+
 	course := models.Course{
+		TenantID:    tenantID(c),
 		Name:        req.Name,
 		Description: req.Description,
 		Subject:     req.Subject,
 		Grade:       req.Grade,
 		CoverImage:  req.CoverImage,
 		TeacherID:   teacherID,
+		InviteCode:  inviteCode,
 		Status:      1,
 	}
+	if req.ChatVisibility != "" {
+		course.ChatVisibility = req.ChatVisibility
+	}
+	if course.ChatVisibility == models.ChatVisibilityAll {
+		now := time.Now()
+		course.ChatVisibleSince = &now
+	}
 
-	if err := database.DB.Create(&course).Error; err != nil {
+	if err := tenantDB(c).Create(&course).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"code":    500,
 			"message": "课程创建失败",
@@ -54,6 +84,8 @@ func CreateCourse(c *gin.Context) {
 		return
 	}
 
+	recordAudit(c, "course.create", "course", course.ID, gin.H{"name": course.Name})
+
 	c.JSON(http.StatusOK, gin.H{
 		"code":    200,
 		"message": "课程创建成功",
@@ -67,11 +99,17 @@ func GetCourses(c *gin.Context) {
 	userRole := middleware.GetCurrentUserRole(c)
 
 	var courses []models.Course
-	query := database.DB.Preload("Teacher")
-
-	// 根据角色过滤课程
-	if userRole == string(models.RoleTeacher) {
-		query = query.Where("teacher_id = ?", userID)
+	query := tenantDB(c).Preload("Teacher")
+
+	// 管理员和有课程授权范围的服务账户可以看到全部课程，其他用户只能看到自己负责、协作或在读的课程
+	if !models.UserRole(userRole).IsAdmin() && !apiKeyCourseAccess(c, 0) {
+		query = query.Where(tenantDB(c).Where("teacher_id = ?", userID).
+			Or("id IN (?)", tenantDB(c).Model(&models.CourseMember{}).
+				Select("course_id").
+				Where("user_id = ?", userID)).
+			Or("id IN (?)", tenantDB(c).Model(&models.Enrollment{}).
+				Select("course_id").
+				Where("user_id = ? AND status = ?", userID, models.EnrollmentActive)))
 	}
 
 	if err := query.Find(&courses).Error; err != nil {
@@ -92,9 +130,12 @@ func GetCourses(c *gin.Context) {
 // 获取课程详情
 func GetCourse(c *gin.Context) {
 	courseID := c.Param("id")
+	if !requireCourseView(c, parseUint(courseID)) {
+		return
+	}
 
 	var course models.Course
-	if err := database.DB.Preload("Teacher").Preload("Chapters").First(&course, courseID).Error; err != nil {
+	if err := tenantDB(c).Preload("Teacher").Preload("Chapters").First(&course, courseID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{
 			"code":    404,
 			"message": "课程不存在",
@@ -112,7 +153,6 @@ func GetCourse(c *gin.Context) {
 // 更新课程
 func UpdateCourse(c *gin.Context) {
 	courseID := c.Param("id")
-	teacherID := middleware.GetCurrentUserID(c)
 
 	var req CreateCourseRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -123,17 +163,13 @@ func UpdateCourse(c *gin.Context) {
 		return
 	}
 
-	// 检查课程是否存在且属于当前教师
-	var course models.Course
-	if err := database.DB.Where("id = ? AND teacher_id = ?", courseID, teacherID).First(&course).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"code":    404,
-			"message": "课程不存在或无权限",
-		})
+	course, ok := findCourse(c, courseID)
+	if !ok {
 		return
 	}
 
 	// 更新课程信息
+	before := *course
 	updates := map[string]interface{}{
 		"name":        req.Name,
 		"description": req.Description,
@@ -141,14 +177,30 @@ func UpdateCourse(c *gin.Context) {
 		"grade":       req.Grade,
 		"cover_image": req.CoverImage,
 	}
+	if req.ChatVisibility != "" && req.ChatVisibility != course.ChatVisibility {
+		if !canChangeChatVisibility(c, course) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"code":    403,
+				"message": "只有课程负责教师或管理员可以修改AI对话可见范围",
+			})
+			return
+		}
+		// 改为all只对此后新建的会话生效，之前的会话仍需学生主动分享
+		updates["chat_visibility"] = req.ChatVisibility
+		updates["chat_visible_since"] = nil
+		if req.ChatVisibility == models.ChatVisibilityAll {
+			updates["chat_visible_since"] = time.Now()
+		}
+	}
 
-	if err := database.DB.Model(&course).Updates(updates).Error; err != nil {
+	if err := tenantDB(c).Model(course).Updates(updates).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"code":    500,
 			"message": "课程更新失败",
 		})
 		return
 	}
+	recordAuditChange(c, "course.update", "course", course.ID, before, course, nil)
 
 	c.JSON(http.StatusOK, gin.H{
 		"code":    200,
@@ -157,28 +209,32 @@ func UpdateCourse(c *gin.Context) {
 	})
 }
 
+// AI对话可见范围涉及学生隐私，只能由课程负责教师或管理员登录后修改，协作教师和API密钥不能修改
+func canChangeChatVisibility(c *gin.Context, course *models.Course) bool {
+	if middleware.IsAPIKeyRequest(c) {
+		return false
+	}
+	return course.TeacherID == middleware.GetCurrentUserID(c) ||
+		models.UserRole(middleware.GetCurrentUserRole(c)).IsAdmin()
+}
+
 // 删除课程
 func DeleteCourse(c *gin.Context) {
 	courseID := c.Param("id")
-	teacherID := middleware.GetCurrentUserID(c)
 
-	// 检查课程是否存在且属于当前教师
-	var course models.Course
-	if err := database.DB.Where("id = ? AND teacher_id = ?", courseID, teacherID).First(&course).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"code":    404,
-			"message": "课程不存在或无权限",
-		})
+	course, ok := findCourse(c, courseID)
+	if !ok {
 		return
 	}
 
-	if err := database.DB.Delete(&course).Error; err != nil {
+	if err := tenantDB(c).Delete(course).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"code":    500,
 			"message": "课程删除失败",
 		})
 		return
 	}
+	recordAudit(c, "course.delete", "course", course.ID, gin.H{"name": course.Name})
 
 	c.JSON(http.StatusOK, gin.H{
 		"code":    200,
@@ -192,18 +248,14 @@ func GenerateLessonPlan(c *gin.Context) {
 	chapterID := c.Param("chapterId")
 
 	// 获取课程信息
-	var course models.Course
-	if err := database.DB.First(&course, courseID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"code":    404,
-			"message": "课程不存在",
-		})
+	course, ok := findCourse(c, courseID)
+	if !ok {
 		return
 	}
 
-	// 获取章节信息
+	// 获取章节信息，章节必须属于该课程
 	var chapter models.Chapter
-	if err := database.DB.First(&chapter, chapterID).Error; err != nil {
+	if err := tenantDB(c).Where("id = ? AND course_id = ?", chapterID, course.ID).First(&chapter).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{
 			"code":    404,
 			"message": "章节不存在",
@@ -213,7 +265,7 @@ func GenerateLessonPlan(c *gin.Context) {
 
 	// 获取知识点
 	var knowledge []models.Knowledge
-	if err := database.DB.Where("chapter_id = ?", chapterID).Find(&knowledge).Error; err != nil {
+	if err := tenantDB(c).Where("chapter_id = ?", chapterID).Find(&knowledge).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"code":    500,
 			"message": "获取知识点失败",
@@ -222,8 +274,12 @@ func GenerateLessonPlan(c *gin.Context) {
 	}
 
 	// 调用AI服务生成备课内容
-	aiService := services.NewAIService()
-	lessonPlan, err := aiService.GenerateLessonPlan(&course, &chapter, knowledge)
+	aiService, err := tenantAIService(c)
+	if err != nil {
+		respondTenantError(c, err, "AI服务暂不可用")
+		return
+	}
+	lessonPlan, err := aiService.GenerateLessonPlan(course, &chapter, knowledge)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"code":    500,
@@ -244,10 +300,13 @@ func GenerateLessonPlan(c *gin.Context) {
 // 获取课程统计信息
 func GetCourseStats(c *gin.Context) {
 	courseID := c.Param("id")
+	if !requireCourseView(c, parseUint(courseID)) {
+		return
+	}
 
 	// 获取课程基本信息
 	var course models.Course
-	if err := database.DB.First(&course, courseID).Error; err != nil {
+	if err := tenantDB(c).First(&course, courseID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{
 			"code":    404,
 			"message": "课程不存在",
@@ -257,15 +316,15 @@ func GetCourseStats(c *gin.Context) {
 
 	// 统计章节数量
 	var chapterCount int64
-	database.DB.Model(&models.Chapter{}).Where("course_id = ?", courseID).Count(&chapterCount)
+	tenantDB(c).Model(&models.Chapter{}).Where("course_id = ?", courseID).Count(&chapterCount)
 
 	// 统计练习数量
 	var exerciseCount int64
-	database.DB.Model(&models.Exercise{}).Where("course_id = ?", courseID).Count(&exerciseCount)
+	tenantDB(c).Model(&models.Exercise{}).Where("course_id = ?", courseID).Count(&exerciseCount)
 
 	// 统计学习进度
 	var progressCount int64
-	database.DB.Model(&models.LearningProgress{}).Where("course_id = ?", courseID).Count(&progressCount)
+	tenantDB(c).Model(&models.LearningProgress{}).Where("course_id = ?", courseID).Count(&progressCount)
 
 	c.JSON(http.StatusOK, gin.H{
 		"code":    200,
@@ -279,15 +338,11 @@ func GetCourseStats(c *gin.Context) {
 	})
 }
 
-// 上传教学资料（教师）
+// 上传教学资料（教师、助教）
 func UploadCourseMaterial(c *gin.Context) {
 	teacherID := middleware.GetCurrentUserID(c)
-	courseID := c.Param("courseId")
-
-	// 角色校验（仅教师）
-	role, _ := c.Get("role")
-	if role != "teacher" {
-		c.JSON(403, gin.H{"message": "只有教师可以上传资料"})
+	course, ok := findCourse(c, c.Param("courseId"))
+	if !ok {
 		return
 	}
 
@@ -304,7 +359,7 @@ func UploadCourseMaterial(c *gin.Context) {
 	}
 
 	// 保存文件到 uploads 目录
-	uploadDir := "uploads/materials"
+	uploadDir := fmt.Sprintf(materialUploadDir, tenantID(c))
 	os.MkdirAll(uploadDir, os.ModePerm)
 	filename := time.Now().Format("20060102150405") + "_" + file.Filename
 	savePath := filepath.Join(uploadDir, filename)
@@ -315,17 +370,22 @@ func UploadCourseMaterial(c *gin.Context) {
 
 	// 写入数据库
 	material := models.CourseMaterial{
-		CourseID:   parseUint(courseID),
+		TenantID:   course.TenantID,
+		CourseID:   course.ID,
 		TeacherID:  teacherID,
 		Title:      title,
 		FileURL:    "/" + savePath,
 		FileType:   filepath.Ext(file.Filename),
 		UploadedAt: time.Now(),
 	}
-	if err := database.DB.Create(&material).Error; err != nil {
+	if err := tenantDB(c).Create(&material).Error; err != nil {
 		c.JSON(500, gin.H{"message": "数据库写入失败"})
 		return
 	}
+	recordAudit(c, "material.upload", "course_material", material.ID, gin.H{
+		"course_id": material.CourseID,
+		"title":     material.Title,
+	})
 
 	c.JSON(200, gin.H{"message": "上传成功", "data": material})
 }
@@ -333,38 +393,81 @@ func UploadCourseMaterial(c *gin.Context) {
 // 获取课程资料列表
 func GetCourseMaterials(c *gin.Context) {
 	courseID := c.Param("courseId")
+	if !requireCourseView(c, parseUint(courseID)) {
+		return
+	}
 	var materials []models.CourseMaterial
-	if err := database.DB.Where("course_id = ?", courseID).Order("uploaded_at desc").Find(&materials).Error; err != nil {
+	if err := tenantDB(c).Where("course_id = ?", courseID).Order("uploaded_at desc").Find(&materials).Error; err != nil {
 		c.JSON(500, gin.H{"message": "获取资料失败"})
 		return
 	}
 	c.JSON(200, gin.H{"data": materials})
 }
 
-// 删除资料（仅教师）
+// 下载课程资料，只有可以访问该课程的本校用户才能下载
+func DownloadCourseMaterial(c *gin.Context) {
+	courseID := parseUint(c.Param("courseId"))
+	if !requireCourseView(c, courseID) {
+		return
+	}
+	var material models.CourseMaterial
+	if err := tenantDB(c).Where("course_id = ?", courseID).First(&material, c.Param("materialId")).Error; err != nil {
+		c.JSON(404, gin.H{"message": "资料不存在"})
+		return
+	}
+
+	path, ok := materialFilePath(tenantID(c), material.FileURL)
+	if !ok {
+		c.JSON(404, gin.H{"message": "资料文件不存在"})
+		return
+	}
+	if _, err := os.Stat(path); err != nil {
+		c.JSON(404, gin.H{"message": "资料文件不存在"})
+		return
+	}
+	name := material.Title
+	if !strings.EqualFold(filepath.Ext(name), material.FileType) {
+		name += material.FileType
+	}
+	c.FileAttachment(path, name)
+}
+
+// 资料文件的本地路径，只允许本校资料目录和升级前的资料目录
+func materialFilePath(tenantID uint, fileURL string) (string, bool) {
+	path := filepath.Clean(strings.TrimPrefix(fileURL, "/"))
+	for _, dir := range []string{fmt.Sprintf(materialUploadDir, tenantID), legacyMaterialDir} {
+		if strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// 删除资料：可删除课程内任意资料，或删除自己上传的资料
 func DeleteCourseMaterial(c *gin.Context) {
 	materialID := c.Param("materialId")
 	teacherID := middleware.GetCurrentUserID(c)
-	role, _ := c.Get("role")
-	if role != "teacher" {
-		c.JSON(403, gin.H{"message": "只有教师可以删除资料"})
-		return
-	}
 	var material models.CourseMaterial
-	if err := database.DB.First(&material, materialID).Error; err != nil {
+	if err := tenantDB(c).First(&material, materialID).Error; err != nil {
 		c.JSON(404, gin.H{"message": "资料不存在"})
 		return
 	}
-	if material.TeacherID != teacherID {
+	if !hasPermission(c, models.PermMaterialDelete, material.CourseID) &&
+		(material.TeacherID != teacherID || !hasPermission(c, models.PermMaterialUpload, material.CourseID)) {
 		c.JSON(403, gin.H{"message": "只能删除自己上传的资料"})
 		return
 	}
 	// 删除文件
 	os.Remove(material.FileURL[1:]) // 去掉前面的/
-	if err := database.DB.Delete(&material).Error; err != nil {
+	if err := tenantDB(c).Delete(&material).Error; err != nil {
 		c.JSON(500, gin.H{"message": "删除失败"})
 		return
 	}
+	recordAudit(c, "material.delete", "course_material", material.ID, gin.H{
+		"course_id":   material.CourseID,
+		"title":       material.Title,
+		"uploaded_by": material.TeacherID,
+	})
 	c.JSON(200, gin.H{"message": "删除成功"})
 }
 
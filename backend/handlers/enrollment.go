@@ -0,0 +1,354 @@
+package handlers
+
+import (
+	"backend/middleware"
+	"backend/models"
+	"backend/services"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type JoinCourseRequest struct {
+	InviteCode string `json:"invite_code" binding:"required"`
+}
+
+type AddStudentsRequest struct {
+	UserIDs   []uint   `json:"user_ids"`
+	Usernames []string `json:"usernames"`
+}
+
+type EnrollClassRequest struct {
+	Class string `json:"class" binding:"required"`
+	Grade string `json:"grade"`
+}
+
+type UpdateEnrollmentRequest struct {
+	Status models.EnrollmentStatus `json:"status" binding:"required,oneof=active dropped removed"`
+}
+
+// 检查当前用户是否可以访问课程，无权限时直接返回403。只按用户身份判断，API密钥的授权范围不代表加入了课程
+func requireCourseAccess(c *gin.Context, courseID uint) bool {
+	userID := middleware.GetCurrentUserID(c)
+	role := middleware.GetCurrentUserRole(c)
+	if services.NewEnrollmentService().CanAccessCourse(userID, role, courseID) {
+		return true
+	}
+
+	c.JSON(http.StatusForbidden, gin.H{
+		"code":    403,
+		"message": "未加入该课程",
+	})
+	return false
+}
+
+// 通过邀请码加入课程（学生）
+func JoinCourse(c *gin.Context) {
+	userID := middleware.GetCurrentUserID(c)
+
+	var req JoinCourseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "请求参数错误",
+		})
+		return
+	}
+
+	var course models.Course
+	if err := tenantDB(c).Where("invite_code = ? AND status = ?", req.InviteCode, 1).First(&course).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"message": "邀请码无效",
+		})
+		return
+	}
+
+	enrollment, _, err := services.NewEnrollmentService().Enroll(course.ID, userID, models.EnrollmentSourceSelf)
+	if errors.Is(err, services.ErrEnrollmentRemoved) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"code":    403,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "加入课程失败",
+		})
+		return
+	}
+	recordAudit(c, "enrollment.join", "course", course.ID, nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "加入课程成功",
+		"data":    enrollment,
+	})
+}
+
+// 获取我的选课列表
+func GetMyEnrollments(c *gin.Context) {
+	userID := middleware.GetCurrentUserID(c)
+
+	var enrollments []models.Enrollment
+	if err := tenantDB(c).Preload("Course").Preload("Course.Teacher").
+		Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Find(&enrollments).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "获取选课列表失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "获取成功",
+		"data":    enrollments,
+	})
+}
+
+// 退出课程（学生）
+func DropCourse(c *gin.Context) {
+	userID := middleware.GetCurrentUserID(c)
+	courseID := parseUint(c.Param("courseId"))
+
+	if err := services.NewEnrollmentService().Drop(courseID, userID); err != nil {
+		if errors.Is(err, services.ErrEnrollmentRemoved) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"code":    403,
+				"message": err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"message": "选课记录不存在",
+		})
+		return
+	}
+	recordAudit(c, "enrollment.drop", "course", courseID, nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "已退出课程",
+	})
+}
+
+// 获取课程学生列表（教师）
+func GetCourseStudents(c *gin.Context) {
+	course, ok := findCourse(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	query := tenantDB(c).Preload("User").Where("course_id = ?", course.ID)
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var enrollments []models.Enrollment
+	if err := query.Order("created_at ASC").Find(&enrollments).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "获取学生列表失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "获取成功",
+		"data":    enrollments,
+	})
+}
+
+// 添加学生到课程（教师）
+func AddCourseStudents(c *gin.Context) {
+	course, ok := findCourse(c, c.Param("courseId"))
+	if !ok {
+		return
+	}
+
+	var req AddStudentsRequest
+	if err := c.ShouldBindJSON(&req); err != nil || (len(req.UserIDs) == 0 && len(req.Usernames) == 0) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "请求参数错误",
+		})
+		return
+	}
+
+	var students []models.User
+	query := tenantDB(c).Where("role = ?", models.RoleStudent)
+	if len(req.UserIDs) > 0 && len(req.Usernames) > 0 {
+		query = query.Where("id IN ? OR username IN ?", req.UserIDs, req.Usernames)
+	} else if len(req.UserIDs) > 0 {
+		query = query.Where("id IN ?", req.UserIDs)
+	} else {
+		query = query.Where("username IN ?", req.Usernames)
+	}
+	if err := query.Find(&students).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "查询学生失败",
+		})
+		return
+	}
+
+	enrollmentService := services.NewEnrollmentService()
+	added := 0
+	for _, student := range students {
+		_, created, err := enrollmentService.Enroll(course.ID, student.ID, models.EnrollmentSourceTeacher)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    500,
+				"message": "添加学生失败",
+			})
+			return
+		}
+		if created {
+			added++
+		}
+	}
+	recordAudit(c, "enrollment.add", "course", course.ID, gin.H{
+		"user_ids": studentIDs(students),
+		"added":    added,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "添加成功",
+		"data": gin.H{
+			"matched": len(students),
+			"added":   added,
+		},
+	})
+}
+
+// 按班级批量添加学生（教师）
+func EnrollClass(c *gin.Context) {
+	course, ok := findCourse(c, c.Param("courseId"))
+	if !ok {
+		return
+	}
+
+	var req EnrollClassRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "请求参数错误",
+		})
+		return
+	}
+
+	added, err := services.NewEnrollmentService().EnrollClass(course.ID, req.Class, req.Grade)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "添加班级学生失败",
+		})
+		return
+	}
+	recordAudit(c, "enrollment.add_class", "course", course.ID, gin.H{
+		"class": req.Class,
+		"grade": req.Grade,
+		"added": added,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "添加成功",
+		"data": gin.H{
+			"added": added,
+		},
+	})
+}
+
+// 修改学生选课状态（教师）
+func UpdateEnrollment(c *gin.Context) {
+	course, ok := findCourse(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	var req UpdateEnrollmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "请求参数错误",
+		})
+		return
+	}
+
+	userID := parseUint(c.Param("userId"))
+	var enrollment models.Enrollment
+	if err := tenantDB(c).Where("course_id = ? AND user_id = ?", course.ID, userID).First(&enrollment).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"message": "选课记录不存在",
+		})
+		return
+	}
+	if err := services.NewEnrollmentService().SetStatus(course.ID, userID, req.Status); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"message": "选课记录不存在",
+		})
+		return
+	}
+	recordAuditChange(c, "enrollment.status_change", "course", course.ID,
+		gin.H{"status": enrollment.Status}, gin.H{"status": req.Status}, gin.H{"user_id": userID})
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "更新成功",
+	})
+}
+
+// 重新生成课程邀请码（教师）
+func RegenerateInviteCode(c *gin.Context) {
+	course, ok := findCourse(c, c.Param("courseId"))
+	if !ok {
+		return
+	}
+
+	code, err := services.NewEnrollmentService().GenerateInviteCode()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "生成邀请码失败",
+		})
+		return
+	}
+
+	if err := tenantDB(c).Model(course).Update("invite_code", code).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "更新邀请码失败",
+		})
+		return
+	}
+	recordAudit(c, "course.invite_code_reset", "course", course.ID, nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "邀请码已更新",
+		"data": gin.H{
+			"invite_code": code,
+		},
+	})
+}
+
+// 取出学生ID列表，用于审计日志
+func studentIDs(students []models.User) []uint {
+	ids := make([]uint, 0, len(students))
+	for _, student := range students {
+		ids = append(ids, student.ID)
+	}
+	return ids
+}
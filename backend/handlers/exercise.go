@@ -1,14 +1,17 @@
 package handlers
 
 import (
-	"backend/database"
 	"backend/middleware"
 	"backend/models"
 	"backend/services"
+	"fmt"
+	"log"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 type CreateExerciseRequest struct {
@@ -26,10 +29,15 @@ type SubmitAnswerRequest struct {
 	Answer     string `json:"answer" binding:"required"`
 }
 
+type OverrideAnswerScoreRequest struct {
+	Score     *int    `json:"score" binding:"required"`
+	IsCorrect *bool   `json:"is_correct"`
+	Feedback  *string `json:"feedback"`
+	Reason    string  `json:"reason" binding:"required"` // 调分原因，写入审计日志
+}
+
 // 创建练习
 func CreateExercise(c *gin.Context) {
-	teacherID := middleware.GetCurrentUserID(c)
-
 	var req CreateExerciseRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -40,15 +48,18 @@ func CreateExercise(c *gin.Context) {
 		return
 	}
 
-	// 验证课程是否属于当前教师
+	// 验证课程存在且当前用户可以管理该课程的练习
 	var course models.Course
-	if err := database.DB.Where("id = ? AND teacher_id = ?", req.CourseID, teacherID).First(&course).Error; err != nil {
+	if err := tenantDB(c).First(&course, req.CourseID).Error; err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"code":    400,
 			"message": "课程不存在或无权限",
 		})
 		return
 	}
+	if !requirePermission(c, models.PermExerciseManage, course.ID) {
+		return
+	}
 
 	exercise := models.Exercise{
 		Title:       req.Title,
@@ -61,13 +72,17 @@ func CreateExercise(c *gin.Context) {
 		Status:      1,
 	}
 
-	if err := database.DB.Create(&exercise).Error; err != nil {
+	if err := tenantDB(c).Create(&exercise).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"code":    500,
 			"message": "练习创建失败",
 		})
 		return
 	}
+	recordAudit(c, "exercise.create", "exercise", exercise.ID, gin.H{
+		"course_id": exercise.CourseID,
+		"title":     exercise.Title,
+	})
 
 	c.JSON(http.StatusOK, gin.H{
 		"code":    200,
@@ -82,7 +97,20 @@ func GetExercises(c *gin.Context) {
 	chapterID := c.Query("chapter_id")
 
 	var exercises []models.Exercise
-	query := database.DB.Preload("Course").Preload("Chapter")
+	// 只显示本校课程的练习
+	query := tenantDB(c).Preload("Course").Preload("Chapter").
+		Where("course_id IN (?)", tenantDB(c).Model(&models.Course{}).Select("id"))
+
+	// 学生只能看到已加入或担任助教课程的练习
+	if middleware.GetCurrentUserRole(c) == string(models.RoleStudent) {
+		userID := middleware.GetCurrentUserID(c)
+		query = query.Where(tenantDB(c).Where("course_id IN (?)", tenantDB(c).Model(&models.Enrollment{}).
+			Select("course_id").
+			Where("user_id = ? AND status = ?", userID, models.EnrollmentActive)).
+			Or("course_id IN (?)", tenantDB(c).Model(&models.CourseMember{}).
+				Select("course_id").
+				Where("user_id = ?", userID)))
+	}
 
 	if courseID != "" {
 		query = query.Where("course_id = ?", courseID)
@@ -111,7 +139,7 @@ func GetExercise(c *gin.Context) {
 	exerciseID := c.Param("id")
 
 	var exercise models.Exercise
-	if err := database.DB.Preload("Course").Preload("Chapter").Preload("Questions").First(&exercise, exerciseID).Error; err != nil {
+	if err := tenantDB(c).Preload("Course").Preload("Chapter").Preload("Questions").First(&exercise, exerciseID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{
 			"code":    404,
 			"message": "练习不存在",
@@ -119,6 +147,10 @@ func GetExercise(c *gin.Context) {
 		return
 	}
 
+	if !requireCourseView(c, exercise.CourseID) {
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"code":    200,
 		"message": "获取成功",
@@ -139,18 +171,14 @@ func GenerateExercises(c *gin.Context) {
 	}
 
 	// 获取课程信息
-	var course models.Course
-	if err := database.DB.First(&course, courseID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"code":    404,
-			"message": "课程不存在",
-		})
+	course, ok := findCourse(c, courseID)
+	if !ok {
 		return
 	}
 
-	// 获取章节信息
+	// 获取章节信息，章节必须属于该课程
 	var chapter models.Chapter
-	if err := database.DB.First(&chapter, chapterID).Error; err != nil {
+	if err := tenantDB(c).Where("id = ? AND course_id = ?", chapterID, course.ID).First(&chapter).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{
 			"code":    404,
 			"message": "章节不存在",
@@ -159,8 +187,12 @@ func GenerateExercises(c *gin.Context) {
 	}
 
 	// 调用AI服务生成练习题
-	aiService := services.NewAIService()
-	questions, err := aiService.GenerateExercises(&course, &chapter, models.QuestionType(questionType), count)
+	aiService, err := tenantAIService(c)
+	if err != nil {
+		respondTenantError(c, err, "AI服务暂不可用")
+		return
+	}
+	questions, err := aiService.GenerateExercises(course, &chapter, models.QuestionType(questionType), count)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"code":    500,
@@ -178,12 +210,12 @@ func GenerateExercises(c *gin.Context) {
 
 // 开始练习
 func StartExercise(c *gin.Context) {
-	exerciseID := c.Param("id")
+	exerciseID := c.Param("exerciseId")
 	userID := middleware.GetCurrentUserID(c)
 
 	// 检查练习是否存在
 	var exercise models.Exercise
-	if err := database.DB.First(&exercise, exerciseID).Error; err != nil {
+	if err := tenantDB(c).First(&exercise, exerciseID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{
 			"code":    404,
 			"message": "练习不存在",
@@ -191,14 +223,20 @@ func StartExercise(c *gin.Context) {
 		return
 	}
 
+	if !requireCourseAccess(c, exercise.CourseID) {
+		return
+	}
+
 	// 创建练习记录
 	record := models.ExerciseRecord{
 		UserID:     userID,
-		ExerciseID: exercise.ID,
+		ExerciseID: &exercise.ID,
+		Type:       models.ExerciseRecordTypeFixed,
+		StartTime:  time.Now(),
 		Status:     "ongoing",
 	}
 
-	if err := database.DB.Create(&record).Error; err != nil {
+	if err := tenantDB(c).Create(&record).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"code":    500,
 			"message": "开始练习失败",
@@ -232,7 +270,7 @@ func SubmitAnswer(c *gin.Context) {
 
 	// 验证练习记录
 	var record models.ExerciseRecord
-	if err := database.DB.Where("id = ? AND user_id = ?", recordID, userID).First(&record).Error; err != nil {
+	if err := tenantDB(c).Where("id = ? AND user_id = ?", recordID, userID).First(&record).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{
 			"code":    404,
 			"message": "练习记录不存在",
@@ -240,9 +278,19 @@ func SubmitAnswer(c *gin.Context) {
 		return
 	}
 
+	// 自适应练习需通过自适应练习接口作答
+	if record.Type == models.ExerciseRecordTypeAdaptive || record.Status != "ongoing" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "练习记录不可作答",
+		})
+		return
+	}
+
 	// 获取题目信息
 	var question models.Question
-	if err := database.DB.First(&question, req.QuestionID).Error; err != nil {
+	if err := tenantDB(c).Where("id = ? AND exercise_id = ?", req.QuestionID, record.ExerciseID).
+		First(&question).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{
 			"code":    404,
 			"message": "题目不存在",
@@ -250,8 +298,18 @@ func SubmitAnswer(c *gin.Context) {
 		return
 	}
 
+	// 每题只能作答一次，重复提交不再评分，也不重复计入掌握度和总分
+	if answeredInRecord(c, record.ID, question.ID) {
+		respondAlreadyAnswered(c)
+		return
+	}
+
 	// 调用AI服务评估答案
-	aiService := services.NewAIService()
+	aiService, err := tenantAIService(c)
+	if err != nil {
+		respondTenantError(c, err, "AI服务暂不可用")
+		return
+	}
 	score, isCorrect, feedback, err := aiService.EvaluateAnswer(&question, req.Answer)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -264,15 +322,21 @@ func SubmitAnswer(c *gin.Context) {
 	// 保存学生答案
 	studentAnswer := models.StudentAnswer{
 		UserID:     userID,
-		ExerciseID: record.ExerciseID,
+		ExerciseID: question.ExerciseID,
 		QuestionID: req.QuestionID,
+		RecordID:   &record.ID,
 		Answer:     req.Answer,
 		Score:      score,
 		IsCorrect:  isCorrect,
 		Feedback:   feedback,
 	}
 
-	if err := database.DB.Create(&studentAnswer).Error; err != nil {
+	if err := tenantDB(c).Create(&studentAnswer).Error; err != nil {
+		// 并发重复提交时由唯一索引拒绝
+		if answeredInRecord(c, record.ID, question.ID) {
+			respondAlreadyAnswered(c)
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"code":    500,
 			"message": "保存答案失败",
@@ -280,6 +344,12 @@ func SubmitAnswer(c *gin.Context) {
 		return
 	}
 
+	// 更新知识点掌握度
+	mastery, err := services.NewMasteryService().RecordAnswer(userID, &question, isCorrect)
+	if err != nil {
+		log.Printf("更新知识点掌握度失败: %v", err)
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"code":    200,
 		"message": "答案提交成功",
@@ -287,10 +357,27 @@ func SubmitAnswer(c *gin.Context) {
 			"score":      score,
 			"is_correct": isCorrect,
 			"feedback":   feedback,
+			"mastery":    mastery,
 		},
 	})
 }
 
+// 练习记录中该题是否已经作答
+func answeredInRecord(c *gin.Context, recordID, questionID uint) bool {
+	var count int64
+	tenantDB(c).Model(&models.StudentAnswer{}).
+		Where("record_id = ? AND question_id = ?", recordID, questionID).
+		Count(&count)
+	return count > 0
+}
+
+func respondAlreadyAnswered(c *gin.Context) {
+	c.JSON(http.StatusConflict, gin.H{
+		"code":    409,
+		"message": "该题已作答",
+	})
+}
+
 // 完成练习
 func CompleteExercise(c *gin.Context) {
 	recordID := c.Param("recordId")
@@ -298,7 +385,7 @@ func CompleteExercise(c *gin.Context) {
 
 	// 验证练习记录
 	var record models.ExerciseRecord
-	if err := database.DB.Where("id = ? AND user_id = ?", recordID, userID).First(&record).Error; err != nil {
+	if err := tenantDB(c).Where("id = ? AND user_id = ?", recordID, userID).First(&record).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{
 			"code":    404,
 			"message": "练习记录不存在",
@@ -306,26 +393,55 @@ func CompleteExercise(c *gin.Context) {
 		return
 	}
 
+	// 自适应练习答完最后一题时自动完成，已完成的练习不能再次完成
+	if record.Type == models.ExerciseRecordTypeAdaptive || record.Status != "ongoing" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "练习记录不可完成",
+		})
+		return
+	}
+
 	// 计算总分
 	var totalScore int
-	database.DB.Model(&models.StudentAnswer{}).
-		Where("user_id = ? AND exercise_id = ?", userID, record.ExerciseID).
-		Select("SUM(score)").
+	tenantDB(c).Model(&models.StudentAnswer{}).
+		Where("user_id = ? AND record_id = ?", userID, record.ID).
+		Select("COALESCE(SUM(score), 0)").
 		Scan(&totalScore)
 
 	// 更新练习记录
 	updates := map[string]interface{}{
-		"score":  totalScore,
-		"status": "completed",
+		"score":               totalScore,
+		"status":              "completed",
+		"end_time":            time.Now(),
+		"current_question_id": nil,
 	}
 
-	if err := database.DB.Model(&record).Updates(updates).Error; err != nil {
+	result := tenantDB(c).Model(&record).Where("status = ?", "ongoing").Updates(updates)
+	if result.Error != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"code":    500,
 			"message": "完成练习失败",
 		})
 		return
 	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "练习记录不可完成",
+		})
+		return
+	}
+
+	// 章节练习完成后更新学习进度
+	if record.ExerciseID != nil {
+		var exercise models.Exercise
+		if err := tenantDB(c).First(&exercise, *record.ExerciseID).Error; err == nil {
+			if _, err := services.NewProgressService().Recalculate(userID, exercise.ChapterID); err != nil {
+				log.Printf("更新学习进度失败: %v", err)
+			}
+		}
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"code":    200,
@@ -337,19 +453,132 @@ func CompleteExercise(c *gin.Context) {
 	})
 }
 
+// 教师调整学生单题得分，已完成的练习同步更新总分
+func OverrideAnswerScore(c *gin.Context) {
+	var req OverrideAnswerScoreRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "请求参数错误，需要填写得分和调分原因",
+		})
+		return
+	}
+
+	var answer models.StudentAnswer
+	if err := tenantDB(c).Where("id = ? AND record_id = ?", c.Param("answerId"), c.Param("recordId")).
+		First(&answer).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"message": "答题记录不存在",
+		})
+		return
+	}
+
+	// 通过题目所属练习确定课程，再校验当前用户的课程权限
+	var question models.Question
+	if err := tenantDB(c).Preload("Exercise").First(&question, answer.QuestionID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"message": "题目不存在",
+		})
+		return
+	}
+	if !requirePermission(c, models.PermExerciseManage, question.Exercise.CourseID) {
+		return
+	}
+
+	if *req.Score < 0 || (question.Score > 0 && *req.Score > question.Score) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": fmt.Sprintf("得分须在0到%d之间", question.Score),
+		})
+		return
+	}
+
+	var record models.ExerciseRecord
+	if err := tenantDB(c).First(&record, *answer.RecordID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"message": "练习记录不存在",
+		})
+		return
+	}
+
+	before := gin.H{
+		"score":        answer.Score,
+		"is_correct":   answer.IsCorrect,
+		"feedback":     answer.Feedback,
+		"record_score": record.Score,
+	}
+
+	updates := map[string]interface{}{"score": *req.Score}
+	if req.IsCorrect != nil {
+		updates["is_correct"] = *req.IsCorrect
+	}
+	if req.Feedback != nil {
+		updates["feedback"] = *req.Feedback
+	}
+
+	err := tenantDB(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&answer).Updates(updates).Error; err != nil {
+			return err
+		}
+		if record.Status != "completed" {
+			return nil
+		}
+		var totalScore int
+		if err := tx.Model(&models.StudentAnswer{}).
+			Where("user_id = ? AND record_id = ?", record.UserID, record.ID).
+			Select("COALESCE(SUM(score), 0)").
+			Scan(&totalScore).Error; err != nil {
+			return err
+		}
+		return tx.Model(&record).Update("score", totalScore).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "调整得分失败",
+		})
+		return
+	}
+
+	recordAuditChange(c, "grade.override", "student_answer", answer.ID, before, gin.H{
+		"score":        answer.Score,
+		"is_correct":   answer.IsCorrect,
+		"feedback":     answer.Feedback,
+		"record_score": record.Score,
+	}, gin.H{
+		"record_id":   record.ID,
+		"student_id":  record.UserID,
+		"question_id": answer.QuestionID,
+		"course_id":   question.Exercise.CourseID,
+		"reason":      req.Reason,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "得分已调整",
+		"data": gin.H{
+			"answer": answer,
+			"record": record,
+		},
+	})
+}
+
 // 获取练习统计
 func GetExerciseStats(c *gin.Context) {
 	userID := middleware.GetCurrentUserID(c)
 
 	// 统计完成的练习数量
 	var completedCount int64
-	database.DB.Model(&models.ExerciseRecord{}).
+	tenantDB(c).Model(&models.ExerciseRecord{}).
 		Where("user_id = ? AND status = ?", userID, "completed").
 		Count(&completedCount)
 
 	// 统计平均分
 	var avgScore float64
-	database.DB.Model(&models.ExerciseRecord{}).
+	tenantDB(c).Model(&models.ExerciseRecord{}).
 		Where("user_id = ? AND status = ?", userID, "completed").
 		Select("AVG(score)").
 		Scan(&avgScore)
@@ -357,10 +586,10 @@ func GetExerciseStats(c *gin.Context) {
 	// 统计正确率
 	var totalAnswers int64
 	var correctAnswers int64
-	database.DB.Model(&models.StudentAnswer{}).
+	tenantDB(c).Model(&models.StudentAnswer{}).
 		Where("user_id = ?", userID).
 		Count(&totalAnswers)
-	database.DB.Model(&models.StudentAnswer{}).
+	tenantDB(c).Model(&models.StudentAnswer{}).
 		Where("user_id = ? AND is_correct = ?", userID, true).
 		Count(&correctAnswers)
 
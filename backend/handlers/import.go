@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"backend/models"
+	"backend/services"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 导入文件大小上限
+const maxImportFileSize = 5 << 20
+
+// 读取上传的导入文件并批量创建学生，courseID不为0时同时加入课程
+func importStudents(c *gin.Context, courseID uint) {
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "未选择文件",
+		})
+		return
+	}
+	if file.Size > maxImportFileSize {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "文件大小不能超过5MB",
+		})
+		return
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "读取文件失败",
+		})
+		return
+	}
+	defer src.Close()
+
+	importService := services.NewImportService()
+	rows, err := importService.ParseStudentFile(file.Filename, src)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	report, err := importService.ImportStudents(tenantID(c), rows, courseID)
+	if errors.Is(err, services.ErrUserQuotaExceeded) {
+		respondTenantError(c, err, "导入学生失败")
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "导入学生失败",
+		})
+		return
+	}
+
+	recordAudit(c, "user.import", "course", courseID, gin.H{
+		"filename": file.Filename,
+		"total":    report.Total,
+		"created":  report.Created,
+		"failed":   report.Failed,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "导入完成",
+		"data":    report,
+	})
+}
+
+// 批量导入学生（管理员），可通过course_id同时加入课程
+func AdminImportStudents(c *gin.Context) {
+	var courseID uint
+	if id := c.PostForm("course_id"); id != "" {
+		var course models.Course
+		if err := tenantDB(c).First(&course, id).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"code":    404,
+				"message": "课程不存在",
+			})
+			return
+		}
+		courseID = course.ID
+	}
+
+	importStudents(c, courseID)
+}
+
+// 批量导入学生并加入课程（教师）
+func ImportCourseStudents(c *gin.Context) {
+	course, ok := findCourse(c, c.Param("courseId"))
+	if !ok {
+		return
+	}
+
+	importStudents(c, course.ID)
+}
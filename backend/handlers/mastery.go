@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"backend/middleware"
+	"backend/models"
+	"backend/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SetQuestionKnowledgeRequest struct {
+	KnowledgeID *uint `json:"knowledge_id"`
+}
+
+// 获取当前学生的知识点掌握度
+func GetMyMastery(c *gin.Context) {
+	userID := middleware.GetCurrentUserID(c)
+	courseID := parseUint(c.Query("course_id"))
+
+	masteries, err := services.NewMasteryService().GetUserMastery(userID, courseID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "获取知识点掌握度失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "获取成功",
+		"data":    masteries,
+	})
+}
+
+// 获取课程内学生的知识点掌握度（教师）
+func GetCourseMastery(c *gin.Context) {
+	course, ok := findCourse(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	masteryService := services.NewMasteryService()
+	var masteries []models.KnowledgeMastery
+	var err error
+	if userID := parseUint(c.Query("user_id")); userID != 0 {
+		masteries, err = masteryService.GetUserMastery(userID, course.ID)
+	} else {
+		masteries, err = masteryService.GetCourseMastery(course.ID)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "获取知识点掌握度失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "获取成功",
+		"data":    masteries,
+	})
+}
+
+// 设置题目关联的知识点（教师）
+func SetQuestionKnowledge(c *gin.Context) {
+	questionID := c.Param("questionId")
+
+	var req SetQuestionKnowledgeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "请求参数错误",
+		})
+		return
+	}
+
+	var question models.Question
+	if err := tenantDB(c).Preload("Exercise").First(&question, questionID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"message": "题目不存在或无权限",
+		})
+		return
+	}
+	if !requirePermission(c, models.PermExerciseManage, question.Exercise.CourseID) {
+		return
+	}
+
+	// 知识点必须属于同一课程
+	if req.KnowledgeID != nil {
+		var knowledge models.Knowledge
+		if err := tenantDB(c).Preload("Chapter").First(&knowledge, *req.KnowledgeID).Error; err != nil ||
+			knowledge.Chapter.CourseID != question.Exercise.CourseID {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    400,
+				"message": "知识点不存在或不属于该课程",
+			})
+			return
+		}
+	}
+
+	before := gin.H{"knowledge_id": question.KnowledgeID}
+	if err := tenantDB(c).Model(&question).Update("knowledge_id", req.KnowledgeID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "关联知识点失败",
+		})
+		return
+	}
+	recordAuditChange(c, "question.knowledge_update", "question", question.ID, before, gin.H{"knowledge_id": req.KnowledgeID}, nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "关联成功",
+		"data":    question,
+	})
+}
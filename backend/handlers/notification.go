@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"backend/middleware"
+	"backend/models"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 获取我的通知
+func GetNotifications(c *gin.Context) {
+	userID := middleware.GetCurrentUserID(c)
+
+	query := tenantDB(c).Where("user_id = ?", userID)
+	if c.Query("unread") == "1" {
+		query = query.Where("is_read = ?", false)
+	}
+
+	var notifications []models.Notification
+	if err := query.Order("created_at DESC").Limit(100).Find(&notifications).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "获取通知失败",
+		})
+		return
+	}
+
+	var unreadCount int64
+	tenantDB(c).Model(&models.Notification{}).Where("user_id = ? AND is_read = ?", userID, false).Count(&unreadCount)
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "获取成功",
+		"data": gin.H{
+			"notifications": notifications,
+			"unread_count":  unreadCount,
+		},
+	})
+}
+
+// 标记通知为已读
+func MarkNotificationRead(c *gin.Context) {
+	userID := middleware.GetCurrentUserID(c)
+	notificationID := c.Param("id")
+
+	var notification models.Notification
+	if err := tenantDB(c).Where("id = ? AND user_id = ?", notificationID, userID).First(&notification).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"message": "通知不存在",
+		})
+		return
+	}
+
+	if err := tenantDB(c).Model(&notification).Updates(map[string]interface{}{
+		"is_read": true,
+		"read_at": time.Now(),
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "更新通知失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "已读",
+	})
+}
+
+// 全部标记为已读
+func MarkAllNotificationsRead(c *gin.Context) {
+	userID := middleware.GetCurrentUserID(c)
+
+	if err := tenantDB(c).Model(&models.Notification{}).
+		Where("user_id = ? AND is_read = ?", userID, false).
+		Updates(map[string]interface{}{
+			"is_read": true,
+			"read_at": time.Now(),
+		}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "更新通知失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "已全部标记为已读",
+	})
+}
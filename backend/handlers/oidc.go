@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"backend/models"
+	"backend/services"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 发起登录时写入浏览器的state，回调时与身份提供方带回的state比对
+const (
+	oidcStateCookie     = "oidc_state"
+	oidcStateCookiePath = "/api/v1/auth/oidc"
+)
+
+type OIDCTokenRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// 只允许站内相对路径，防止登录后被跳转到外部站点
+func safeRedirectPath(redirect string) string {
+	if !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") || strings.Contains(redirect, "\\") {
+		return ""
+	}
+	return redirect
+}
+
+// 跳转回前端登录回调页面，附带一次性登录code或错误信息
+func redirectToFrontend(c *gin.Context, frontendURL string, params url.Values) {
+	target, err := url.Parse(frontendURL)
+	if err != nil || frontendURL == "" {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "未配置登录回调页面",
+		})
+		return
+	}
+	query := target.Query()
+	for k, v := range params {
+		query[k] = v
+	}
+	target.RawQuery = query.Encode()
+	c.Redirect(http.StatusFound, target.String())
+}
+
+// 请求是否经由HTTPS访问，用于设置cookie的Secure属性
+func isHTTPS(c *gin.Context) bool {
+	return c.Request.TLS != nil || strings.EqualFold(c.GetHeader("X-Forwarded-Proto"), "https")
+}
+
+// 发起统一身份认证登录，跳转到身份提供方
+func OIDCLogin(c *gin.Context) {
+	authURL, state, err := services.NewOIDCService().AuthURL(tenantID(c), safeRedirectPath(c.Query("redirect")))
+	if err != nil {
+		if errors.Is(err, services.ErrOIDCDisabled) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"code":    404,
+				"message": err.Error(),
+			})
+			return
+		}
+		log.Printf("发起统一身份认证登录失败: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{
+			"code":    502,
+			"message": "身份提供方暂不可用",
+		})
+		return
+	}
+	// 身份提供方回调是顶级跳转，Lax模式下会携带该cookie
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, state, services.OIDCStateTTL(), oidcStateCookiePath, "", isHTTPS(c), true)
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// 身份提供方回调：校验身份后生成一次性登录code并跳转回前端
+func OIDCCallback(c *gin.Context) {
+	oidcService := services.NewOIDCService()
+	frontendURL := oidcService.FrontendURL()
+
+	if errCode := c.Query("error"); errCode != "" {
+		redirectToFrontend(c, frontendURL, url.Values{"error": {"身份提供方拒绝了登录请求: " + errCode}})
+		return
+	}
+
+	// state cookie只能使用一次
+	browserState, _ := c.Cookie(oidcStateCookie)
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, "", -1, oidcStateCookiePath, "", isHTTPS(c), true)
+
+	user, redirect, err := oidcService.HandleCallback(c.Query("code"), c.Query("state"), browserState)
+	if err != nil {
+		message := err.Error()
+		if !errors.Is(err, services.ErrOIDCDisabled) &&
+			!errors.Is(err, services.ErrOIDCInvalidState) &&
+			!errors.Is(err, services.ErrExternalAccountNotFound) &&
+			!errors.Is(err, services.ErrExternalAccountNoEmail) &&
+			!errors.Is(err, services.ErrExternalEmailConflict) {
+			log.Printf("统一身份认证回调失败: %v", err)
+			message = "统一身份认证登录失败"
+		}
+		redirectToFrontend(c, frontendURL, url.Values{"error": {message}})
+		return
+	}
+
+	switch user.Status {
+	case models.UserStatusDisabled:
+		redirectToFrontend(c, frontendURL, url.Values{"error": {"账户已被禁用"}})
+		return
+	case models.UserStatusPending:
+		redirectToFrontend(c, frontendURL, url.Values{"error": {"账户待管理员审核"}})
+		return
+	}
+
+	code, err := oidcService.IssueLoginCode(user.ID)
+	if err != nil {
+		redirectToFrontend(c, frontendURL, url.Values{"error": {"生成登录凭证失败"}})
+		return
+	}
+	params := url.Values{"code": {code}}
+	if redirect != "" {
+		params.Set("redirect", redirect)
+	}
+	redirectToFrontend(c, frontendURL, params)
+}
+
+// 前端用一次性登录code换取平台令牌，已启用或角色要求双因素认证时与密码登录一样先返回登录挑战
+func OIDCToken(c *gin.Context) {
+	var req OIDCTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "请求参数错误",
+		})
+		return
+	}
+
+	userID, err := services.NewOIDCService().ExchangeLoginCode(req.Code)
+	if err != nil {
+		if errors.Is(err, services.ErrOIDCInvalidCode) {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"code":    401,
+				"message": err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "登录失败",
+		})
+		return
+	}
+
+	var user models.User
+	if err := tenantDB(c).First(&user, userID).Error; err != nil || user.Status != models.UserStatusActive {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"code":    401,
+			"message": services.ErrOIDCInvalidCode.Error(),
+		})
+		return
+	}
+
+	if startTwoFactorChallenge(c, &user) {
+		return
+	}
+	respondLoginSuccess(c, &user, nil)
+}
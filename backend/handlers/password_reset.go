@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"backend/services"
+	"backend/utils"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PasswordResetRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type PasswordResetConfirmRequest struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required"`
+}
+
+// 申请重置密码，无论邮箱是否存在都返回相同结果
+func RequestPasswordReset(c *gin.Context) {
+	var req PasswordResetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "请求参数错误",
+		})
+		return
+	}
+
+	// 异步发送邮件，响应时间不因账户是否存在而不同
+	go func(tenantID uint, email string) {
+		if err := services.NewPasswordResetService().RequestReset(tenantID, email); err != nil {
+			log.Printf("发送重置密码邮件失败: %v", err)
+		}
+	}(tenantID(c), req.Email)
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "如果该邮箱已注册，重置密码邮件将很快送达",
+	})
+}
+
+// 使用邮件中的令牌设置新密码
+func ConfirmPasswordReset(c *gin.Context) {
+	var req PasswordResetConfirmRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "请求参数错误",
+		})
+		return
+	}
+
+	if err := services.NewPasswordResetService().ConfirmReset(req.Token, req.NewPassword); err != nil {
+		var weak utils.WeakPasswordError
+		if errors.Is(err, services.ErrInvalidResetToken) || errors.Is(err, services.ErrDirectoryManagedPassword) ||
+			errors.As(err, &weak) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    400,
+				"message": err.Error(),
+			})
+			return
+		}
+		log.Printf("重置密码失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "重置密码失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "密码已重置，请使用新密码登录",
+	})
+}
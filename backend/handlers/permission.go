@@ -0,0 +1,293 @@
+package handlers
+
+import (
+	"backend/middleware"
+	"backend/models"
+	"backend/services"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type UpdateRolePermissionsRequest struct {
+	Permissions []string `json:"permissions"`
+}
+
+type AddCourseMemberRequest struct {
+	UserID   uint   `json:"user_id"`
+	Username string `json:"username"`
+	Role     string `json:"role" binding:"required,oneof=co_teacher assistant"`
+}
+
+// 获取课程，不存在时直接返回404；权限由路由上的RequirePermission校验
+func findCourse(c *gin.Context, courseID string) (*models.Course, bool) {
+	var course models.Course
+	if err := tenantDB(c).First(&course, courseID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"message": "课程不存在或无权限",
+		})
+		return nil, false
+	}
+	return &course, true
+}
+
+// 检查当前用户在课程内是否拥有权限，无权限时直接返回403
+func requirePermission(c *gin.Context, permission string, courseID uint) bool {
+	if hasPermission(c, permission, courseID) {
+		return true
+	}
+	c.JSON(http.StatusForbidden, gin.H{
+		"code":    403,
+		"message": "权限不足",
+	})
+	return false
+}
+
+func hasPermission(c *gin.Context, permission string, courseID uint) bool {
+	return middleware.Can(c, permission, courseID)
+}
+
+// 获取我的权限，指定course_id时包含课程内角色的权限
+func GetMyPermissions(c *gin.Context) {
+	userID := middleware.GetCurrentUserID(c)
+	courseID := parseUint(c.Query("course_id"))
+	permissionService := services.NewPermissionService()
+
+	// 服务账户的权限来自授权范围，与课程内角色无关
+	if middleware.IsAPIKeyRequest(c) {
+		scopes := c.GetStringSlice("api_scopes")
+		c.JSON(http.StatusOK, gin.H{
+			"code":    200,
+			"message": "获取成功",
+			"data": gin.H{
+				"role":        middleware.GetCurrentUserRole(c),
+				"scopes":      scopes,
+				"permissions": services.NewAPIKeyService().ScopePermissions(scopes),
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "获取成功",
+		"data": gin.H{
+			"role":        middleware.GetCurrentUserRole(c),
+			"course_role": permissionService.CourseRole(userID, courseID),
+			"permissions": permissionService.Permissions(userID, middleware.GetCurrentUserRole(c), courseID),
+		},
+	})
+}
+
+// 获取所有权限（管理员）
+func AdminListPermissions(c *gin.Context) {
+	var permissions []models.Permission
+	if err := tenantDB(c).Order("id ASC").Find(&permissions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "获取权限列表失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "获取成功",
+		"data":    permissions,
+	})
+}
+
+// 获取角色及其权限（管理员）
+func AdminListRoles(c *gin.Context) {
+	var roles []models.Role
+	if err := tenantDB(c).Preload("Permissions").Order("id ASC").Find(&roles).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "获取角色列表失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "获取成功",
+		"data":    roles,
+	})
+}
+
+// 设置角色拥有的权限（管理员）
+func AdminUpdateRolePermissions(c *gin.Context) {
+	var req UpdateRolePermissionsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "请求参数错误",
+		})
+		return
+	}
+
+	var role models.Role
+	if err := tenantDB(c).Preload("Permissions").First(&role, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"message": "角色不存在",
+		})
+		return
+	}
+	before := permissionCodes(role.Permissions)
+
+	if err := services.NewPermissionService().SetRolePermissions(&role, req.Permissions); err != nil {
+		if errors.Is(err, services.ErrUnknownPermission) || errors.Is(err, services.ErrRoleLockout) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"code":    400,
+				"message": err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "更新角色权限失败",
+		})
+		return
+	}
+
+	recordAuditChange(c, "role.permissions", "role", role.ID,
+		gin.H{"permissions": before}, gin.H{"permissions": permissionCodes(role.Permissions)},
+		gin.H{"role": role.Name})
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "更新成功",
+		"data":    role,
+	})
+}
+
+func permissionCodes(permissions []models.Permission) []string {
+	codes := make([]string, 0, len(permissions))
+	for _, p := range permissions {
+		codes = append(codes, p.Code)
+	}
+	return codes
+}
+
+// 获取课程的协作教师和助教
+func GetCourseMembers(c *gin.Context) {
+	course, ok := findCourse(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	var members []models.CourseMember
+	if err := tenantDB(c).Preload("User").Where("course_id = ?", course.ID).Order("created_at ASC").Find(&members).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "获取课程成员失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "获取成功",
+		"data":    members,
+	})
+}
+
+// 添加或调整协作教师、助教（课程负责人）
+func AddCourseMember(c *gin.Context) {
+	course, ok := findCourse(c, c.Param("courseId"))
+	if !ok {
+		return
+	}
+
+	var req AddCourseMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil || (req.UserID == 0 && req.Username == "") {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "请求参数错误",
+		})
+		return
+	}
+
+	var user models.User
+	query := tenantDB(c).Where("status = ?", models.UserStatusActive)
+	if req.UserID != 0 {
+		query = query.Where("id = ?", req.UserID)
+	} else {
+		query = query.Where("username = ?", req.Username)
+	}
+	if err := query.First(&user).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"message": "用户不存在",
+		})
+		return
+	}
+	// 协作教师必须是教师账户，助教可以是教师或学生
+	if user.ID == course.TeacherID ||
+		(req.Role == models.CourseRoleCoTeacher && user.Role != models.RoleTeacher) ||
+		user.Role.IsAdmin() || user.Role == models.RoleService {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "该用户不能设置为此课程角色",
+		})
+		return
+	}
+
+	member := models.CourseMember{CourseID: course.ID, UserID: user.ID}
+	if err := tenantDB(c).Where(member).Assign(models.CourseMember{Role: req.Role}).FirstOrCreate(&member).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "添加课程成员失败",
+		})
+		return
+	}
+	member.User = user
+
+	recordAudit(c, "course.member_add", "course", course.ID, gin.H{
+		"user_id": user.ID,
+		"role":    req.Role,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "添加成功",
+		"data":    member,
+	})
+}
+
+// 移除协作教师、助教（课程负责人）
+func RemoveCourseMember(c *gin.Context) {
+	course, ok := findCourse(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	userID := parseUint(c.Param("userId"))
+	result := tenantDB(c).Where("course_id = ? AND user_id = ?", course.ID, userID).Delete(&models.CourseMember{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "移除课程成员失败",
+		})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"message": "课程成员不存在",
+		})
+		return
+	}
+
+	recordAudit(c, "course.member_remove", "course", course.ID, gin.H{
+		"user_id": userID,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "移除成功",
+	})
+}
@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"backend/models"
+	"backend/services"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AccountDeletionRequest struct {
+	Password string `json:"password" binding:"required"`
+	Reason   string `json:"reason" binding:"max=500"`
+}
+
+type AdminAnonymizeUserRequest struct {
+	Reason string `json:"reason" binding:"required,max=500"`
+}
+
+// 隐私相关错误统一转换为响应
+func respondPrivacyError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrExportInProgress),
+		errors.Is(err, services.ErrDeletionPending),
+		errors.Is(err, services.ErrAlreadyAnonymized):
+		c.JSON(http.StatusConflict, gin.H{
+			"code":    409,
+			"message": err.Error(),
+		})
+	case errors.Is(err, services.ErrDeletionAdmin):
+		c.JSON(http.StatusForbidden, gin.H{
+			"code":    403,
+			"message": err.Error(),
+		})
+	case errors.Is(err, services.ErrDeletionNotFound), errors.Is(err, services.ErrExportNotReady):
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"message": err.Error(),
+		})
+	default:
+		log.Printf("%s: %v", message, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": message,
+		})
+	}
+}
+
+// 申请导出个人数据
+func RequestDataExport(c *gin.Context) {
+	var user models.User
+	if err := tenantDB(c).First(&user, c.GetUint("user_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"message": "用户不存在",
+		})
+		return
+	}
+
+	export, err := services.NewPrivacyService().RequestExport(&user)
+	if err != nil {
+		respondPrivacyError(c, err, "申请数据导出失败")
+		return
+	}
+	recordAudit(c, "privacy.export_request", "data_export", export.ID, nil)
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"code":    202,
+		"message": "已开始生成，完成后会通过站内通知提醒您下载",
+		"data":    export,
+	})
+}
+
+// 我的数据导出记录
+func GetMyDataExports(c *gin.Context) {
+	var exports []models.DataExport
+	if err := tenantDB(c).Where("user_id = ?", c.GetUint("user_id")).
+		Order("id DESC").Limit(20).Find(&exports).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "获取导出记录失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "获取成功",
+		"data":    exports,
+	})
+}
+
+// 下载数据导出文件，只能下载自己的导出
+func DownloadDataExport(c *gin.Context) {
+	var export models.DataExport
+	if err := tenantDB(c).Where("id = ? AND user_id = ?", c.Param("id"), c.GetUint("user_id")).
+		First(&export).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"message": "导出记录不存在",
+		})
+		return
+	}
+
+	path, err := services.NewPrivacyService().ExportFile(&export)
+	if err != nil {
+		respondPrivacyError(c, err, "下载失败")
+		return
+	}
+	recordAudit(c, "privacy.export_download", "data_export", export.ID, nil)
+
+	c.FileAttachment(path, fmt.Sprintf("my-data-%s.zip", export.CreatedAt.Format("20060102")))
+}
+
+// 查看我的注销申请
+func GetAccountDeletion(c *gin.Context) {
+	deletion, err := services.NewPrivacyService().PendingDeletion(c.GetUint("user_id"))
+	if err != nil {
+		respondPrivacyError(c, err, "获取注销申请失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "获取成功",
+		"data":    deletion,
+	})
+}
+
+// 申请注销账户，需要再次验证密码，冷静期结束后执行
+func RequestAccountDeletion(c *gin.Context) {
+	var req AccountDeletionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "请求参数错误",
+		})
+		return
+	}
+
+	var user models.User
+	if err := tenantDB(c).First(&user, c.GetUint("user_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"message": "用户不存在",
+		})
+		return
+	}
+	if verified, err := authenticatePassword(c, user.Username, req.Password); err != nil || verified.ID != user.ID {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "密码错误",
+		})
+		return
+	}
+
+	privacyService := services.NewPrivacyService()
+	deletion, err := privacyService.RequestDeletion(&user, req.Reason)
+	if err != nil {
+		respondPrivacyError(c, err, "申请注销失败")
+		return
+	}
+	recordAudit(c, "user.deletion_request", "user", user.ID, gin.H{
+		"deletion_id":  deletion.ID,
+		"scheduled_at": deletion.ScheduledAt,
+	})
+	services.NewNotificationService().Notify(user.ID, "system", "账户注销申请已提交",
+		fmt.Sprintf("您的账户将于%s注销，之前可在个人设置中撤销。", deletion.ScheduledAt.Format("2006-01-02 15:04")),
+		"/profile/privacy")
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "注销申请已提交",
+		"data":    deletion,
+	})
+}
+
+// 撤销注销申请
+func CancelAccountDeletion(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	deletion, err := services.NewPrivacyService().CancelDeletion(userID)
+	if err != nil {
+		respondPrivacyError(c, err, "撤销注销申请失败")
+		return
+	}
+	recordAudit(c, "user.deletion_cancel", "user", userID, gin.H{"deletion_id": deletion.ID})
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "已撤销注销申请",
+	})
+}
+
+// 注销申请列表（管理员）
+func AdminListAccountDeletions(c *gin.Context) {
+	page, pageSize := getPagination(c)
+
+	query := tenantDB(c).Model(&models.AccountDeletion{})
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "获取注销申请失败",
+		})
+		return
+	}
+
+	var deletions []models.AccountDeletion
+	if err := query.Order("id DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&deletions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "获取注销申请失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "获取成功",
+		"data": gin.H{
+			"list":      deletions,
+			"total":     total,
+			"page":      page,
+			"page_size": pageSize,
+		},
+	})
+}
+
+// 立即注销并匿名化用户（管理员），不可恢复
+func AdminAnonymizeUser(c *gin.Context) {
+	user, ok := findUser(c, true)
+	if !ok || rejectSelf(c, user) {
+		return
+	}
+
+	var req AdminAnonymizeUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "请填写注销原因",
+		})
+		return
+	}
+
+	username := user.Username
+	deletion, err := services.NewPrivacyService().DeleteNow(user, c.GetUint("user_id"), req.Reason)
+	if err != nil {
+		respondPrivacyError(c, err, "注销用户失败")
+		return
+	}
+	recordAudit(c, "user.anonymize", "user", user.ID, gin.H{
+		"deletion_id": deletion.ID,
+		"reason":      req.Reason,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": fmt.Sprintf("用户%s已注销", username),
+		"data":    deletion,
+	})
+}
@@ -0,0 +1,326 @@
+package handlers
+
+import (
+	"backend/database"
+	"backend/models"
+	"backend/utils"
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	_ "golang.org/x/image/webp"
+	"gorm.io/gorm"
+)
+
+const (
+	maxAvatarSize      = 2 << 20 // 头像文件大小上限
+	maxAvatarDimension = 4096    // 头像宽高上限，防止超大图片解码占用过多内存
+	avatarThumbSize    = 128     // 头像缩略图边长
+)
+
+// 允许上传的头像类型及保存时使用的扩展名
+var avatarContentTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// 字段为空指针时不修改
+type UpdateProfileRequest struct {
+	RealName   *string `json:"real_name"`
+	Email      *string `json:"email"`
+	Phone      *string `json:"phone"`
+	Department *string `json:"department"`
+	Major      *string `json:"major"`
+	Grade      *string `json:"grade"`
+	Class      *string `json:"class"`
+	StudentID  *string `json:"student_id"`
+	TeacherID  *string `json:"teacher_id"`
+}
+
+// 更新个人资料
+func UpdateProfile(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	var req UpdateProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "请求参数错误",
+		})
+		return
+	}
+
+	var user models.User
+	if err := tenantDB(c).First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"message": "用户不存在",
+		})
+		return
+	}
+
+	if message := validateProfileRequest(&req, &user); message != "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": message,
+		})
+		return
+	}
+
+	userUpdates := map[string]interface{}{}
+	if req.RealName != nil {
+		userUpdates["real_name"] = *req.RealName
+	}
+	if req.Email != nil && *req.Email != user.Email {
+		userUpdates["email"] = *req.Email
+		userUpdates["email_unverified"] = true
+	}
+	if req.Phone != nil {
+		userUpdates["phone"] = *req.Phone
+	}
+
+	profileUpdates := map[string]interface{}{}
+	for column, value := range map[string]*string{
+		"department": req.Department,
+		"major":      req.Major,
+		"grade":      req.Grade,
+		"class":      req.Class,
+		"student_id": req.StudentID,
+		"teacher_id": req.TeacherID,
+	} {
+		if value != nil {
+			profileUpdates[column] = *value
+		}
+	}
+
+	var profile models.UserProfile
+	err := tenantDB(c).Transaction(func(tx *gorm.DB) error {
+		if len(userUpdates) > 0 {
+			if err := tx.Model(&user).Updates(userUpdates).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Where(models.UserProfile{UserID: user.ID}).FirstOrCreate(&profile).Error; err != nil {
+			return err
+		}
+		if len(profileUpdates) > 0 {
+			return tx.Model(&profile).Updates(profileUpdates).Error
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "更新个人资料失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "更新成功",
+		"data": gin.H{
+			"id":        user.ID,
+			"username":  user.Username,
+			"email":     user.Email,
+			"real_name": user.RealName,
+			"role":      user.Role,
+			"avatar":    user.Avatar,
+			"phone":     user.Phone,
+			"status":    user.Status,
+			"profile":   profile,
+		},
+	})
+}
+
+// 校验个人资料修改，返回错误信息
+func validateProfileRequest(req *UpdateProfileRequest, user *models.User) string {
+	for _, field := range []*string{req.RealName, req.Email, req.Phone, req.Department, req.Major,
+		req.Grade, req.Class, req.StudentID, req.TeacherID} {
+		if field != nil {
+			*field = strings.TrimSpace(*field)
+		}
+	}
+
+	if req.RealName != nil && (*req.RealName == "" || len([]rune(*req.RealName)) > 50) {
+		return "姓名不能为空且不超过50个字符"
+	}
+	if req.Phone != nil && len(*req.Phone) > 20 {
+		return "手机号格式错误"
+	}
+	if req.StudentID != nil && user.Role != models.RoleStudent {
+		return "只有学生可以设置学号"
+	}
+	if req.TeacherID != nil && user.Role != models.RoleTeacher {
+		return "只有教师可以设置工号"
+	}
+
+	if req.Email != nil && *req.Email != user.Email {
+		if addr, err := mail.ParseAddress(*req.Email); err != nil || addr.Address != *req.Email || len(*req.Email) > 100 {
+			return "邮箱格式错误"
+		}
+		var count int64
+		database.ForTenant(user.TenantID).Unscoped().Model(&models.User{}).Where("email = ? AND id <> ?", *req.Email, user.ID).Count(&count)
+		if count > 0 {
+			return "邮箱已被使用"
+		}
+	}
+
+	if req.StudentID != nil && *req.StudentID != "" {
+		var count int64
+		database.DB.Model(&models.UserProfile{}).
+			Joins("JOIN users ON users.id = user_profiles.user_id").
+			Where("users.tenant_id = ? AND user_profiles.student_id = ? AND user_profiles.user_id <> ?", user.TenantID, *req.StudentID, user.ID).
+			Count(&count)
+		if count > 0 {
+			return "学号已存在"
+		}
+	}
+	return ""
+}
+
+// 上传头像，保存原图并生成缩略图
+func UploadAvatar(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	file, err := c.FormFile("avatar")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "未选择文件",
+		})
+		return
+	}
+	if file.Size > maxAvatarSize {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "头像大小不能超过2MB",
+		})
+		return
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "读取文件失败",
+		})
+		return
+	}
+	defer src.Close()
+
+	data, err := io.ReadAll(io.LimitReader(src, maxAvatarSize+1))
+	if err != nil || len(data) > maxAvatarSize {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "头像大小不能超过2MB",
+		})
+		return
+	}
+
+	// 按文件内容判断类型，不信任扩展名
+	ext, ok := avatarContentTypes[http.DetectContentType(data)]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "仅支持JPG、PNG、GIF、WEBP格式的图片",
+		})
+		return
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || cfg.Width > maxAvatarDimension || cfg.Height > maxAvatarDimension {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "图片无法识别或尺寸过大",
+		})
+		return
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "图片无法识别",
+		})
+		return
+	}
+
+	uploadDir := fmt.Sprintf(utils.AvatarUploadDir, tenantID(c))
+	os.MkdirAll(uploadDir, os.ModePerm)
+	name := fmt.Sprintf("%d_%s", userID, time.Now().Format("20060102150405"))
+	originalPath := filepath.Join(uploadDir, name+ext)
+	thumbPath := filepath.Join(uploadDir, name+"_thumb.png")
+
+	if err := os.WriteFile(originalPath, data, 0644); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "文件保存失败",
+		})
+		return
+	}
+	var thumb bytes.Buffer
+	if err := png.Encode(&thumb, utils.GenerateThumbnail(img, avatarThumbSize)); err != nil ||
+		os.WriteFile(thumbPath, thumb.Bytes(), 0644) != nil {
+		os.Remove(originalPath)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "生成缩略图失败",
+		})
+		return
+	}
+
+	var user models.User
+	if err := tenantDB(c).First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"message": "用户不存在",
+		})
+		return
+	}
+	previous := user.Avatar
+
+	avatarURL := "/" + filepath.ToSlash(thumbPath)
+	if err := tenantDB(c).Model(&user).Update("avatar", avatarURL).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "更新头像失败",
+		})
+		return
+	}
+	utils.RemoveAvatarFiles(tenantID(c), previous)
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "上传成功",
+		"data": gin.H{
+			"avatar": avatarURL,
+		},
+	})
+}
+
+// 头像缩略图公开访问（页面中的<img>无法携带令牌），不提供头像原图和其他上传文件
+func ServeAvatar(c *gin.Context) {
+	file := filepath.Base(c.Param("file"))
+	if !strings.HasSuffix(file, "_thumb.png") {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	dir := utils.LegacyAvatarDir
+	if tenant := c.Param("tenantId"); tenant != "" {
+		dir = fmt.Sprintf(utils.AvatarUploadDir, parseUint(tenant))
+	}
+	c.File(filepath.Join(dir, file))
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"backend/middleware"
+	"backend/models"
+	"backend/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ProgressHeartbeatRequest struct {
+	ChapterID uint `json:"chapter_id" binding:"required"`
+}
+
+// 学习心跳，用于累计学习时长
+func ProgressHeartbeat(c *gin.Context) {
+	userID := middleware.GetCurrentUserID(c)
+
+	var req ProgressHeartbeatRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "请求参数错误",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	var chapter models.Chapter
+	if err := tenantDB(c).First(&chapter, req.ChapterID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"message": "章节不存在",
+		})
+		return
+	}
+
+	if !requireCourseAccess(c, chapter.CourseID) {
+		return
+	}
+
+	progress, err := services.NewProgressService().Heartbeat(userID, chapter.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "记录学习时长失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "记录成功",
+		"data":    progress,
+	})
+}
+
+// 标记章节完成
+func CompleteChapterProgress(c *gin.Context) {
+	userID := middleware.GetCurrentUserID(c)
+	chapterID := parseUint(c.Param("chapterId"))
+
+	var chapter models.Chapter
+	if err := tenantDB(c).First(&chapter, chapterID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"message": "章节不存在",
+		})
+		return
+	}
+
+	if !requireCourseAccess(c, chapter.CourseID) {
+		return
+	}
+
+	progress, err := services.NewProgressService().CompleteChapter(userID, chapter.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "更新学习进度失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "章节已完成",
+		"data":    progress,
+	})
+}
+
+// 标记知识点完成
+func CompleteKnowledgeProgress(c *gin.Context) {
+	userID := middleware.GetCurrentUserID(c)
+	knowledgeID := parseUint(c.Param("knowledgeId"))
+
+	var knowledge models.Knowledge
+	if err := tenantDB(c).Preload("Chapter").First(&knowledge, knowledgeID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"message": "知识点不存在",
+		})
+		return
+	}
+
+	if !requireCourseAccess(c, knowledge.Chapter.CourseID) {
+		return
+	}
+
+	progress, err := services.NewProgressService().CompleteKnowledge(userID, knowledge.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "更新学习进度失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "知识点已完成",
+		"data":    progress,
+	})
+}
+
+// 获取我的学习进度
+func GetMyProgress(c *gin.Context) {
+	userID := middleware.GetCurrentUserID(c)
+	courseID := c.Query("course_id")
+
+	var progress []models.LearningProgress
+	query := tenantDB(c).Preload("Course").Preload("Chapter").Where("user_id = ?", userID)
+	if courseID != "" {
+		query = query.Where("course_id = ?", courseID)
+	}
+
+	if err := query.Order("last_study_at DESC").Find(&progress).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "获取学习进度失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "获取成功",
+		"data":    progress,
+	})
+}
+
+// 获取课程学习进度汇总
+func GetCourseProgress(c *gin.Context) {
+	userID := middleware.GetCurrentUserID(c)
+	courseID := parseUint(c.Param("courseId"))
+
+	var course models.Course
+	if err := tenantDB(c).First(&course, courseID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"message": "课程不存在",
+		})
+		return
+	}
+
+	if !requireCourseAccess(c, course.ID) {
+		return
+	}
+
+	rollup, err := services.NewProgressService().CourseRollup(userID, course.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "获取学习进度失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "获取成功",
+		"data":    rollup,
+	})
+}
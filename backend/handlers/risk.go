@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"backend/models"
+	"backend/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type UpdateRiskAlertRequest struct {
+	Status models.RiskAlertStatus `json:"status" binding:"required,oneof=open acknowledged resolved"`
+	Note   string                 `json:"note"`
+}
+
+// 获取课程学业风险预警列表（教师）
+func GetCourseRiskAlerts(c *gin.Context) {
+	course, ok := findCourse(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	query := tenantDB(c).Preload("User").Where("course_id = ?", course.ID)
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	} else {
+		query = query.Where("status <> ?", models.RiskAlertResolved)
+	}
+	if level := c.Query("level"); level != "" {
+		query = query.Where("level = ?", level)
+	}
+
+	var alerts []models.RiskAlert
+	if err := query.Order("score DESC, evaluated_at DESC").Find(&alerts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "获取预警列表失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "获取成功",
+		"data":    alerts,
+	})
+}
+
+// 立即评估课程学生风险（教师）
+func EvaluateCourseRisk(c *gin.Context) {
+	course, ok := findCourse(c, c.Param("courseId"))
+	if !ok {
+		return
+	}
+
+	alerted, err := services.NewRiskService().EvaluateCourse(course.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "风险评估失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "评估完成",
+		"data": gin.H{
+			"alerted": alerted,
+		},
+	})
+}
+
+// 更新预警处理状态（教师）
+func UpdateRiskAlert(c *gin.Context) {
+	alertID := c.Param("id")
+
+	var req UpdateRiskAlertRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "请求参数错误",
+		})
+		return
+	}
+
+	var alert models.RiskAlert
+	if err := tenantDB(c).Preload("Course").First(&alert, alertID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"message": "预警不存在或无权限",
+		})
+		return
+	}
+	if !requirePermission(c, models.PermCourseAnalytics, alert.CourseID) {
+		return
+	}
+
+	before := gin.H{"status": alert.Status, "note": alert.Note}
+	updates := map[string]interface{}{
+		"status": req.Status,
+		"note":   req.Note,
+	}
+	if err := tenantDB(c).Model(&alert).Updates(updates).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "更新预警失败",
+		})
+		return
+	}
+	recordAuditChange(c, "risk_alert.update", "risk_alert", alert.ID, before, gin.H{"status": alert.Status, "note": alert.Note}, nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "更新成功",
+		"data":    alert,
+	})
+}
@@ -0,0 +1,390 @@
+package handlers
+
+import (
+	"backend/middleware"
+	"backend/models"
+	"backend/services"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultAPIKeyDays = 90
+
+type CreateServiceAccountRequest struct {
+	Name        string   `json:"name" binding:"required,max=50"`
+	Description string   `json:"description" binding:"max=255"`
+	Scopes      []string `json:"scopes" binding:"required"`
+}
+
+type UpdateServiceAccountRequest struct {
+	Name        *string  `json:"name" binding:"omitempty,min=1,max=50"`
+	Description *string  `json:"description" binding:"omitempty,max=255"`
+	Scopes      []string `json:"scopes"`
+	Status      *int     `json:"status" binding:"omitempty,oneof=0 1"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name          string `json:"name" binding:"max=50"`
+	ExpiresInDays int    `json:"expires_in_days" binding:"omitempty,min=1,max=730"`
+}
+
+type RotateAPIKeyRequest struct {
+	GraceHours    int `json:"grace_hours" binding:"min=0,max=168"` // 旧密钥继续可用的小时数，0表示立即失效
+	ExpiresInDays int `json:"expires_in_days" binding:"omitempty,min=1,max=730"`
+}
+
+// API密钥拥有课程相关的授权范围时，可以查看本校的所有课程
+func apiKeyCourseAccess(c *gin.Context, courseID uint) bool {
+	if !middleware.IsAPIKeyRequest(c) {
+		return false
+	}
+	for _, permission := range []string{models.PermCourseAnalytics, models.PermCourseStudents, models.PermCourseUpdate} {
+		if hasPermission(c, permission, courseID) {
+			return true
+		}
+	}
+	return false
+}
+
+// 查看课程详情、统计和资料：API密钥按授权范围判断，其他用户需要可以访问该课程
+func requireCourseView(c *gin.Context, courseID uint) bool {
+	if apiKeyCourseAccess(c, courseID) {
+		return true
+	}
+	return requireCourseAccess(c, courseID)
+}
+
+// 授权范围或服务账户错误统一转换为响应
+func respondServiceAccountError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrUnknownScope), errors.Is(err, services.ErrScopeRequired),
+		errors.Is(err, services.ErrAPIKeyExpired):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": err.Error(),
+		})
+	case errors.Is(err, services.ErrUserQuotaExceeded), errors.Is(err, services.ErrTenantDisabled),
+		errors.Is(err, services.ErrTenantNotFound):
+		respondTenantError(c, err, message)
+	default:
+		log.Printf("%s: %v", message, err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": message,
+		})
+	}
+}
+
+// 查找本校的服务账户，不存在时直接返回404
+func findServiceAccount(c *gin.Context) (*models.ServiceAccount, bool) {
+	var account models.ServiceAccount
+	if err := tenantDB(c).Preload("User").First(&account, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"message": "服务账户不存在",
+		})
+		return nil, false
+	}
+	return &account, true
+}
+
+// 查找服务账户下的密钥，不存在时直接返回404
+func findAPIKey(c *gin.Context, account *models.ServiceAccount) (*models.APIKey, bool) {
+	var key models.APIKey
+	if err := tenantDB(c).Where("service_account_id = ?", account.ID).First(&key, c.Param("keyId")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"message": "API密钥不存在",
+		})
+		return nil, false
+	}
+	return &key, true
+}
+
+// 可授予服务账户的授权范围（管理员）
+func AdminListAPIScopes(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "获取成功",
+		"data":    services.NewAPIKeyService().Scopes(),
+	})
+}
+
+// 服务账户列表（管理员）
+func AdminListServiceAccounts(c *gin.Context) {
+	var accounts []models.ServiceAccount
+	if err := tenantDB(c).Preload("User").Preload("APIKeys", "revoked_at IS NULL AND expires_at > ?", time.Now()).
+		Order("id DESC").Find(&accounts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "获取服务账户失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "获取成功",
+		"data":    accounts,
+	})
+}
+
+// 创建服务账户（管理员），创建后需再为其生成API密钥
+func AdminCreateServiceAccount(c *gin.Context) {
+	var req CreateServiceAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "请求参数错误",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	apiKeyService := services.NewAPIKeyService()
+	scopes, err := apiKeyService.NormalizeScopes(req.Scopes)
+	if err != nil {
+		respondServiceAccountError(c, err, "创建服务账户失败")
+		return
+	}
+	account, err := apiKeyService.CreateAccount(tenantID(c), req.Name, req.Description, scopes, middleware.GetCurrentUserID(c))
+	if err != nil {
+		respondServiceAccountError(c, err, "创建服务账户失败")
+		return
+	}
+
+	recordAudit(c, "service_account.create", "service_account", account.ID, gin.H{
+		"name":    account.Name,
+		"user_id": account.UserID,
+		"scopes":  account.Scopes,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "创建成功",
+		"data":    account,
+	})
+}
+
+// 服务账户详情及全部密钥（管理员）
+func AdminGetServiceAccount(c *gin.Context) {
+	account, ok := findServiceAccount(c)
+	if !ok {
+		return
+	}
+	if err := tenantDB(c).Where("service_account_id = ?", account.ID).Order("id DESC").Find(&account.APIKeys).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "获取API密钥失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "获取成功",
+		"data":    account,
+	})
+}
+
+// 修改服务账户名称、授权范围或启用状态（管理员），授权范围立即对所有密钥生效
+func AdminUpdateServiceAccount(c *gin.Context) {
+	account, ok := findServiceAccount(c)
+	if !ok {
+		return
+	}
+
+	var req UpdateServiceAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "请求参数错误",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	apiKeyService := services.NewAPIKeyService()
+	updates := map[string]interface{}{}
+	if req.Name != nil {
+		updates["name"] = *req.Name
+	}
+	if req.Description != nil {
+		updates["description"] = *req.Description
+	}
+	if req.Scopes != nil {
+		scopes, err := apiKeyService.NormalizeScopes(req.Scopes)
+		if err != nil {
+			respondServiceAccountError(c, err, "更新服务账户失败")
+			return
+		}
+		updates["scopes"] = scopes
+	}
+
+	before := *account
+	if len(updates) > 0 {
+		if err := tenantDB(c).Model(account).Updates(updates).Error; err != nil {
+			respondServiceAccountError(c, err, "更新服务账户失败")
+			return
+		}
+		if name, ok := updates["name"]; ok {
+			tenantDB(c).Model(&models.User{}).Where("id = ?", account.UserID).Update("real_name", name)
+		}
+	}
+	if req.Status != nil && *req.Status != account.Status {
+		if err := apiKeyService.SetStatus(account, *req.Status); err != nil {
+			respondServiceAccountError(c, err, "更新服务账户失败")
+			return
+		}
+	}
+
+	recordAuditChange(c, "service_account.update", "service_account", account.ID,
+		gin.H{"name": before.Name, "description": before.Description, "scopes": before.Scopes, "status": before.Status},
+		gin.H{"name": account.Name, "description": account.Description, "scopes": account.Scopes, "status": account.Status},
+		nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "更新成功",
+		"data":    account,
+	})
+}
+
+// 删除服务账户（管理员），其全部密钥立即失效
+func AdminDeleteServiceAccount(c *gin.Context) {
+	account, ok := findServiceAccount(c)
+	if !ok {
+		return
+	}
+
+	if err := services.NewAPIKeyService().DeleteAccount(account); err != nil {
+		respondServiceAccountError(c, err, "删除服务账户失败")
+		return
+	}
+	recordAudit(c, "service_account.delete", "service_account", account.ID, gin.H{"name": account.Name})
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "删除成功",
+	})
+}
+
+// 为服务账户生成API密钥（管理员），完整密钥只在本次响应中返回
+func AdminCreateAPIKey(c *gin.Context) {
+	account, ok := findServiceAccount(c)
+	if !ok {
+		return
+	}
+
+	var req CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "请求参数错误",
+			"error":   err.Error(),
+		})
+		return
+	}
+	if req.ExpiresInDays == 0 {
+		req.ExpiresInDays = defaultAPIKeyDays
+	}
+
+	key, token, err := services.NewAPIKeyService().CreateKey(account, req.Name,
+		time.Duration(req.ExpiresInDays)*24*time.Hour, middleware.GetCurrentUserID(c))
+	if err != nil {
+		respondServiceAccountError(c, err, "生成API密钥失败")
+		return
+	}
+	recordAudit(c, "api_key.create", "service_account", account.ID, gin.H{
+		"key_id":     key.ID,
+		"prefix":     key.Prefix,
+		"expires_at": key.ExpiresAt,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "生成成功，请立即保存密钥，之后将无法再次查看",
+		"data": gin.H{
+			"key":     token,
+			"api_key": key,
+		},
+	})
+}
+
+// 轮换API密钥（管理员），生成新密钥，旧密钥在过渡期后失效
+func AdminRotateAPIKey(c *gin.Context) {
+	account, ok := findServiceAccount(c)
+	if !ok {
+		return
+	}
+	old, ok := findAPIKey(c, account)
+	if !ok {
+		return
+	}
+
+	var req RotateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "请求参数错误",
+			"error":   err.Error(),
+		})
+		return
+	}
+	if req.ExpiresInDays == 0 {
+		req.ExpiresInDays = defaultAPIKeyDays
+	}
+
+	key, token, err := services.NewAPIKeyService().RotateKey(account, old,
+		time.Duration(req.GraceHours)*time.Hour, time.Duration(req.ExpiresInDays)*24*time.Hour,
+		middleware.GetCurrentUserID(c))
+	if err != nil {
+		respondServiceAccountError(c, err, "轮换API密钥失败")
+		return
+	}
+	recordAudit(c, "api_key.rotate", "service_account", account.ID, gin.H{
+		"old_key_id":  old.ID,
+		"key_id":      key.ID,
+		"prefix":      key.Prefix,
+		"grace_hours": req.GraceHours,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "轮换成功，请立即保存新密钥，之后将无法再次查看",
+		"data": gin.H{
+			"key":     token,
+			"api_key": key,
+		},
+	})
+}
+
+// 撤销API密钥（管理员），立即失效
+func AdminRevokeAPIKey(c *gin.Context) {
+	account, ok := findServiceAccount(c)
+	if !ok {
+		return
+	}
+	key, ok := findAPIKey(c, account)
+	if !ok {
+		return
+	}
+
+	if err := services.NewAPIKeyService().RevokeKey(key); err != nil {
+		respondServiceAccountError(c, err, "撤销API密钥失败")
+		return
+	}
+	recordAudit(c, "api_key.revoke", "service_account", account.ID, gin.H{
+		"key_id": key.ID,
+		"prefix": key.Prefix,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "已撤销",
+	})
+}
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"backend/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type UnlockIPRequest struct {
+	IP string `json:"ip" binding:"required,ip"`
+}
+
+// 获取我的登录设备列表
+func GetMySessions(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	sessions, err := services.NewTokenService().ListSessions(userID, c.GetString("session_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "获取登录设备失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "获取成功",
+		"data":    sessions,
+	})
+}
+
+// 注销我的某个登录设备
+func RevokeMySession(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	sessionID := c.Param("sessionId")
+
+	tokenService := services.NewTokenService()
+	if ok, err := tokenService.HasSession(userID, sessionID); err != nil || !ok {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"message": "登录设备不存在",
+		})
+		return
+	}
+
+	if err := tokenService.RevokeSession(userID, sessionID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "注销登录设备失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "已注销该设备",
+	})
+}
+
+// 获取用户的登录设备列表（管理员）
+func AdminGetUserSessions(c *gin.Context) {
+	user, ok := findUser(c, false)
+	if !ok {
+		return
+	}
+
+	sessions, err := services.NewTokenService().ListSessions(user.ID, "")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "获取登录设备失败",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "获取成功",
+		"data":    sessions,
+	})
+}
+
+// 强制用户在所有设备上退出登录（管理员）
+func AdminForceLogout(c *gin.Context) {
+	user, ok := findUser(c, false)
+	if !ok {
+		return
+	}
+
+	if err := services.NewTokenService().RevokeAll(user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "强制退出失败",
+		})
+		return
+	}
+
+	recordAudit(c, "user.force_logout", "user", user.ID, nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "已强制退出所有设备",
+	})
+}
+
+// 解除用户的登录锁定（管理员）
+func AdminUnlockUser(c *gin.Context) {
+	user, ok := findUser(c, false)
+	if !ok {
+		return
+	}
+
+	if err := services.NewLoginGuardService().UnlockUser(user.TenantID, user.Username); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "解除锁定失败",
+		})
+		return
+	}
+
+	recordAudit(c, "user.unlock", "user", user.ID, nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "已解除锁定",
+	})
+}
+
+// 解除IP的登录锁定（管理员）
+func AdminUnlockIP(c *gin.Context) {
+	var req UnlockIPRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "请求参数错误",
+		})
+		return
+	}
+
+	if err := services.NewLoginGuardService().UnlockIP(req.IP); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "解除锁定失败",
+		})
+		return
+	}
+
+	recordAudit(c, "ip.unlock", "ip", 0, gin.H{
+		"ip": req.IP,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "已解除锁定",
+	})
+}
@@ -0,0 +1,439 @@
+package handlers
+
+import (
+	"backend/database"
+	"backend/middleware"
+	"backend/models"
+	"backend/services"
+	"backend/utils"
+	"errors"
+	"net/http"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 学校标识用于子域名，只允许小写字母、数字和短横线
+var tenantCodePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,49}$`)
+
+type TenantAIConfigRequest struct {
+	AIProvider *string `json:"ai_provider" binding:"omitempty,oneof=deepseek openai xunfei local"` // 为空字符串时使用全局配置
+	AIAPIKey   *string `json:"ai_api_key"`
+	AIBaseURL  *string `json:"ai_base_url"`
+	AIModel    *string `json:"ai_model"`
+}
+
+type CreateTenantRequest struct {
+	Name            string `json:"name" binding:"required,max=100"`
+	Code            string `json:"code" binding:"required"`
+	MaxUsers        int    `json:"max_users" binding:"min=0"`
+	MaxCourses      int    `json:"max_courses" binding:"min=0"`
+	DailyAIRequests int    `json:"daily_ai_requests" binding:"min=0"`
+	TenantAIConfigRequest
+}
+
+type UpdateTenantRequest struct {
+	Name            *string `json:"name" binding:"omitempty,max=100"`
+	Status          *int    `json:"status" binding:"omitempty,oneof=0 1"`
+	MaxUsers        *int    `json:"max_users" binding:"omitempty,min=0"`
+	MaxCourses      *int    `json:"max_courses" binding:"omitempty,min=0"`
+	DailyAIRequests *int    `json:"daily_ai_requests" binding:"omitempty,min=0"`
+	TenantAIConfigRequest
+}
+
+type CreateTenantAdminRequest struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+	Email    string `json:"email" binding:"required,email"`
+	RealName string `json:"real_name" binding:"required"`
+	Phone    string `json:"phone"`
+}
+
+// 当前请求所属学校ID
+func tenantID(c *gin.Context) uint {
+	return middleware.GetCurrentTenantID(c)
+}
+
+// 限定在当前学校内的查询，带TenantID字段的模型自动追加学校条件
+func tenantDB(c *gin.Context) *gorm.DB {
+	return database.ForTenant(tenantID(c))
+}
+
+// 当前用户是否为平台管理员
+func isSuperAdmin(c *gin.Context) bool {
+	return middleware.GetCurrentUserRole(c) == string(models.RoleSuperAdmin)
+}
+
+// 只有平台管理员可以设置平台管理员角色，API密钥不能设置管理员角色
+func canAssignRole(c *gin.Context, role models.UserRole) bool {
+	if role.IsAdmin() && middleware.IsAPIKeyRequest(c) {
+		return false
+	}
+	return role.IsValid() && (role != models.RoleSuperAdmin || isSuperAdmin(c))
+}
+
+// 配额不足或学校不可用时返回403，其他错误返回500
+func respondTenantError(c *gin.Context, err error, message string) {
+	if errors.Is(err, services.ErrUserQuotaExceeded) ||
+		errors.Is(err, services.ErrCourseQuotaExceeded) ||
+		errors.Is(err, services.ErrAIQuotaExceeded) ||
+		errors.Is(err, services.ErrTenantDisabled) ||
+		errors.Is(err, services.ErrTenantNotFound) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"code":    403,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{
+		"code":    500,
+		"message": message,
+	})
+}
+
+// 使用当前学校的AI配置创建AI服务，并扣减学校当日AI调用配额
+func tenantAIService(c *gin.Context) (*services.AIService, error) {
+	if err := services.NewTenantService().ConsumeAIRequest(tenantID(c)); err != nil {
+		return nil, err
+	}
+	return services.NewAIServiceForTenant(middleware.GetCurrentTenant(c)), nil
+}
+
+// 学校信息，附带用量和是否已设置AI密钥（不返回密钥本身）
+func tenantDetail(tenant *models.Tenant) gin.H {
+	return gin.H{
+		"tenant":         tenant,
+		"ai_api_key_set": tenant.AIAPIKey != "",
+		"usage":          services.NewTenantService().Usage(tenant.ID),
+	}
+}
+
+// 把请求中的AI配置写入updates
+func applyTenantAIConfig(req TenantAIConfigRequest, updates map[string]interface{}) {
+	if req.AIProvider != nil {
+		updates["ai_provider"] = *req.AIProvider
+	}
+	if req.AIBaseURL != nil {
+		updates["ai_base_url"] = *req.AIBaseURL
+	}
+	if req.AIModel != nil {
+		updates["ai_model"] = *req.AIModel
+	}
+	if req.AIAPIKey != nil {
+		updates["ai_api_key"] = *req.AIAPIKey
+	}
+}
+
+// AI密钥不会出现在变更字段中，审计详情里只标记是否修改过
+func tenantUpdateDetail(updates map[string]interface{}) interface{} {
+	if _, ok := updates["ai_api_key"]; ok {
+		return gin.H{"ai_api_key_changed": true}
+	}
+	return nil
+}
+
+// 获取所有学校及用量（平台管理员）
+func PlatformListTenants(c *gin.Context) {
+	var tenants []models.Tenant
+	if err := database.DB.Order("id ASC").Find(&tenants).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "获取学校列表失败",
+		})
+		return
+	}
+
+	list := make([]gin.H, 0, len(tenants))
+	for i := range tenants {
+		list = append(list, tenantDetail(&tenants[i]))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "获取成功",
+		"data":    list,
+	})
+}
+
+// 创建学校（平台管理员）
+func PlatformCreateTenant(c *gin.Context) {
+	var req CreateTenantRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "请求参数错误",
+			"error":   err.Error(),
+		})
+		return
+	}
+	if !tenantCodePattern.MatchString(req.Code) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "学校标识只能包含小写字母、数字和短横线，长度2-50",
+		})
+		return
+	}
+
+	var count int64
+	database.DB.Model(&models.Tenant{}).Where("code = ?", req.Code).Count(&count)
+	if count > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": services.ErrTenantCodeConflict.Error(),
+		})
+		return
+	}
+
+	tenant := models.Tenant{
+		Name:            req.Name,
+		Code:            req.Code,
+		Status:          models.TenantStatusActive,
+		MaxUsers:        req.MaxUsers,
+		MaxCourses:      req.MaxCourses,
+		DailyAIRequests: req.DailyAIRequests,
+	}
+	if req.AIProvider != nil {
+		tenant.AIProvider = *req.AIProvider
+	}
+	if req.AIAPIKey != nil {
+		tenant.AIAPIKey = *req.AIAPIKey
+	}
+	if req.AIBaseURL != nil {
+		tenant.AIBaseURL = *req.AIBaseURL
+	}
+	if req.AIModel != nil {
+		tenant.AIModel = *req.AIModel
+	}
+	if err := database.DB.Create(&tenant).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "创建学校失败",
+		})
+		return
+	}
+
+	recordAudit(c, "tenant.create", "tenant", tenant.ID, gin.H{
+		"name": tenant.Name,
+		"code": tenant.Code,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "创建成功",
+		"data":    tenantDetail(&tenant),
+	})
+}
+
+// 修改学校名称、状态、配额和AI配置（平台管理员）
+func PlatformUpdateTenant(c *gin.Context) {
+	var req UpdateTenantRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "请求参数错误",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	var tenant models.Tenant
+	if err := database.DB.First(&tenant, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"message": services.ErrTenantNotFound.Error(),
+		})
+		return
+	}
+	// 平台管理员属于默认学校，停用后将无法登录
+	if req.Status != nil && *req.Status == models.TenantStatusDisabled &&
+		tenant.Code == services.NewTenantService().DefaultCode() {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "不能停用默认学校",
+		})
+		return
+	}
+
+	updates := map[string]interface{}{}
+	if req.Name != nil {
+		updates["name"] = *req.Name
+	}
+	if req.Status != nil {
+		updates["status"] = *req.Status
+	}
+	if req.MaxUsers != nil {
+		updates["max_users"] = *req.MaxUsers
+	}
+	if req.MaxCourses != nil {
+		updates["max_courses"] = *req.MaxCourses
+	}
+	if req.DailyAIRequests != nil {
+		updates["daily_ai_requests"] = *req.DailyAIRequests
+	}
+	applyTenantAIConfig(req.TenantAIConfigRequest, updates)
+
+	if len(updates) > 0 {
+		before := tenant
+		if err := database.DB.Model(&tenant).Updates(updates).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    500,
+				"message": "修改学校失败",
+			})
+			return
+		}
+		services.InvalidateTenantCache()
+		recordAuditChange(c, "tenant.update", "tenant", tenant.ID, before, tenant, tenantUpdateDetail(updates))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "修改成功",
+		"data":    tenantDetail(&tenant),
+	})
+}
+
+// 为学校创建管理员账户（平台管理员）
+func PlatformCreateTenantAdmin(c *gin.Context) {
+	var req CreateTenantAdminRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "请求参数错误",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	var tenant models.Tenant
+	if err := database.DB.First(&tenant, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"message": services.ErrTenantNotFound.Error(),
+		})
+		return
+	}
+
+	var count int64
+	database.ForTenant(tenant.ID).Unscoped().Model(&models.User{}).
+		Where("username = ? OR email = ?", req.Username, req.Email).Count(&count)
+	if count > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "用户名或邮箱已存在",
+		})
+		return
+	}
+
+	if err := utils.ValidatePasswordStrength(req.Password, req.Username); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	hashedPassword, err := utils.HashPassword(req.Password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "密码加密失败",
+		})
+		return
+	}
+
+	if err := services.NewTenantService().CheckUserQuota(tenant.ID, 1); err != nil {
+		respondTenantError(c, err, "用户创建失败")
+		return
+	}
+
+	user := models.User{
+		TenantID: tenant.ID,
+		Username: req.Username,
+		Password: hashedPassword,
+		Email:    req.Email,
+		RealName: req.RealName,
+		Role:     models.RoleAdmin,
+		Phone:    req.Phone,
+		Status:   models.UserStatusActive,
+	}
+	if err := database.DB.Create(&user).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "用户创建失败",
+		})
+		return
+	}
+
+	recordAudit(c, "tenant.admin_create", "tenant", tenant.ID, gin.H{
+		"user_id":  user.ID,
+		"username": user.Username,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "学校管理员创建成功",
+		"data":    user,
+	})
+}
+
+// 获取本校信息和用量（学校管理员）
+func AdminGetTenant(c *gin.Context) {
+	var tenant models.Tenant
+	if err := database.DB.First(&tenant, tenantID(c)).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"message": services.ErrTenantNotFound.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "获取成功",
+		"data":    tenantDetail(&tenant),
+	})
+}
+
+// 设置本校使用的AI服务（学校管理员），配额只能由平台管理员调整
+func AdminUpdateTenantAI(c *gin.Context) {
+	var req TenantAIConfigRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "请求参数错误",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	var tenant models.Tenant
+	if err := database.DB.First(&tenant, tenantID(c)).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"message": services.ErrTenantNotFound.Error(),
+		})
+		return
+	}
+
+	updates := map[string]interface{}{}
+	applyTenantAIConfig(req, updates)
+	if len(updates) > 0 {
+		before := tenant
+		if err := database.DB.Model(&tenant).Updates(updates).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"code":    500,
+				"message": "修改AI配置失败",
+			})
+			return
+		}
+		services.InvalidateTenantCache()
+		recordAuditChange(c, "tenant.ai_update", "tenant", tenant.ID, before, tenant, tenantUpdateDetail(updates))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "修改成功",
+		"data":    tenantDetail(&tenant),
+	})
+}
@@ -0,0 +1,383 @@
+package handlers
+
+import (
+	"backend/models"
+	"backend/services"
+	"backend/utils"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TwoFactorChallengeRequest struct {
+	ChallengeToken string `json:"challenge_token" binding:"required"`
+	Code           string `json:"code"`
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type DisableTwoFactorRequest struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+// 双因素认证的业务错误返回400，登录挑战失效返回401，其余返回500
+func respondTwoFactorError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidChallenge):
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"code":    401,
+			"message": err.Error(),
+		})
+	case errors.Is(err, services.ErrInvalidTwoFactor),
+		errors.Is(err, services.ErrTwoFactorEnabled),
+		errors.Is(err, services.ErrTwoFactorNotEnabled),
+		errors.Is(err, services.ErrTwoFactorNotStarted),
+		errors.Is(err, services.ErrTwoFactorEnforced):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": err.Error(),
+		})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "双因素认证处理失败",
+		})
+	}
+}
+
+// 已启用或角色要求双因素认证时返回登录挑战，不直接签发令牌。已返回响应时为true
+func startTwoFactorChallenge(c *gin.Context, user *models.User) bool {
+	twoFactor := services.NewTwoFactorService()
+	purpose := ""
+	if twoFactor.IsEnabled(user.ID) {
+		purpose = services.ChallengeVerify
+	} else if twoFactor.IsEnforced(user.Role) {
+		purpose = services.ChallengeSetup
+	}
+	if purpose == "" {
+		return false
+	}
+
+	challenge, err := twoFactor.CreateChallenge(user.ID, purpose)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    500,
+			"message": "生成登录验证失败",
+		})
+		return true
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "请完成双因素认证",
+		"data": gin.H{
+			"two_factor_required": purpose == services.ChallengeVerify,
+			"two_factor_setup":    purpose == services.ChallengeSetup,
+			"challenge_token":     challenge,
+			"expires_in":          services.ChallengeTTL(),
+		},
+	})
+	return true
+}
+
+// 读取登录挑战对应的正常用户
+func challengeUser(c *gin.Context, token, purpose string) (*models.User, bool) {
+	userID, err := services.NewTwoFactorService().CheckChallenge(token, purpose)
+	if err != nil {
+		respondTwoFactorError(c, err)
+		return nil, false
+	}
+
+	var user models.User
+	if err := tenantDB(c).First(&user, userID).Error; err != nil || user.Status != models.UserStatusActive {
+		respondTwoFactorError(c, services.ErrInvalidChallenge)
+		return nil, false
+	}
+	return &user, true
+}
+
+// 登录第二步：输入身份验证器验证码或恢复码
+func VerifyTwoFactorLogin(c *gin.Context) {
+	var req TwoFactorChallengeRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "请求参数错误",
+		})
+		return
+	}
+
+	user, ok := challengeUser(c, req.ChallengeToken, services.ChallengeVerify)
+	if !ok {
+		return
+	}
+
+	// 验证码错误与密码错误共用失败计数，重新登录获取新挑战不会清除失败次数
+	guard := services.NewLoginGuardService()
+	ip := c.ClientIP()
+	if remaining := guard.LockedFor(tenantID(c), user.Username, ip); remaining > 0 {
+		respondLoginLocked(c, remaining)
+		return
+	}
+
+	twoFactor := services.NewTwoFactorService()
+	if err := twoFactor.Verify(user.ID, req.Code); err != nil {
+		locked := false
+		if errors.Is(err, services.ErrInvalidTwoFactor) {
+			locked = guard.RecordFailure(tenantID(c), user.Username, ip)
+		}
+		recordLoginAudit(c, user, user.Username, "auth.2fa_failed", gin.H{"locked": locked})
+		if locked {
+			twoFactor.CompleteChallenge(req.ChallengeToken)
+			respondLoginLocked(c, guard.LockedFor(tenantID(c), user.Username, ip))
+			return
+		}
+		respondTwoFactorError(c, err)
+		return
+	}
+	if err := twoFactor.CompleteChallenge(req.ChallengeToken); err != nil {
+		respondTwoFactorError(c, err)
+		return
+	}
+	guard.Reset(tenantID(c), user.Username)
+
+	respondLoginSuccess(c, user, nil)
+}
+
+// 登录时强制绑定：获取身份验证器密钥
+func BeginTwoFactorSetupLogin(c *gin.Context) {
+	var req TwoFactorChallengeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "请求参数错误",
+		})
+		return
+	}
+
+	user, ok := challengeUser(c, req.ChallengeToken, services.ChallengeSetup)
+	if !ok {
+		return
+	}
+
+	enrollment, err := services.NewTwoFactorService().BeginEnrollment(user)
+	if err != nil {
+		respondTwoFactorError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "请使用身份验证器扫描二维码",
+		"data":    enrollment,
+	})
+}
+
+// 登录时强制绑定：确认验证码，启用后直接完成登录并返回恢复码
+func ConfirmTwoFactorSetupLogin(c *gin.Context) {
+	var req TwoFactorChallengeRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "请求参数错误",
+		})
+		return
+	}
+
+	user, ok := challengeUser(c, req.ChallengeToken, services.ChallengeSetup)
+	if !ok {
+		return
+	}
+
+	twoFactor := services.NewTwoFactorService()
+	codes, err := twoFactor.ConfirmEnrollment(user.ID, req.Code)
+	if err != nil {
+		respondTwoFactorError(c, err)
+		return
+	}
+	if err := twoFactor.CompleteChallenge(req.ChallengeToken); err != nil {
+		respondTwoFactorError(c, err)
+		return
+	}
+
+	respondLoginSuccess(c, user, gin.H{"recovery_codes": codes})
+}
+
+// 获取我的双因素认证状态
+func GetTwoFactorStatus(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	status, err := services.NewTwoFactorService().Status(user)
+	if err != nil {
+		respondTwoFactorError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "获取成功",
+		"data":    status,
+	})
+}
+
+// 开始绑定身份验证器
+func BeginTwoFactorEnrollment(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	enrollment, err := services.NewTwoFactorService().BeginEnrollment(user)
+	if err != nil {
+		respondTwoFactorError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "请使用身份验证器扫描二维码",
+		"data":    enrollment,
+	})
+}
+
+// 确认验证码并启用双因素认证
+func ConfirmTwoFactorEnrollment(c *gin.Context) {
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "请求参数错误",
+		})
+		return
+	}
+
+	userID := c.GetUint("user_id")
+	codes, err := services.NewTwoFactorService().ConfirmEnrollment(userID, req.Code)
+	if err != nil {
+		respondTwoFactorError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "双因素认证已启用，请妥善保存恢复码",
+		"data": gin.H{
+			"recovery_codes": codes,
+		},
+	})
+}
+
+// 重新生成恢复码，需要验证当前验证码
+func RegenerateRecoveryCodes(c *gin.Context) {
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "请求参数错误",
+		})
+		return
+	}
+
+	userID := c.GetUint("user_id")
+	twoFactor := services.NewTwoFactorService()
+	if err := twoFactor.Verify(userID, req.Code); err != nil {
+		respondTwoFactorError(c, err)
+		return
+	}
+	codes, err := twoFactor.RegenerateRecoveryCodes(userID)
+	if err != nil {
+		respondTwoFactorError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "恢复码已重新生成",
+		"data": gin.H{
+			"recovery_codes": codes,
+		},
+	})
+}
+
+// 关闭双因素认证，需要密码和验证码
+func DisableTwoFactor(c *gin.Context) {
+	var req DisableTwoFactorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "请求参数错误",
+		})
+		return
+	}
+
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	if !utils.CheckPassword(req.Password, user.Password) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":    400,
+			"message": "密码错误",
+		})
+		return
+	}
+
+	twoFactor := services.NewTwoFactorService()
+	if twoFactor.IsEnforced(user.Role) {
+		respondTwoFactorError(c, services.ErrTwoFactorEnforced)
+		return
+	}
+	if err := twoFactor.Verify(user.ID, req.Code); err != nil {
+		respondTwoFactorError(c, err)
+		return
+	}
+	if err := twoFactor.Disable(user.ID); err != nil {
+		respondTwoFactorError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "双因素认证已关闭",
+	})
+}
+
+// 重置用户的双因素认证（管理员），用于用户丢失身份验证器且恢复码用尽的情况
+func AdminResetTwoFactor(c *gin.Context) {
+	user, ok := findUser(c, false)
+	if !ok {
+		return
+	}
+
+	if err := services.NewTwoFactorService().Disable(user.ID); err != nil {
+		respondTwoFactorError(c, err)
+		return
+	}
+	revokeUserTokens(user.ID)
+
+	recordAudit(c, "user.2fa_reset", "user", user.ID, nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    200,
+		"message": "双因素认证已重置",
+	})
+}
+
+// 获取当前登录用户，不存在时直接返回404
+func currentUser(c *gin.Context) (*models.User, bool) {
+	var user models.User
+	if err := tenantDB(c).First(&user, c.GetUint("user_id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code":    404,
+			"message": "用户不存在",
+		})
+		return nil, false
+	}
+	return &user, true
+}
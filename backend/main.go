@@ -5,11 +5,22 @@ import (
 	"backend/database"
 	"backend/redis"
 	"backend/routes"
+	"backend/services"
+	"context"
+	"errors"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
+// 退出时等待处理中的请求和审计日志写入的最长时间
+const shutdownTimeout = 15 * time.Second
+
 func main() {
 	// 加载配置
 	if err := config.LoadConfig(); err != nil {
@@ -21,11 +32,30 @@ func main() {
 		log.Fatal("Failed to initialize database:", err)
 	}
 
+	// 创建默认学校并迁移升级前的数据
+	if err := services.NewTenantService().SeedDefault(); err != nil {
+		log.Fatal("Failed to seed default tenant:", err)
+	}
+
+	// 写入内置角色和权限
+	if err := services.NewPermissionService().Seed(); err != nil {
+		log.Fatal("Failed to seed permissions:", err)
+	}
+
 	// 初始化Redis
 	if err := redis.InitRedis(); err != nil {
 		log.Fatal("Failed to initialize Redis:", err)
 	}
 
+	// 启动审计日志后台写入
+	services.NewAuditService().StartWriter()
+
+	// 启动注销执行和数据导出清理定时任务
+	services.NewPrivacyService().StartScheduler()
+
+	// 启动学业风险预警定时任务
+	services.NewRiskService().StartScheduler()
+
 	// 设置Gin模式
 	gin.SetMode(config.GlobalConfig.Server.Mode)
 
@@ -33,8 +63,30 @@ func main() {
 	r := routes.SetupRoutes()
 
 	// 启动服务器
-	log.Printf("Server starting on port %s", config.GlobalConfig.Server.Port)
-	if err := r.Run(config.GlobalConfig.Server.Port); err != nil {
-		log.Fatal("Failed to start server:", err)
+	srv := &http.Server{
+		Addr:    config.GlobalConfig.Server.Port,
+		Handler: r,
+	}
+	go func() {
+		log.Printf("Server starting on port %s", config.GlobalConfig.Server.Port)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal("Failed to start server:", err)
+		}
+	}()
+
+	// 收到退出信号后先停止接收请求、等待处理中的请求完成，再写完队列中的审计日志
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+	log.Println("Shutting down server...")
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Server forced to shutdown: %v", err)
+	}
+	if err := services.NewAuditService().StopWriter(ctx); err != nil {
+		log.Printf("Failed to flush audit logs: %v", err)
 	}
+	log.Println("Server exited")
 }
@@ -1,16 +1,35 @@
 package middleware
 
 import (
+	"backend/models"
+	"backend/services"
 	"backend/utils"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
-// JWT认证中间件
+// APIKeyHeader 服务账户调用接口时携带API密钥的请求头
+const APIKeyHeader = "X-API-Key"
+
+// 需要修改初始密码的用户可以访问的接口
+var passwordChangeAllowed = map[string]bool{
+	"GET /api/v1/user/profile":     true,
+	"GET /api/v1/user/permissions": true,
+	"PUT /api/v1/user/password":    true,
+	"POST /api/v1/auth/logout":     true,
+}
+
+// JWT认证中间件，也接受服务账户的API密钥
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if apiKey := c.GetHeader(APIKeyHeader); apiKey != "" {
+			authenticateAPIKey(c, apiKey)
+			return
+		}
+
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{
@@ -43,15 +62,101 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		// 检查会话是否已注销、令牌版本是否已失效
+		if err := services.NewTokenService().ValidateAccess(claims); err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"code":    401,
+				"message": "token已失效，请重新登录",
+			})
+			c.Abort()
+			return
+		}
+
+		// 令牌只能在签发它的学校内使用
+		if claims.TenantID != GetCurrentTenantID(c) {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"code":    401,
+				"message": "token不属于当前学校，请重新登录",
+			})
+			c.Abort()
+			return
+		}
+
+		// 使用初始密码登录时，修改密码前只能访问少数账户接口
+		if claims.MustChangePassword && !passwordChangeAllowed[c.Request.Method+" "+c.FullPath()] {
+			c.JSON(http.StatusForbidden, gin.H{
+				"code":    403,
+				"message": "请先修改初始密码",
+				"data":    gin.H{"must_change_password": true},
+			})
+			c.Abort()
+			return
+		}
+
 		// 将用户信息存储到上下文中
 		c.Set("user_id", claims.UserID)
 		c.Set("username", claims.Username)
-		c.Set("role", claims.Role)
+		c.Set("role", string(claims.Role))
+		c.Set("session_id", claims.SessionID)
+
+		c.Next()
+	}
+}
+
+// 使用API密钥认证，服务账户的权限由密钥所属账户的授权范围决定
+func authenticateAPIKey(c *gin.Context, apiKey string) {
+	identity, err := services.NewAPIKeyService().Authenticate(GetCurrentTenantID(c), apiKey, c.ClientIP())
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"code":    401,
+			"message": err.Error(),
+		})
+		c.Abort()
+		return
+	}
+
+	c.Set("user_id", identity.Account.UserID)
+	c.Set("username", identity.Account.User.Username)
+	c.Set("role", string(models.RoleService))
+	c.Set("api_key_id", identity.Key.ID)
+	c.Set("api_scopes", identity.Account.ScopeList())
+
+	c.Next()
+}
 
+// 是否为使用API密钥的请求
+func IsAPIKeyRequest(c *gin.Context) bool {
+	_, exists := c.Get("api_key_id")
+	return exists
+}
+
+// 只允许用户本人登录后访问，拒绝API密钥，用于个人资料、密码等账户相关接口
+func RejectAPIKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if IsAPIKeyRequest(c) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"code":    403,
+				"message": "该接口不支持使用API密钥访问",
+			})
+			c.Abort()
+			return
+		}
 		c.Next()
 	}
 }
 
+// Can 检查当前请求是否拥有权限：API密钥按授权范围和请求方法判断，并且只能访问本校课程；其他请求按用户角色判断
+func Can(c *gin.Context, permission string, courseID uint) bool {
+	userID := c.GetUint("user_id")
+	if IsAPIKeyRequest(c) {
+		if !services.NewAPIKeyService().ScopeAllows(c.GetStringSlice("api_scopes"), permission, c.Request.Method) {
+			return false
+		}
+		return courseID == 0 || services.NewPermissionService().SameTenant(userID, courseID)
+	}
+	return services.NewPermissionService().Can(userID, c.GetString("role"), permission, courseID)
+}
+
 // 角色权限中间件
 func RoleMiddleware(roles ...string) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -87,6 +192,37 @@ func RoleMiddleware(roles ...string) gin.HandlerFunc {
 	}
 }
 
+// 权限中间件，courseParam为路由中课程ID参数名，指定时同时按用户在该课程内的角色判断
+func RequirePermission(permission string, courseParam ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, exists := c.Get("user_id"); !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"code":    401,
+				"message": "未认证",
+			})
+			c.Abort()
+			return
+		}
+
+		var courseID uint
+		if len(courseParam) > 0 {
+			id, _ := strconv.ParseUint(c.Param(courseParam[0]), 10, 64)
+			courseID = uint(id)
+		}
+
+		if !Can(c, permission, courseID) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"code":    403,
+				"message": "权限不足",
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
 // 获取当前用户ID
 func GetCurrentUserID(c *gin.Context) uint {
 	userID, _ := c.Get("user_id")
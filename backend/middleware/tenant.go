@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"backend/models"
+	"backend/services"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 学校解析中间件，依次使用X-Tenant请求头、tenant查询参数（SSE无法设置请求头）和子域名，
+// 都没有时使用默认学校
+func TenantMiddleware() gin.HandlerFunc {
+	tenantService := services.NewTenantService()
+	return func(c *gin.Context) {
+		code := c.GetHeader("X-Tenant")
+		if code == "" {
+			code = c.Query("tenant")
+		}
+		if code == "" {
+			code = subdomain(c.Request.Host, tenantService.BaseDomain())
+		}
+		if code == "" {
+			code = tenantService.DefaultCode()
+		}
+
+		tenant, err := tenantService.GetByCode(strings.ToLower(code))
+		if err != nil {
+			status, message := http.StatusInternalServerError, "获取学校信息失败"
+			switch {
+			case errors.Is(err, services.ErrTenantNotFound):
+				status, message = http.StatusNotFound, err.Error()
+			case errors.Is(err, services.ErrTenantDisabled):
+				status, message = http.StatusForbidden, err.Error()
+			}
+			c.JSON(status, gin.H{
+				"code":    status,
+				"message": message,
+			})
+			c.Abort()
+			return
+		}
+
+		c.Set("tenant_id", tenant.ID)
+		c.Set("tenant", tenant)
+		c.Next()
+	}
+}
+
+// 从school1.example.com中取出school1，主域名本身或其他域名返回空
+func subdomain(host, baseDomain string) string {
+	if baseDomain == "" {
+		return ""
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	suffix := "." + strings.ToLower(baseDomain)
+	if !strings.HasSuffix(host, suffix) {
+		return ""
+	}
+	sub := strings.TrimSuffix(host, suffix)
+	if i := strings.LastIndex(sub, "."); i >= 0 {
+		sub = sub[i+1:]
+	}
+	return sub
+}
+
+// 获取当前请求所属学校ID
+func GetCurrentTenantID(c *gin.Context) uint {
+	tenantID, _ := c.Get("tenant_id")
+	id, _ := tenantID.(uint)
+	return id
+}
+
+// 获取当前请求所属学校
+func GetCurrentTenant(c *gin.Context) *models.Tenant {
+	tenant, _ := c.Get("tenant")
+	t, _ := tenant.(*models.Tenant)
+	return t
+}
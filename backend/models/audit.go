@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+)
+
+// AuditLog 操作审计日志
+type AuditLog struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	TenantID   uint      `json:"tenant_id" gorm:"index"` // 操作发生的学校
+	ActorID    uint      `json:"actor_id" gorm:"index"`  // 操作人，0表示系统
+	ActorName  string    `json:"actor_name" gorm:"size:50"`
+	Action     string    `json:"action" gorm:"size:50;index"`      // 操作类型，如 user.create
+	TargetType string    `json:"target_type" gorm:"size:50;index"` // 操作对象类型，如 user
+	TargetID   uint      `json:"target_id" gorm:"index"`
+	Detail     string    `json:"detail" gorm:"type:text"`  // JSON格式存储操作详情
+	Changes    string    `json:"changes" gorm:"type:text"` // JSON格式存储变更字段，形如 {"role":{"before":"student","after":"teacher"}}
+	IP         string    `json:"ip" gorm:"size:64;index"`
+	UserAgent  string    `json:"user_agent" gorm:"size:255"`
+	RequestID  string    `json:"request_id" gorm:"size:64;index"` // 对应响应头X-Request-ID，便于与访问日志关联
+	CreatedAt  time.Time `json:"created_at" gorm:"index"`
+}
@@ -6,38 +6,67 @@ import (
 	"gorm.io/gorm"
 )
 
+// 聊天消息角色
+const (
+	ChatRoleUser      = "user"      // 学生提问
+	ChatRoleAssistant = "assistant" // AI回复
+	ChatRoleSystem    = "system"
+	ChatRoleTeacher   = "teacher" // 教师人工回复，不是AI生成的内容
+)
+
+// DefaultChatTitle 未填写标题的会话在自动生成标题之前显示的标题
+const DefaultChatTitle = "新对话"
+
+// 课程内AI对话对教师的可见范围，即Course.ChatVisibility
+const (
+	ChatVisibilityShared = "shared" // 只能看到学生主动分享的会话
+	ChatVisibilityAll    = "all"    // 可以查看课程内所有会话
+)
+
 type ChatSession struct {
-	ID        uint           `json:"id" gorm:"primaryKey"`
-	UserID    uint           `json:"user_id"`
-	Title     string         `json:"title" gorm:"size:100"`
-	Type      string         `json:"type" gorm:"size:20"` // learning, practice, general
-	CourseID  *uint          `json:"course_id"`
-	ChapterID *uint          `json:"chapter_id"`
-	Status    int            `json:"status" gorm:"default:1"` // 1: 活跃, 0: 结束
-	CreatedAt time.Time      `json:"created_at"`
-	UpdatedAt time.Time      `json:"updated_at"`
-	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
-	User      User           `json:"user" gorm:"foreignKey:UserID"`
-	Course    *Course        `json:"course" gorm:"foreignKey:CourseID"`
-	Chapter   *Chapter       `json:"chapter" gorm:"foreignKey:ChapterID"`
-	Messages  []ChatMessage  `json:"messages" gorm:"foreignKey:SessionID"`
+	ID                uint           `json:"id" gorm:"primaryKey"`
+	UserID            uint           `json:"user_id"`
+	Title             string         `json:"title" gorm:"size:100"`
+	Type              string         `json:"type" gorm:"size:20"` // learning, practice, general
+	CourseID          *uint          `json:"course_id" gorm:"index"`
+	ChapterID         *uint          `json:"chapter_id"`
+	Status            int            `json:"status" gorm:"default:1"`                  // 1: 活跃, 0: 结束
+	SharedWithTeacher bool           `json:"shared_with_teacher" gorm:"default:false"` // 学生是否已分享给课程教师
+	SharedAt          *time.Time     `json:"shared_at"`
+	ShareToken        *string        `json:"-" gorm:"uniqueIndex;size:32"`    // 分享链接标识，为空表示未开启链接分享
+	CurrentMessageID  *uint          `json:"current_message_id"`              // 当前分支的最后一条消息，重新生成或编辑后切换到新分支
+	AutoTitle         bool           `json:"auto_title" gorm:"default:false"` // 创建时未填写标题，由第一轮对话自动生成
+	Tags              string         `json:"tags" gorm:"size:255"`            // 主题标签，逗号分隔
+	TaggedAt          *time.Time     `json:"tagged_at"`                       // 已根据第一轮对话生成标题和标签的时间
+	CreatedAt         time.Time      `json:"created_at"`
+	UpdatedAt         time.Time      `json:"updated_at"`
+	DeletedAt         gorm.DeletedAt `json:"-" gorm:"index"`
+	User              User           `json:"user" gorm:"foreignKey:UserID"`
+	Course            *Course        `json:"course" gorm:"foreignKey:CourseID"`
+	Chapter           *Chapter       `json:"chapter" gorm:"foreignKey:ChapterID"`
+	Messages          []ChatMessage  `json:"messages" gorm:"foreignKey:SessionID"`
+	Knowledge         []Knowledge    `json:"knowledge" gorm:"many2many:chat_session_knowledge"` // 对话涉及的课程知识点
 }
 
 type ChatMessage struct {
 	ID          uint           `json:"id" gorm:"primaryKey"`
 	SessionID   uint           `json:"session_id"`
-	Role        string         `json:"role" gorm:"size:20"` // user, assistant, system
+	ParentID    *uint          `json:"parent_id" gorm:"index"` // 上一条消息，重新生成和编辑会产生同一父消息下的多个分支
+	Role        string         `json:"role" gorm:"size:20"`    // user, assistant, system, teacher
 	Content     string         `json:"content" gorm:"type:text"`
 	MessageType string         `json:"message_type" gorm:"size:20"` // text, image, file
 	Metadata    string         `json:"metadata" gorm:"type:text"`   // JSON格式存储额外信息
+	SenderID    *uint          `json:"sender_id"`                   // 教师回复时为回复的教师
 	CreatedAt   time.Time      `json:"created_at"`
 	UpdatedAt   time.Time      `json:"updated_at"`
 	DeletedAt   gorm.DeletedAt `json:"-" gorm:"index"`
 	Session     ChatSession    `json:"session" gorm:"foreignKey:SessionID"`
+	Sender      *User          `json:"sender,omitempty" gorm:"foreignKey:SenderID"`
 }
 
 type KnowledgeBase struct {
 	ID          uint           `json:"id" gorm:"primaryKey"`
+	TenantID    uint           `json:"tenant_id" gorm:"index"`
 	Name        string         `json:"name" gorm:"not null;size:100"`
 	Description string         `json:"description" gorm:"type:text"`
 	Type        string         `json:"type" gorm:"size:20"`        // course, general, custom
@@ -52,11 +81,13 @@ type KnowledgeBase struct {
 
 type LearningProgress struct {
 	ID          uint           `json:"id" gorm:"primaryKey"`
-	UserID      uint           `json:"user_id"`
-	CourseID    uint           `json:"course_id"`
-	ChapterID   uint           `json:"chapter_id"`
+	UserID      uint           `json:"user_id" gorm:"uniqueIndex:idx_user_chapter"`
+	CourseID    uint           `json:"course_id" gorm:"index"`
+	ChapterID   uint           `json:"chapter_id" gorm:"uniqueIndex:idx_user_chapter"`
 	Progress    float64        `json:"progress"`   // 学习进度 0-100
 	TimeSpent   int            `json:"time_spent"` // 学习时长(分钟)
+	Completed   bool           `json:"completed"`  // 是否已标记完成
+	CompletedAt *time.Time     `json:"completed_at"`
 	LastStudyAt time.Time      `json:"last_study_at"`
 	CreatedAt   time.Time      `json:"created_at"`
 	UpdatedAt   time.Time      `json:"updated_at"`
@@ -65,3 +96,15 @@ type LearningProgress struct {
 	Course      Course         `json:"course" gorm:"foreignKey:CourseID"`
 	Chapter     Chapter        `json:"chapter" gorm:"foreignKey:ChapterID"`
 }
+
+// KnowledgeProgress 学生标记完成的知识点
+type KnowledgeProgress struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	UserID      uint      `json:"user_id" gorm:"uniqueIndex:idx_user_knowledge_progress"`
+	KnowledgeID uint      `json:"knowledge_id" gorm:"uniqueIndex:idx_user_knowledge_progress"`
+	ChapterID   uint      `json:"chapter_id" gorm:"index"`
+	CompletedAt time.Time `json:"completed_at"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Knowledge   Knowledge `json:"knowledge" gorm:"foreignKey:KnowledgeID"`
+}
@@ -7,19 +7,52 @@ import (
 )
 
 type Course struct {
-	ID          uint           `json:"id" gorm:"primaryKey"`
-	Name        string         `json:"name" gorm:"not null;size:100"`
-	Description string         `json:"description" gorm:"type:text"`
-	Subject     string         `json:"subject" gorm:"size:50"` // 学科
-	Grade       string         `json:"grade" gorm:"size:20"`   // 适用年级
-	CoverImage  string         `json:"cover_image" gorm:"size:255"`
-	TeacherID   uint           `json:"teacher_id"`
-	Status      int            `json:"status" gorm:"default:1"` // 1: 正常, 0: 禁用
-	CreatedAt   time.Time      `json:"created_at"`
-	UpdatedAt   time.Time      `json:"updated_at"`
-	DeletedAt   gorm.DeletedAt `json:"-" gorm:"index"`
-	Teacher     User           `json:"teacher" gorm:"foreignKey:TeacherID"`
-	Chapters    []Chapter      `json:"chapters" gorm:"foreignKey:CourseID"`
+	ID               uint           `json:"id" gorm:"primaryKey"`
+	TenantID         uint           `json:"tenant_id" gorm:"index"`
+	Name             string         `json:"name" gorm:"not null;size:100"`
+	Description      string         `json:"description" gorm:"type:text"`
+	Subject          string         `json:"subject" gorm:"size:50"` // 学科
+	Grade            string         `json:"grade" gorm:"size:20"`   // 适用年级
+	CoverImage       string         `json:"cover_image" gorm:"size:255"`
+	TeacherID        uint           `json:"teacher_id"`
+	InviteCode       string         `json:"invite_code" gorm:"size:20;index"`                // 学生自助选课邀请码
+	Status           int            `json:"status" gorm:"default:1"`                         // 1: 正常, 0: 禁用
+	ChatVisibility   string         `json:"chat_visibility" gorm:"size:20;default:'shared'"` // 教师查看学生AI对话的范围：shared、all
+	ChatVisibleSince *time.Time     `json:"chat_visible_since"`                              // 改为all的时间，此后新建的会话才对教师可见
+	CreatedAt        time.Time      `json:"created_at"`
+	UpdatedAt        time.Time      `json:"updated_at"`
+	DeletedAt        gorm.DeletedAt `json:"-" gorm:"index"`
+	Teacher          User           `json:"teacher" gorm:"foreignKey:TeacherID"`
+	Chapters         []Chapter      `json:"chapters" gorm:"foreignKey:CourseID"`
+}
+
+type EnrollmentStatus string
+
+const (
+	EnrollmentActive  EnrollmentStatus = "active"  // 在读
+	EnrollmentDropped EnrollmentStatus = "dropped" // 已退课
+	EnrollmentRemoved EnrollmentStatus = "removed" // 被教师移出
+)
+
+type EnrollmentSource string
+
+const (
+	EnrollmentSourceSelf    EnrollmentSource = "self"    // 邀请码自助加入
+	EnrollmentSourceTeacher EnrollmentSource = "teacher" // 教师添加
+	EnrollmentSourceClass   EnrollmentSource = "class"   // 按班级批量添加
+)
+
+// Enrollment 学生选课记录
+type Enrollment struct {
+	ID        uint             `json:"id" gorm:"primaryKey"`
+	CourseID  uint             `json:"course_id" gorm:"uniqueIndex:idx_course_user"`
+	UserID    uint             `json:"user_id" gorm:"uniqueIndex:idx_course_user"`
+	Status    EnrollmentStatus `json:"status" gorm:"size:20;default:'active'"`
+	Source    EnrollmentSource `json:"source" gorm:"size:20"`
+	CreatedAt time.Time        `json:"created_at"`
+	UpdatedAt time.Time        `json:"updated_at"`
+	Course    Course           `json:"course" gorm:"foreignKey:CourseID"`
+	User      User             `json:"user" gorm:"foreignKey:UserID"`
 }
 
 type Chapter struct {
@@ -52,6 +85,7 @@ type Knowledge struct {
 
 type CourseMaterial struct {
 	ID         uint      `json:"id" gorm:"primaryKey"`
+	TenantID   uint      `json:"tenant_id" gorm:"index"`
 	CourseID   uint      `json:"course_id"`
 	TeacherID  uint      `json:"teacher_id"`
 	Title      string    `json:"title" gorm:"size:200"`
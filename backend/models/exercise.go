@@ -35,28 +35,31 @@ type Exercise struct {
 }
 
 type Question struct {
-	ID         uint           `json:"id" gorm:"primaryKey"`
-	ExerciseID uint           `json:"exercise_id"`
-	Type       QuestionType   `json:"type" gorm:"not null;size:20"`
-	Title      string         `json:"title" gorm:"not null;size:200"`
-	Content    string         `json:"content" gorm:"type:text"`
-	Options    string         `json:"options" gorm:"type:text"`  // JSON格式存储选项
-	Answer     string         `json:"answer" gorm:"type:text"`   // 正确答案
-	Analysis   string         `json:"analysis" gorm:"type:text"` // 解析
-	Score      int            `json:"score"`                     // 分值
-	Difficulty int            `json:"difficulty"`                // 难度等级 1-5
-	Order      int            `json:"order"`
-	CreatedAt  time.Time      `json:"created_at"`
-	UpdatedAt  time.Time      `json:"updated_at"`
-	DeletedAt  gorm.DeletedAt `json:"-" gorm:"index"`
-	Exercise   Exercise       `json:"exercise" gorm:"foreignKey:ExerciseID"`
+	ID          uint           `json:"id" gorm:"primaryKey"`
+	ExerciseID  uint           `json:"exercise_id"`
+	KnowledgeID *uint          `json:"knowledge_id"` // 关联知识点
+	Type        QuestionType   `json:"type" gorm:"not null;size:20"`
+	Title       string         `json:"title" gorm:"not null;size:200"`
+	Content     string         `json:"content" gorm:"type:text"`
+	Options     string         `json:"options" gorm:"type:text"`  // JSON格式存储选项
+	Answer      string         `json:"answer" gorm:"type:text"`   // 正确答案
+	Analysis    string         `json:"analysis" gorm:"type:text"` // 解析
+	Score       int            `json:"score"`                     // 分值
+	Difficulty  int            `json:"difficulty"`                // 难度等级 1-5
+	Order       int            `json:"order"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+	DeletedAt   gorm.DeletedAt `json:"-" gorm:"index"`
+	Exercise    Exercise       `json:"exercise" gorm:"foreignKey:ExerciseID"`
+	Knowledge   *Knowledge     `json:"knowledge" gorm:"foreignKey:KnowledgeID"`
 }
 
 type StudentAnswer struct {
 	ID         uint           `json:"id" gorm:"primaryKey"`
 	UserID     uint           `json:"user_id"`
 	ExerciseID uint           `json:"exercise_id"`
-	QuestionID uint           `json:"question_id"`
+	QuestionID uint           `json:"question_id" gorm:"uniqueIndex:idx_record_question"`
+	RecordID   *uint          `json:"record_id" gorm:"index;uniqueIndex:idx_record_question"` // 所属练习记录，每题只能作答一次
 	Answer     string         `json:"answer" gorm:"type:text"`
 	Score      int            `json:"score"`                     // 得分
 	IsCorrect  bool           `json:"is_correct"`                // 是否正确
@@ -69,17 +72,30 @@ type StudentAnswer struct {
 	Question   Question       `json:"question" gorm:"foreignKey:QuestionID"`
 }
 
+type ExerciseRecordType string
+
+const (
+	ExerciseRecordTypeFixed    ExerciseRecordType = "fixed"    // 固定练习
+	ExerciseRecordTypeAdaptive ExerciseRecordType = "adaptive" // 自适应练习
+)
+
 type ExerciseRecord struct {
-	ID         uint           `json:"id" gorm:"primaryKey"`
-	UserID     uint           `json:"user_id"`
-	ExerciseID uint           `json:"exercise_id"`
-	StartTime  time.Time      `json:"start_time"`
-	EndTime    *time.Time     `json:"end_time"`
-	Score      int            `json:"score"`
-	Status     string         `json:"status" gorm:"size:20"` // ongoing, completed, timeout
-	CreatedAt  time.Time      `json:"created_at"`
-	UpdatedAt  time.Time      `json:"updated_at"`
-	DeletedAt  gorm.DeletedAt `json:"-" gorm:"index"`
-	User       User           `json:"user" gorm:"foreignKey:UserID"`
-	Exercise   Exercise       `json:"exercise" gorm:"foreignKey:ExerciseID"`
+	ID                uint               `json:"id" gorm:"primaryKey"`
+	UserID            uint               `json:"user_id"`
+	ExerciseID        *uint              `json:"exercise_id"` // 自适应练习为空
+	Type              ExerciseRecordType `json:"type" gorm:"size:20;default:'fixed'"`
+	ChapterID         *uint              `json:"chapter_id"`          // 自适应练习所属章节
+	CurrentQuestionID *uint              `json:"current_question_id"` // 自适应练习当前待答题目
+	QuestionLimit     int                `json:"question_limit"`      // 自适应练习最多题数
+	TargetMastery     float64            `json:"target_mastery"`      // 自适应练习目标掌握度
+	StartTime         time.Time          `json:"start_time"`
+	EndTime           *time.Time         `json:"end_time"`
+	Score             int                `json:"score"`
+	Status            string             `json:"status" gorm:"size:20"` // ongoing, completed, timeout
+	CreatedAt         time.Time          `json:"created_at"`
+	UpdatedAt         time.Time          `json:"updated_at"`
+	DeletedAt         gorm.DeletedAt     `json:"-" gorm:"index"`
+	User              User               `json:"user" gorm:"foreignKey:UserID"`
+	Exercise          *Exercise          `json:"exercise" gorm:"foreignKey:ExerciseID"`
+	Chapter           *Chapter           `json:"chapter" gorm:"foreignKey:ChapterID"`
 }
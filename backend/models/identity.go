@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// UserIdentity 外部身份（如统一身份认证OIDC、LDAP）与平台账户的绑定关系
+type UserIdentity struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	TenantID  uint      `json:"tenant_id" gorm:"uniqueIndex:idx_tenant_provider_subject"`
+	UserID    uint      `json:"user_id" gorm:"index"`
+	Provider  string    `json:"provider" gorm:"size:20;uniqueIndex:idx_tenant_provider_subject"`
+	Subject   string    `json:"subject" gorm:"size:255;uniqueIndex:idx_tenant_provider_subject"` // 同一学校内唯一
+	Email     string    `json:"email" gorm:"size:100"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	User      User      `json:"-" gorm:"foreignKey:UserID"`
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// KnowledgeMastery 学生对单个知识点的掌握度估计（贝叶斯知识追踪）
+type KnowledgeMastery struct {
+	ID             uint           `json:"id" gorm:"primaryKey"`
+	UserID         uint           `json:"user_id" gorm:"uniqueIndex:idx_user_knowledge"`
+	KnowledgeID    uint           `json:"knowledge_id" gorm:"uniqueIndex:idx_user_knowledge"`
+	CourseID       uint           `json:"course_id" gorm:"index"`
+	ChapterID      uint           `json:"chapter_id"`
+	Mastery        float64        `json:"mastery"`       // 掌握概率 0-1
+	Attempts       int            `json:"attempts"`      // 作答次数
+	CorrectCount   int            `json:"correct_count"` // 答对次数
+	LastAnsweredAt time.Time      `json:"last_answered_at"`
+	CreatedAt      time.Time      `json:"created_at"`
+	UpdatedAt      time.Time      `json:"updated_at"`
+	DeletedAt      gorm.DeletedAt `json:"-" gorm:"index"`
+	Knowledge      Knowledge      `json:"knowledge" gorm:"foreignKey:KnowledgeID"`
+}
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Notification 站内通知
+type Notification struct {
+	ID        uint           `json:"id" gorm:"primaryKey"`
+	UserID    uint           `json:"user_id" gorm:"index"`
+	Type      string         `json:"type" gorm:"size:30"` // risk_alert, system
+	Title     string         `json:"title" gorm:"size:200"`
+	Content   string         `json:"content" gorm:"type:text"`
+	Link      string         `json:"link" gorm:"size:255"` // 前端跳转路径
+	IsRead    bool           `json:"is_read" gorm:"default:false"`
+	ReadAt    *time.Time     `json:"read_at"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+}
@@ -0,0 +1,64 @@
+package models
+
+import "time"
+
+// 权限编码
+const (
+	PermTenantManage    = "tenant.manage"          // 管理学校及其配额（平台管理员）
+	PermUserManage      = "user.manage"            // 用户管理、注册审核、邀请码
+	PermRoleManage      = "role.manage"            // 调整角色权限
+	PermAuditView       = "audit.view"             // 查询和导出本校审计日志
+	PermServiceAccount  = "service_account.manage" // 管理服务账户和API密钥
+	PermCourseCreate    = "course.create"          // 创建课程
+	PermCourseUpdate    = "course.update"          // 编辑课程信息
+	PermCourseDelete    = "course.delete"          // 删除课程
+	PermCourseMembers   = "course.members"         // 管理协作教师和助教
+	PermCourseStudents  = "course.students"        // 管理选课学生和邀请码
+	PermCourseAnalytics = "course.analytics"       // 查看学情分析、掌握度和风险预警
+	PermMaterialUpload  = "material.upload"        // 上传资料，可删除自己上传的资料
+	PermMaterialDelete  = "material.delete"        // 删除课程内任意资料
+	PermExerciseManage  = "exercise.manage"        // 创建练习、AI出题、标注题目知识点
+	PermLessonPlan      = "lesson_plan.generate"   // AI生成备课内容
+	PermChatReview      = "chat.review"            // 查看课程内学生的AI对话并人工回复
+)
+
+// 角色作用范围
+const (
+	RoleScopeGlobal = "global" // 对应User.Role，在所有课程生效
+	RoleScopeCourse = "course" // 只在所属课程内生效
+)
+
+// 课程内角色
+const (
+	CourseRoleOwner     = "owner"      // 课程创建教师，由Course.TeacherID确定
+	CourseRoleCoTeacher = "co_teacher" // 协作教师
+	CourseRoleAssistant = "assistant"  // 助教
+)
+
+type Permission struct {
+	ID          uint   `json:"id" gorm:"primaryKey"`
+	Code        string `json:"code" gorm:"uniqueIndex;size:50;not null"`
+	Name        string `json:"name" gorm:"size:50"`
+	Description string `json:"description" gorm:"size:200"`
+}
+
+// Role 角色及其权限，全局角色与User.Role同名，课程角色与CourseMember.Role同名
+type Role struct {
+	ID          uint         `json:"id" gorm:"primaryKey"`
+	Name        string       `json:"name" gorm:"uniqueIndex;size:20;not null"`
+	DisplayName string       `json:"display_name" gorm:"size:50"`
+	Scope       string       `json:"scope" gorm:"size:20"`
+	CreatedAt   time.Time    `json:"created_at"`
+	UpdatedAt   time.Time    `json:"updated_at"`
+	Permissions []Permission `json:"permissions" gorm:"many2many:role_permissions"`
+}
+
+// CourseMember 课程协作教师和助教
+type CourseMember struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	CourseID  uint      `json:"course_id" gorm:"uniqueIndex:idx_course_member"`
+	UserID    uint      `json:"user_id" gorm:"uniqueIndex:idx_course_member;index"`
+	Role      string    `json:"role" gorm:"size:20"`
+	CreatedAt time.Time `json:"created_at"`
+	User      User      `json:"user" gorm:"foreignKey:UserID"`
+}
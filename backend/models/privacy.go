@@ -0,0 +1,48 @@
+package models
+
+import "time"
+
+// 个人数据导出状态
+const (
+	DataExportPending    = "pending"    // 等待生成
+	DataExportProcessing = "processing" // 生成中
+	DataExportCompleted  = "completed"  // 可下载
+	DataExportFailed     = "failed"     // 生成失败
+	DataExportExpired    = "expired"    // 文件已过期删除
+)
+
+// DataExport 用户申请的个人数据导出，由后台任务打包为ZIP
+type DataExport struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	TenantID    uint       `json:"tenant_id" gorm:"index"`
+	UserID      uint       `json:"user_id" gorm:"index"`
+	Status      string     `json:"status" gorm:"size:20;index"`
+	FilePath    string     `json:"-" gorm:"size:255"`
+	FileSize    int64      `json:"file_size"`
+	Error       string     `json:"error" gorm:"size:255"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// 账户注销申请状态
+const (
+	AccountDeletionPending   = "pending"   // 冷静期中，到期后执行
+	AccountDeletionCancelled = "cancelled" // 已撤销
+	AccountDeletionCompleted = "completed" // 已匿名化
+)
+
+// AccountDeletion 账户注销申请，到期后匿名化个人信息，保留成绩等课程统计数据
+type AccountDeletion struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	TenantID    uint       `json:"tenant_id" gorm:"index"`
+	UserID      uint       `json:"user_id" gorm:"index"`
+	Status      string     `json:"status" gorm:"size:20;index"`
+	Reason      string     `json:"reason" gorm:"size:500"`
+	RequestedBy uint       `json:"requested_by"` // 本人申请时为用户自己，管理员直接注销时为管理员
+	ScheduledAt time.Time  `json:"scheduled_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type RiskLevel string
+
+const (
+	RiskLevelMedium RiskLevel = "medium"
+	RiskLevelHigh   RiskLevel = "high"
+)
+
+type RiskAlertStatus string
+
+const (
+	RiskAlertOpen         RiskAlertStatus = "open"         // 待处理
+	RiskAlertAcknowledged RiskAlertStatus = "acknowledged" // 已跟进
+	RiskAlertResolved     RiskAlertStatus = "resolved"     // 已解除
+)
+
+// RiskAlert 学生学业风险预警
+type RiskAlert struct {
+	ID          uint            `json:"id" gorm:"primaryKey"`
+	CourseID    uint            `json:"course_id" gorm:"index"`
+	UserID      uint            `json:"user_id" gorm:"index"`
+	Score       int             `json:"score"` // 风险分 0-100
+	Level       RiskLevel       `json:"level" gorm:"size:20"`
+	Factors     string          `json:"factors" gorm:"type:text"` // JSON格式存储风险因素
+	Status      RiskAlertStatus `json:"status" gorm:"size:20;default:'open'"`
+	Note        string          `json:"note" gorm:"type:text"` // 教师跟进备注
+	EvaluatedAt time.Time       `json:"evaluated_at"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+	DeletedAt   gorm.DeletedAt  `json:"-" gorm:"index"`
+	Course      Course          `json:"course" gorm:"foreignKey:CourseID"`
+	User        User            `json:"user" gorm:"foreignKey:UserID"`
+}
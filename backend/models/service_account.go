@@ -0,0 +1,63 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 服务账户状态
+const (
+	ServiceAccountDisabled = 0
+	ServiceAccountActive   = 1
+)
+
+// ServiceAccount 供脚本和其他学校系统调用接口的服务账户。
+// 每个服务账户对应一个Role为service的用户，接口中的创建人、审计日志等都记在该用户名下
+type ServiceAccount struct {
+	ID          uint           `json:"id" gorm:"primaryKey"`
+	TenantID    uint           `json:"tenant_id" gorm:"index"`
+	UserID      uint           `json:"user_id" gorm:"uniqueIndex"`
+	Name        string         `json:"name" gorm:"size:50;not null"`
+	Description string         `json:"description" gorm:"size:255"`
+	Scopes      string         `json:"scopes" gorm:"size:255"` // 授权范围，逗号分隔，如 grades:read,content:write
+	Status      int            `json:"status" gorm:"default:1"`
+	CreatedBy   uint           `json:"created_by"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+	DeletedAt   gorm.DeletedAt `json:"-" gorm:"index"`
+	User        User           `json:"user" gorm:"foreignKey:UserID"`
+	APIKeys     []APIKey       `json:"api_keys,omitempty" gorm:"foreignKey:ServiceAccountID"`
+}
+
+// ScopeList 授权范围列表
+func (a *ServiceAccount) ScopeList() []string {
+	if a.Scopes == "" {
+		return nil
+	}
+	return strings.Split(a.Scopes, ",")
+}
+
+// APIKey 服务账户的API密钥，完整密钥只在创建时返回一次，数据库中只保存前缀和哈希值
+type APIKey struct {
+	ID               uint       `json:"id" gorm:"primaryKey"`
+	TenantID         uint       `json:"tenant_id" gorm:"index"`
+	ServiceAccountID uint       `json:"service_account_id" gorm:"index"`
+	Name             string     `json:"name" gorm:"size:50"`
+	Prefix           string     `json:"prefix" gorm:"uniqueIndex;size:16"` // 密钥中的公开部分，用于查找和识别
+	KeyHash          string     `json:"-" gorm:"size:64"`
+	ExpiresAt        time.Time  `json:"expires_at"`
+	LastUsedAt       *time.Time `json:"last_used_at"`
+	LastUsedIP       string     `json:"last_used_ip" gorm:"size:64"`
+	RevokedAt        *time.Time `json:"revoked_at"`
+	RotatedTo        uint       `json:"rotated_to"` // 轮换后替代它的新密钥ID
+	CreatedBy        uint       `json:"created_by"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+}
+
+// Active 密钥是否未撤销且未过期
+func (k *APIKey) Active(now time.Time) bool {
+	return k.RevokedAt == nil && now.Before(k.ExpiresAt)
+}
@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+// 学校状态
+const (
+	TenantStatusDisabled = 0
+	TenantStatusActive   = 1
+)
+
+// Tenant 学校（租户），用户、课程、知识库和上传的资料都归属于某个学校
+type Tenant struct {
+	ID     uint   `json:"id" gorm:"primaryKey"`
+	Name   string `json:"name" gorm:"not null;size:100"`
+	Code   string `json:"code" gorm:"uniqueIndex;not null;size:50"` // 子域名或X-Tenant请求头中使用的标识
+	Status int    `json:"status" gorm:"default:1"`
+
+	// AI服务配置，为空时使用全局配置
+	AIProvider string `json:"ai_provider" gorm:"size:20"` // deepseek、openai
+	AIAPIKey   string `json:"-" gorm:"size:255"`
+	AIBaseURL  string `json:"ai_base_url" gorm:"size:255"`
+	AIModel    string `json:"ai_model" gorm:"size:100"`
+
+	// 配额，0表示不限制
+	MaxUsers        int `json:"max_users"`
+	MaxCourses      int `json:"max_courses"`
+	DailyAIRequests int `json:"daily_ai_requests"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// TwoFactor 用户的TOTP双因素认证设置，Enabled为false时表示正在绑定
+type TwoFactor struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	UserID    uint       `json:"user_id" gorm:"uniqueIndex"`
+	Secret    string     `json:"-" gorm:"size:64"`
+	Enabled   bool       `json:"enabled" gorm:"default:false"`
+	EnabledAt *time.Time `json:"enabled_at"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// RecoveryCode 双因素认证恢复码，与密码一样只保存哈希值
+type RecoveryCode struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	UserID    uint       `json:"user_id" gorm:"index"`
+	CodeHash  string     `json:"-" gorm:"size:255"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
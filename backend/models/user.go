@@ -9,24 +9,52 @@ import (
 type UserRole string
 
 const (
-	RoleAdmin   UserRole = "admin"
-	RoleTeacher UserRole = "teacher"
-	RoleStudent UserRole = "student"
+	RoleSuperAdmin UserRole = "super_admin" // 平台管理员，管理各学校
+	RoleAdmin      UserRole = "admin"       // 学校管理员
+	RoleTeacher    UserRole = "teacher"
+	RoleStudent    UserRole = "student"
+	RoleService    UserRole = "service" // 服务账户，只能通过API密钥访问，不能登录也不能分配给普通用户
 )
 
+// 用户状态
+const (
+	UserStatusDisabled = 0 // 禁用
+	UserStatusActive   = 1 // 正常
+	UserStatusPending  = 2 // 待审核（自助注册的教师）
+)
+
+// 是否为已定义的角色
+func (r UserRole) IsValid() bool {
+	switch r {
+	case RoleSuperAdmin, RoleAdmin, RoleTeacher, RoleStudent:
+		return true
+	}
+	return false
+}
+
+// 是否为管理员（学校管理员或平台管理员）
+func (r UserRole) IsAdmin() bool {
+	return r == RoleAdmin || r == RoleSuperAdmin
+}
+
 type User struct {
-	ID        uint           `json:"id" gorm:"primaryKey"`
-	Username  string         `json:"username" gorm:"uniqueIndex;not null;size:50"`
-	Password  string         `json:"-" gorm:"not null;size:255"`
-	Email     string         `json:"email" gorm:"uniqueIndex;size:100"`
-	RealName  string         `json:"real_name" gorm:"size:50"`
-	Role      UserRole       `json:"role" gorm:"not null;default:'student';size:20"`
-	Avatar    string         `json:"avatar" gorm:"size:255"`
-	Phone     string         `json:"phone" gorm:"size:20"`
-	Status    int            `json:"status" gorm:"default:1"` // 1: 正常, 0: 禁用
-	CreatedAt time.Time      `json:"created_at"`
-	UpdatedAt time.Time      `json:"updated_at"`
-	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+	ID                 uint           `json:"id" gorm:"primaryKey"`
+	TenantID           uint           `json:"tenant_id" gorm:"uniqueIndex:idx_tenant_username;uniqueIndex:idx_tenant_email"`
+	Username           string         `json:"username" gorm:"uniqueIndex:idx_tenant_username;not null;size:50"` // 同一学校内唯一
+	Password           string         `json:"-" gorm:"not null;size:255"`
+	Email              string         `json:"email" gorm:"uniqueIndex:idx_tenant_email;size:100"`
+	EmailUnverified    bool           `json:"-" gorm:"default:false"` // 邮箱由用户注册或修改资料时自行填写，未经验证，不能按邮箱关联外部身份
+	RealName           string         `json:"real_name" gorm:"size:50"`
+	Role               UserRole       `json:"role" gorm:"not null;default:'student';size:20"`
+	Avatar             string         `json:"avatar" gorm:"size:255"`
+	Phone              string         `json:"phone" gorm:"size:20"`
+	Status             int            `json:"status" gorm:"default:1"`                   // 1: 正常, 0: 禁用, 2: 待审核
+	TokenVersion       int            `json:"-" gorm:"default:0"`                        // 令牌版本，递增后已签发的令牌全部失效
+	MustChangePassword bool           `json:"must_change_password" gorm:"default:false"` // 使用导入或管理员重置的密码，登录后必须先修改密码
+	AnonymizedAt       *time.Time     `json:"anonymized_at"`                             // 注销后个人信息已匿名化的时间
+	CreatedAt          time.Time      `json:"created_at"`
+	UpdatedAt          time.Time      `json:"updated_at"`
+	DeletedAt          gorm.DeletedAt `json:"-" gorm:"index"`
 }
 
 type UserProfile struct {
@@ -42,3 +70,19 @@ type UserProfile struct {
 	UpdatedAt  time.Time `json:"updated_at"`
 	User       User      `json:"user" gorm:"foreignKey:UserID"`
 }
+
+// RegistrationInvite 注册邀请码，由管理员发放，决定注册账户的角色
+type RegistrationInvite struct {
+	ID        uint           `json:"id" gorm:"primaryKey"`
+	TenantID  uint           `json:"tenant_id" gorm:"index"`
+	Code      string         `json:"code" gorm:"uniqueIndex;size:32"`
+	Role      UserRole       `json:"role" gorm:"size:20"`
+	MaxUses   int            `json:"max_uses"` // 0表示不限次数
+	UsedCount int            `json:"used_count"`
+	ExpiresAt *time.Time     `json:"expires_at"`
+	CreatedBy uint           `json:"created_by"`
+	Note      string         `json:"note" gorm:"size:200"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+}
@@ -3,6 +3,7 @@ package routes
 import (
 	"backend/handlers"
 	"backend/middleware"
+	"backend/models"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -15,14 +16,18 @@ func SetupRoutes() *gin.Engine {
 	config := cors.DefaultConfig()
 	config.AllowAllOrigins = true
 	config.AllowMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
-	config.AllowHeaders = []string{"Origin", "Content-Type", "Accept", "Authorization"}
+	config.AllowHeaders = []string{"Origin", "Content-Type", "Accept", "Authorization", "X-Tenant", middleware.RequestIDHeader, middleware.APIKeyHeader}
+	config.ExposeHeaders = []string{middleware.RequestIDHeader, "Content-Disposition"}
 	r.Use(cors.New(config))
+	r.Use(middleware.RequestID())
 
-	// 静态文件服务 - 提供上传的文件访问
-	r.Static("/uploads", "./uploads")
+	// 上传目录不再整体公开：头像缩略图公开访问，课程资料通过需要登录的下载接口访问
+	r.GET("/uploads/avatars/:file", handlers.ServeAvatar)
+	r.GET("/uploads/tenants/:tenantId/avatars/:file", handlers.ServeAvatar)
 
 	// API路由组
 	api := r.Group("/api/v1")
+	api.Use(middleware.TenantMiddleware())
 
 	// 公开路由
 	{
@@ -31,6 +36,16 @@ func SetupRoutes() *gin.Engine {
 		{
 			auth.POST("/login", handlers.Login)
 			auth.POST("/register", handlers.Register)
+			auth.POST("/refresh", handlers.RefreshToken)
+			auth.POST("/password-reset/request", handlers.RequestPasswordReset)
+			auth.POST("/password-reset/confirm", handlers.ConfirmPasswordReset)
+			auth.POST("/logout", middleware.AuthMiddleware(), middleware.RejectAPIKey(), handlers.Logout)
+			auth.POST("/2fa/verify", handlers.VerifyTwoFactorLogin)
+			auth.POST("/2fa/setup", handlers.BeginTwoFactorSetupLogin)
+			auth.POST("/2fa/setup/confirm", handlers.ConfirmTwoFactorSetupLogin)
+			auth.GET("/oidc/login", handlers.OIDCLogin)
+			auth.GET("/oidc/callback", handlers.OIDCCallback)
+			auth.POST("/oidc/token", handlers.OIDCToken)
 		}
 
 		// SSE流式AI回复 - 需要从query参数获取token，所以放在公开路由
@@ -44,65 +59,216 @@ func SetupRoutes() *gin.Engine {
 		// 用户相关
 		user := authenticated.Group("/user")
 		{
-			user.GET("/profile", handlers.GetCurrentUser)
-			user.PUT("/password", handlers.ChangePassword)
+			user.GET("/permissions", handlers.GetMyPermissions)
+		}
+
+		// 账户相关操作只能由用户本人登录后进行，不接受API密钥
+		account := authenticated.Group("/user")
+		account.Use(middleware.RejectAPIKey())
+		{
+			account.GET("/profile", handlers.GetCurrentUser)
+			account.PUT("/profile", handlers.UpdateProfile)
+			account.POST("/avatar", handlers.UploadAvatar)
+			account.PUT("/password", handlers.ChangePassword)
+			account.GET("/sessions", handlers.GetMySessions)
+			account.DELETE("/sessions/:sessionId", handlers.RevokeMySession)
+			account.GET("/2fa", handlers.GetTwoFactorStatus)
+			account.POST("/2fa/enroll", handlers.BeginTwoFactorEnrollment)
+			account.POST("/2fa/confirm", handlers.ConfirmTwoFactorEnrollment)
+			account.POST("/2fa/recovery-codes", handlers.RegenerateRecoveryCodes)
+			account.DELETE("/2fa", handlers.DisableTwoFactor)
+			account.GET("/data-exports", handlers.GetMyDataExports)
+			account.POST("/data-exports", handlers.RequestDataExport)
+			account.GET("/data-exports/:id/download", handlers.DownloadDataExport)
+			account.GET("/account-deletion", handlers.GetAccountDeletion)
+			account.POST("/account-deletion", handlers.RequestAccountDeletion)
+			account.DELETE("/account-deletion", handlers.CancelAccountDeletion)
 		}
 
 		// 课程相关
 		courses := authenticated.Group("/courses")
 		{
 			courses.GET("", handlers.GetCourses)
-			courses.GET("/:id", handlers.GetCourses)
+			courses.GET("/:id", handlers.GetCourse)
 			courses.GET("/:id/stats", handlers.GetCourseStats)
+			courses.GET("/:id/analytics", middleware.RequirePermission(models.PermCourseAnalytics, "id"), handlers.GetCourseAnalytics)
+			courses.GET("/:id/risk-alerts", middleware.RequirePermission(models.PermCourseAnalytics, "id"), handlers.GetCourseRiskAlerts)
+			courses.POST("/:courseId/risk-alerts/evaluate", middleware.RequirePermission(models.PermCourseAnalytics, "courseId"), handlers.EvaluateCourseRisk)
+			courses.GET("/:id/mastery", middleware.RequirePermission(models.PermCourseAnalytics, "id"), handlers.GetCourseMastery)
+			courses.GET("/:id/students", middleware.RequirePermission(models.PermCourseStudents, "id"), handlers.GetCourseStudents)
+			courses.POST("/:courseId/students", middleware.RequirePermission(models.PermCourseStudents, "courseId"), handlers.AddCourseStudents)
+			courses.POST("/:courseId/students/class", middleware.RequirePermission(models.PermCourseStudents, "courseId"), handlers.EnrollClass)
+			courses.POST("/:courseId/students/import", middleware.RequirePermission(models.PermCourseStudents, "courseId"), handlers.ImportCourseStudents)
+			courses.PUT("/:id/students/:userId", middleware.RequirePermission(models.PermCourseStudents, "id"), handlers.UpdateEnrollment)
+			courses.POST("/:courseId/invite-code", middleware.RequirePermission(models.PermCourseStudents, "courseId"), handlers.RegenerateInviteCode)
+			courses.GET("/:id/members", middleware.RequirePermission(models.PermCourseMembers, "id"), handlers.GetCourseMembers)
+			courses.POST("/:courseId/members", middleware.RequirePermission(models.PermCourseMembers, "courseId"), handlers.AddCourseMember)
+			courses.DELETE("/:id/members/:userId", middleware.RequirePermission(models.PermCourseMembers, "id"), handlers.RemoveCourseMember)
+			courses.GET("/:id/chat-sessions", middleware.RequirePermission(models.PermChatReview, "id"), handlers.GetCourseChatSessions)
+			courses.GET("/:id/chat-sessions/:sessionId", middleware.RequirePermission(models.PermChatReview, "id"), handlers.GetCourseChatSession)
+			courses.POST("/:courseId/chat-sessions/:sessionId/replies", middleware.RequirePermission(models.PermChatReview, "courseId"), handlers.ReplyCourseChatSession)
 
 			// 教师专用
-			courses.POST("", middleware.RoleMiddleware("teacher"), handlers.CreateCourse)
-			courses.PUT("/:id", middleware.RoleMiddleware("teacher"), handlers.UpdateCourse)
-			courses.DELETE("/:id", middleware.RoleMiddleware("teacher"), handlers.DeleteCourse)
-			courses.POST("/:courseId/chapters/:chapterId/lesson-plan", middleware.RoleMiddleware("teacher"), handlers.GenerateLessonPlan)
+			courses.POST("", middleware.RequirePermission(models.PermCourseCreate), handlers.CreateCourse)
+			courses.PUT("/:id", middleware.RequirePermission(models.PermCourseUpdate, "id"), handlers.UpdateCourse)
+			courses.DELETE("/:id", middleware.RequirePermission(models.PermCourseDelete, "id"), handlers.DeleteCourse)
+			courses.POST("/:courseId/chapters/:chapterId/lesson-plan", middleware.RequirePermission(models.PermLessonPlan, "courseId"), handlers.GenerateLessonPlan)
+		}
+
+		// 学业风险预警
+		authenticated.PUT("/risk-alerts/:id", handlers.UpdateRiskAlert)
+
+		// 通知相关（以下学生学习相关接口只供用户本人登录后使用，不接受API密钥）
+		notifications := authenticated.Group("/notifications")
+		notifications.Use(middleware.RejectAPIKey())
+		{
+			notifications.GET("", handlers.GetNotifications)
+			notifications.PUT("/read-all", handlers.MarkAllNotificationsRead)
+			notifications.PUT("/:id/read", handlers.MarkNotificationRead)
+		}
+
+		// 选课相关
+		enrollments := authenticated.Group("/enrollments")
+		enrollments.Use(middleware.RejectAPIKey())
+		{
+			enrollments.GET("", handlers.GetMyEnrollments)
+			enrollments.POST("/join", handlers.JoinCourse)
+			enrollments.DELETE("/:courseId", handlers.DropCourse)
 		}
 
 		// 课程材料相关 - 使用不同的路径结构避免冲突
-		authenticated.POST("/course-materials/:courseId", middleware.RoleMiddleware("teacher"), handlers.UploadCourseMaterial)
+		authenticated.POST("/course-materials/:courseId", middleware.RequirePermission(models.PermMaterialUpload, "courseId"), handlers.UploadCourseMaterial)
 		authenticated.GET("/course-materials/:courseId", handlers.GetCourseMaterials)
-		authenticated.DELETE("/course-materials/:materialId", middleware.RoleMiddleware("teacher"), handlers.DeleteCourseMaterial)
+		authenticated.GET("/course-materials/:courseId/files/:materialId", handlers.DownloadCourseMaterial)
+		authenticated.DELETE("/course-materials/:materialId", handlers.DeleteCourseMaterial)
 
 		// 练习相关
 		exercises := authenticated.Group("/exercises")
 		{
 			exercises.GET("", handlers.GetExercises)
-			exercises.GET("/:id", handlers.GetExercises)
+			exercises.GET("/:id", handlers.GetExercise)
 			exercises.GET("/stats", handlers.GetExerciseStats)
 
 			// 教师专用
-			exercises.POST("", middleware.RoleMiddleware("teacher"), handlers.CreateExercise)
-			exercises.POST("/:courseId/chapters/:chapterId/generate", middleware.RoleMiddleware("teacher"), handlers.GenerateExercises)
+			exercises.POST("", handlers.CreateExercise)
+			exercises.POST("/:courseId/chapters/:chapterId/generate", middleware.RequirePermission(models.PermExerciseManage, "courseId"), handlers.GenerateExercises)
+			exercises.PUT("/questions/:questionId/knowledge", handlers.SetQuestionKnowledge)
 		}
 
 		// 练习记录相关（学生答题）
 		exerciseRecords := authenticated.Group("/exercise-records")
+		exerciseRecords.Use(middleware.RejectAPIKey())
 		{
 			exerciseRecords.POST("/start/:exerciseId", handlers.StartExercise)
 			exerciseRecords.POST("/:recordId/answers", handlers.SubmitAnswer)
 			exerciseRecords.POST("/:recordId/complete", handlers.CompleteExercise)
+			exerciseRecords.PUT("/:recordId/answers/:answerId/score", handlers.OverrideAnswerScore)
+		}
+
+		// 自适应练习
+		adaptive := authenticated.Group("/adaptive-practice")
+		adaptive.Use(middleware.RejectAPIKey())
+		{
+			adaptive.POST("/start", handlers.StartAdaptivePractice)
+			adaptive.POST("/:recordId/answers", handlers.SubmitAdaptiveAnswer)
+			adaptive.POST("/:recordId/complete", handlers.CompleteAdaptivePractice)
 		}
 
+		// 学习进度
+		progress := authenticated.Group("/progress")
+		progress.Use(middleware.RejectAPIKey())
+		{
+			progress.GET("", handlers.GetMyProgress)
+			progress.GET("/courses/:courseId", handlers.GetCourseProgress)
+			progress.POST("/heartbeat", handlers.ProgressHeartbeat)
+			progress.POST("/chapters/:chapterId/complete", handlers.CompleteChapterProgress)
+			progress.POST("/knowledge/:knowledgeId/complete", handlers.CompleteKnowledgeProgress)
+		}
+
+		// 知识点掌握度
+		authenticated.GET("/mastery", middleware.RejectAPIKey(), handlers.GetMyMastery)
+
 		// 聊天相关
 		chat := authenticated.Group("/chat")
+		chat.Use(middleware.RejectAPIKey())
 		{
 			chat.GET("/sessions", handlers.GetChatSessions)
+			chat.GET("/tags", handlers.GetChatTags)
 			chat.POST("/sessions", handlers.CreateChatSession)
 			chat.GET("/sessions/:id", handlers.GetChatSession)
 			chat.POST("/sessions/:sessionId/messages", handlers.SendMessage)
+			chat.POST("/sessions/:sessionId/messages/:messageId/edit", handlers.EditMessage)
+			chat.POST("/sessions/:sessionId/regenerate", handlers.RegenerateReply)
+			chat.GET("/sessions/:id/tree", handlers.GetChatMessageTree)
+			chat.PUT("/sessions/:id/branch", handlers.SwitchChatBranch)
 			chat.DELETE("/sessions/:id", handlers.DeleteChatSession)
+			chat.PUT("/sessions/:id/share", handlers.ShareChatSession)
+			chat.GET("/shared/:token", handlers.GetSharedChatSession)
 			chat.GET("/advice", handlers.GetLearningAdvice)
 		}
 
 		// 管理相关
 		admin := authenticated.Group("/admin")
-		admin.Use(middleware.RoleMiddleware("admin"))
+		admin.Use(middleware.RequirePermission(models.PermUserManage))
+		{
+			// 用户管理：修改角色和状态、重置密码和双因素认证、匿名化只能由管理员登录后操作，不接受API密钥
+			admin.GET("/users", handlers.AdminListUsers)
+			admin.POST("/users", handlers.AdminCreateUser)
+			admin.POST("/users/import", handlers.AdminImportStudents)
+			admin.GET("/users/:id", handlers.AdminGetUser)
+			admin.PUT("/users/:id/role", middleware.RejectAPIKey(), handlers.AdminUpdateUserRole)
+			admin.PUT("/users/:id/status", middleware.RejectAPIKey(), handlers.AdminUpdateUserStatus)
+			admin.POST("/users/:id/reset-password", middleware.RejectAPIKey(), handlers.AdminResetPassword)
+			admin.DELETE("/users/:id", handlers.AdminDeleteUser)
+			admin.POST("/users/:id/restore", handlers.AdminRestoreUser)
+			admin.GET("/users/:id/sessions", handlers.AdminGetUserSessions)
+			admin.POST("/users/:id/logout", handlers.AdminForceLogout)
+			admin.POST("/users/:id/unlock", handlers.AdminUnlockUser)
+			admin.DELETE("/users/:id/2fa", middleware.RejectAPIKey(), handlers.AdminResetTwoFactor)
+			admin.POST("/users/:id/anonymize", middleware.RejectAPIKey(), handlers.AdminAnonymizeUser)
+			admin.GET("/account-deletions", handlers.AdminListAccountDeletions)
+			admin.POST("/login-locks/unlock-ip", handlers.AdminUnlockIP)
+
+			// 注册审核与邀请码：审核会授予教师角色，不接受API密钥
+			admin.GET("/teacher-applications", handlers.AdminListPendingTeachers)
+			admin.POST("/users/:id/review", middleware.RejectAPIKey(), handlers.AdminReviewTeacher)
+			admin.GET("/invites", handlers.AdminListInvites)
+			admin.POST("/invites", handlers.AdminCreateInvite)
+			admin.DELETE("/invites/:id", handlers.AdminDeleteInvite)
+
+			// 角色权限
+			admin.GET("/permissions", middleware.RequirePermission(models.PermRoleManage), handlers.AdminListPermissions)
+			admin.GET("/roles", middleware.RequirePermission(models.PermRoleManage), handlers.AdminListRoles)
+			admin.PUT("/roles/:id/permissions", middleware.RequirePermission(models.PermRoleManage), handlers.AdminUpdateRolePermissions)
+
+			// 审计日志
+			admin.GET("/audit-logs", middleware.RequirePermission(models.PermAuditView), handlers.AdminListAuditLogs)
+			admin.GET("/audit-logs/export", middleware.RequirePermission(models.PermAuditView), handlers.AdminExportAuditLogs)
+
+			// 服务账户与API密钥
+			admin.GET("/api-scopes", middleware.RequirePermission(models.PermServiceAccount), handlers.AdminListAPIScopes)
+			admin.GET("/service-accounts", middleware.RequirePermission(models.PermServiceAccount), handlers.AdminListServiceAccounts)
+			admin.POST("/service-accounts", middleware.RequirePermission(models.PermServiceAccount), handlers.AdminCreateServiceAccount)
+			admin.GET("/service-accounts/:id", middleware.RequirePermission(models.PermServiceAccount), handlers.AdminGetServiceAccount)
+			admin.PUT("/service-accounts/:id", middleware.RequirePermission(models.PermServiceAccount), handlers.AdminUpdateServiceAccount)
+			admin.DELETE("/service-accounts/:id", middleware.RequirePermission(models.PermServiceAccount), handlers.AdminDeleteServiceAccount)
+			admin.POST("/service-accounts/:id/keys", middleware.RequirePermission(models.PermServiceAccount), handlers.AdminCreateAPIKey)
+			admin.POST("/service-accounts/:id/keys/:keyId/rotate", middleware.RequirePermission(models.PermServiceAccount), handlers.AdminRotateAPIKey)
+			admin.DELETE("/service-accounts/:id/keys/:keyId", middleware.RequirePermission(models.PermServiceAccount), handlers.AdminRevokeAPIKey)
+
+			// 本校信息与AI配置，不接受API密钥
+			admin.GET("/tenant", middleware.RejectAPIKey(), handlers.AdminGetTenant)
+			admin.PUT("/tenant/ai", middleware.RejectAPIKey(), handlers.AdminUpdateTenantAI)
+		}
+
+		// 平台管理（平台管理员）
+		platform := authenticated.Group("/platform")
+		platform.Use(middleware.RequirePermission(models.PermTenantManage))
 		{
-			// 这里可以添加管理员专用接口
+			platform.GET("/tenants", handlers.PlatformListTenants)
+			platform.POST("/tenants", handlers.PlatformCreateTenant)
+			platform.PUT("/tenants/:id", handlers.PlatformUpdateTenant)
+			platform.POST("/tenants/:id/admins", handlers.PlatformCreateTenantAdmin)
 		}
 	}
 
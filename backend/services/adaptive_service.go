@@ -0,0 +1,161 @@
+package services
+
+import (
+	"backend/database"
+	"backend/models"
+	"math"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// DefaultAdaptiveQuestionLimit 自适应练习默认最多题数
+	DefaultAdaptiveQuestionLimit = 10
+	// MaxAdaptiveQuestionLimit 自适应练习题数上限
+	MaxAdaptiveQuestionLimit = 50
+)
+
+// AdaptivePracticeService 自适应练习选题服务
+type AdaptivePracticeService struct {
+	db *gorm.DB
+}
+
+// NewAdaptivePracticeService 创建自适应练习服务实例
+func NewAdaptivePracticeService() *AdaptivePracticeService {
+	return &AdaptivePracticeService{db: database.DB}
+}
+
+// ChapterQuestionBank 获取章节题库（章节下所有启用练习中的题目）
+func (s *AdaptivePracticeService) ChapterQuestionBank(chapterID uint) ([]models.Question, error) {
+	var questions []models.Question
+	err := s.db.Joins("JOIN exercises ON exercises.id = questions.exercise_id AND exercises.deleted_at IS NULL").
+		Where("exercises.chapter_id = ? AND exercises.status = ?", chapterID, 1).
+		Find(&questions).Error
+	return questions, err
+}
+
+// NextQuestion 为练习记录选择下一题；返回nil表示练习应结束
+func (s *AdaptivePracticeService) NextQuestion(record *models.ExerciseRecord) (*models.Question, error) {
+	if record.ChapterID == nil {
+		return nil, nil
+	}
+
+	var answered []uint
+	if err := s.db.Model(&models.StudentAnswer{}).
+		Where("record_id = ?", record.ID).
+		Pluck("question_id", &answered).Error; err != nil {
+		return nil, err
+	}
+	if len(answered) >= record.QuestionLimit {
+		return nil, nil
+	}
+
+	bank, err := s.ChapterQuestionBank(*record.ChapterID)
+	if err != nil {
+		return nil, err
+	}
+
+	masteryByKnowledge, err := s.chapterMastery(record.UserID, *record.ChapterID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 题库涉及的知识点全部达到目标掌握度时结束
+	if s.reachedTarget(bank, masteryByKnowledge, record.TargetMastery) {
+		return nil, nil
+	}
+
+	answeredSet := make(map[uint]bool, len(answered))
+	for _, id := range answered {
+		answeredSet[id] = true
+	}
+
+	var best *models.Question
+	bestScore := math.Inf(-1)
+	for i := range bank {
+		q := &bank[i]
+		if answeredSet[q.ID] {
+			continue
+		}
+		if score := s.questionScore(q, masteryByKnowledge); score > bestScore {
+			best, bestScore = q, score
+		}
+	}
+
+	return best, nil
+}
+
+// ChapterMasteryAverage 计算章节题库涉及知识点的平均掌握度
+func (s *AdaptivePracticeService) ChapterMasteryAverage(userID, chapterID uint) (float64, error) {
+	bank, err := s.ChapterQuestionBank(chapterID)
+	if err != nil {
+		return 0, err
+	}
+	masteryByKnowledge, err := s.chapterMastery(userID, chapterID)
+	if err != nil {
+		return 0, err
+	}
+
+	seen := make(map[uint]bool)
+	var total float64
+	for _, q := range bank {
+		if q.KnowledgeID == nil || seen[*q.KnowledgeID] {
+			continue
+		}
+		seen[*q.KnowledgeID] = true
+		total += knowledgeMastery(masteryByKnowledge, q.KnowledgeID)
+	}
+	if len(seen) == 0 {
+		return 0, nil
+	}
+	return total / float64(len(seen)), nil
+}
+
+func (s *AdaptivePracticeService) chapterMastery(userID, chapterID uint) (map[uint]float64, error) {
+	var masteries []models.KnowledgeMastery
+	if err := s.db.Where("user_id = ? AND chapter_id = ?", userID, chapterID).Find(&masteries).Error; err != nil {
+		return nil, err
+	}
+
+	result := make(map[uint]float64, len(masteries))
+	for _, m := range masteries {
+		result[m.KnowledgeID] = m.Mastery
+	}
+	return result, nil
+}
+
+func (s *AdaptivePracticeService) reachedTarget(bank []models.Question, masteryByKnowledge map[uint]float64, target float64) bool {
+	covered := false
+	for _, q := range bank {
+		if q.KnowledgeID == nil {
+			continue
+		}
+		covered = true
+		if knowledgeMastery(masteryByKnowledge, q.KnowledgeID) < target {
+			return false
+		}
+	}
+	return covered
+}
+
+// questionScore 优先选择掌握度低的知识点，并使题目难度贴近当前掌握水平
+func (s *AdaptivePracticeService) questionScore(q *models.Question, masteryByKnowledge map[uint]float64) float64 {
+	mastery := knowledgeMastery(masteryByKnowledge, q.KnowledgeID)
+	difficulty := q.Difficulty
+	if difficulty < 1 || difficulty > 5 {
+		difficulty = 3
+	}
+	targetDifficulty := 1 + 4*mastery
+
+	return 2*(1-mastery) - math.Abs(float64(difficulty)-targetDifficulty)/4
+}
+
+func knowledgeMastery(masteryByKnowledge map[uint]float64, knowledgeID *uint) float64 {
+	if knowledgeID == nil {
+		return bktInitial
+	}
+	if m, ok := masteryByKnowledge[*knowledgeID]; ok {
+		return m
+	}
+	return bktInitial
+}
@@ -21,13 +21,53 @@ type AIService struct {
 }
 
 func NewAIService() *AIService {
+	return newAIService(config.GlobalConfig.AI)
+}
+
+// NewAIServiceForTenant 使用学校自己的AI配置创建服务，学校未配置的项沿用全局配置
+func NewAIServiceForTenant(tenant *models.Tenant) *AIService {
 	cfg := config.GlobalConfig.AI
+	if tenant == nil {
+		return newAIService(cfg)
+	}
+	if tenant.AIProvider != "" {
+		cfg.Provider = tenant.AIProvider
+	}
+	switch cfg.Provider {
+	case "deepseek":
+		if tenant.AIAPIKey != "" {
+			cfg.DeepSeekAPIKey = tenant.AIAPIKey
+		}
+		if tenant.AIBaseURL != "" {
+			cfg.DeepSeekBaseURL = tenant.AIBaseURL
+		}
+		if tenant.AIModel != "" {
+			cfg.DeepSeekModel = tenant.AIModel
+		}
+	case "openai":
+		if tenant.AIAPIKey != "" {
+			cfg.OpenAIAPIKey = tenant.AIAPIKey
+		}
+		if tenant.AIBaseURL != "" {
+			cfg.OpenAIBaseURL = tenant.AIBaseURL
+		}
+		if tenant.AIModel != "" {
+			cfg.OpenAIModel = tenant.AIModel
+		}
+	}
+	return newAIService(cfg)
+}
 
+func newAIService(cfg config.AIConfig) *AIService {
 	// 创建 OpenAI 客户端（备用）
-	openaiClient := openai.NewClient(cfg.OpenAIAPIKey)
+	openaiConfig := openai.DefaultConfig(cfg.OpenAIAPIKey)
+	if cfg.OpenAIBaseURL != "" {
+		openaiConfig.BaseURL = cfg.OpenAIBaseURL
+	}
+	openaiClient := openai.NewClientWithConfig(openaiConfig)
 
 	// 创建 DeepSeek 服务
-	deepseekService := NewDeepSeekService()
+	deepseekService := newDeepSeekService(cfg)
 
 	// 创建讯飞星火X1服务
 	var xunfei *XunfeiX1Service
@@ -182,23 +222,64 @@ func (s *AIService) EvaluateAnswer(question *models.Question, studentAnswer stri
 }
 
 // 生成学习建议
-func (s *AIService) GenerateLearningAdvice(userID uint, progress []models.LearningProgress, answers []models.StudentAnswer) (string, error) {
+func (s *AIService) GenerateLearningAdvice(userID uint, progress []models.LearningProgress, answers []models.StudentAnswer, masteries []models.KnowledgeMastery) (string, error) {
 	prompt := fmt.Sprintf(`
 基于学生的学习数据，生成个性化学习建议：
 
 学习进度：%s
 答题情况：%s
+知识点掌握度（0-1，低于%.2f为薄弱）：
+%s
 
 请分析学生的学习情况，提供：
-1. 薄弱知识点
+1. 薄弱知识点（请以知识点掌握度为依据）
 2. 学习建议
 3. 推荐练习
 4. 学习方法指导
-`, s.formatProgress(progress), s.formatAnswers(answers))
+`, s.formatProgress(progress), s.formatAnswers(answers), WeakMasteryThreshold, s.formatMastery(masteries))
 
 	return s.chatCompletion(prompt)
 }
 
+// SummarizeChat 根据会话的第一轮问答生成简短标题和主题标签
+func (s *AIService) SummarizeChat(question, answer string) (string, []string, error) {
+	prompt := fmt.Sprintf(`
+请根据以下学生与AI助教的对话，生成会话标题和主题标签：
+
+学生提问：%s
+AI回答：%s
+
+要求：
+1. 标题不超过20个字，概括学生想解决的问题，不要加引号和标点结尾
+2. 标签1-3个，每个不超过10个字，为涉及的知识主题，如"递归"、"指针"
+
+请只返回JSON，不要包含其他内容：
+{
+  "title": "标题",
+  "tags": ["标签1", "标签2"]
+}
+`, truncateRunes(question, 500), truncateRunes(answer, 1000))
+
+	response, err := s.chatCompletion(prompt)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// 部分模型会把JSON放在代码块中，只取大括号内的内容
+	start, end := strings.Index(response, "{"), strings.LastIndex(response, "}")
+	if start < 0 || end < start {
+		return "", nil, fmt.Errorf("无法解析AI返回的标题: %s", response)
+	}
+	var result struct {
+		Title string   `json:"title"`
+		Tags  []string `json:"tags"`
+	}
+	if err := json.Unmarshal([]byte(response[start:end+1]), &result); err != nil {
+		return "", nil, err
+	}
+	return result.Title, result.Tags, nil
+}
+
 // 私有方法
 func (s *AIService) chatCompletion(prompt string) (string, error) {
 	// 根据配置选择AI提供商
@@ -286,6 +367,26 @@ func (s *AIService) formatProgress(progress []models.LearningProgress) string {
 	return result.String()
 }
 
+func (s *AIService) formatMastery(masteries []models.KnowledgeMastery) string {
+	if len(masteries) == 0 {
+		return "暂无知识点掌握数据\n"
+	}
+
+	var result strings.Builder
+	for _, m := range masteries {
+		label := "一般"
+		switch {
+		case m.Mastery >= MasteryThreshold:
+			label = "已掌握"
+		case m.Mastery < WeakMasteryThreshold:
+			label = "薄弱"
+		}
+		result.WriteString(fmt.Sprintf("- %s：%.2f（%s，作答%d次，答对%d次）\n",
+			m.Knowledge.Title, m.Mastery, label, m.Attempts, m.CorrectCount))
+	}
+	return result.String()
+}
+
 func (s *AIService) formatAnswers(answers []models.StudentAnswer) string {
 	var result strings.Builder
 	for _, a := range answers {
@@ -294,3 +395,12 @@ func (s *AIService) formatAnswers(answers []models.StudentAnswer) string {
 	}
 	return result.String()
 }
+
+// 按字符截断过长的文本
+func truncateRunes(text string, max int) string {
+	runes := []rune(text)
+	if len(runes) <= max {
+		return text
+	}
+	return string(runes[:max]) + "..."
+}
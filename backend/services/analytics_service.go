@@ -0,0 +1,455 @@
+package services
+
+import (
+	"backend/database"
+	"backend/models"
+	"backend/redis"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// analyticsCacheTTL 课程分析结果缓存时长
+const analyticsCacheTTL = 10 * time.Minute
+
+// AnalyticsService 课程学情分析服务
+type AnalyticsService struct {
+	db *gorm.DB
+}
+
+// CourseAnalytics 课程学情分析结果
+type CourseAnalytics struct {
+	CourseID        uint                `json:"course_id"`
+	Start           time.Time           `json:"start"`
+	End             time.Time           `json:"end"`
+	EnrolledCount   int                 `json:"enrolled_count"`
+	ActiveStudents  []DailyActive       `json:"active_students"`
+	Chapters        []ChapterAnalytics  `json:"chapters"`
+	Exercises       []ExerciseAnalytics `json:"exercises"`
+	ChatTopics      []ChatTopic         `json:"chat_topics"`
+	Students        []StudentAnalytics  `json:"students"`
+	GeneratedAt     time.Time           `json:"generated_at"`
+	TotalChatCount  int                 `json:"total_chat_count"`
+	TotalSubmission int                 `json:"total_submission"`
+}
+
+type DailyActive struct {
+	Date  string `json:"date"`
+	Count int    `json:"count"`
+}
+
+type ChapterAnalytics struct {
+	ChapterID      uint    `json:"chapter_id"`
+	Title          string  `json:"title"`
+	CompletedCount int     `json:"completed_count"`
+	CompletionRate float64 `json:"completion_rate"` // 0-100
+	AvgProgress    float64 `json:"avg_progress"`    // 0-100
+}
+
+type ExerciseAnalytics struct {
+	ExerciseID     uint    `json:"exercise_id"`
+	Title          string  `json:"title"`
+	Type           string  `json:"type"`
+	SubmittedCount int     `json:"submitted_count"`
+	SubmissionRate float64 `json:"submission_rate"` // 0-100
+	AvgScore       float64 `json:"avg_score"`
+}
+
+type ChatTopic struct {
+	KnowledgeID uint   `json:"knowledge_id"`
+	Title       string `json:"title"`
+	Count       int    `json:"count"`
+}
+
+type StudentAnalytics struct {
+	UserID             uint       `json:"user_id"`
+	Username           string     `json:"username"`
+	RealName           string     `json:"real_name"`
+	Class              string     `json:"class"`
+	Progress           float64    `json:"progress"` // 课程平均进度 0-100
+	TimeSpent          int        `json:"time_spent"`
+	CompletedExercises int        `json:"completed_exercises"`
+	AvgScore           float64    `json:"avg_score"`
+	ChatCount          int        `json:"chat_count"`
+	LastActivityAt     *time.Time `json:"last_activity_at"`
+}
+
+type activityRow struct {
+	UserID uint
+	At     time.Time
+}
+
+type recordRow struct {
+	UserID     uint
+	ExerciseID uint
+	Score      int
+	At         time.Time
+}
+
+type chatRow struct {
+	UserID  uint
+	At      time.Time
+	Content string
+}
+
+// NewAnalyticsService 创建学情分析服务实例
+func NewAnalyticsService() *AnalyticsService {
+	return &AnalyticsService{db: database.DB}
+}
+
+// CourseAnalytics 获取课程学情分析，结果按时间范围缓存在Redis中；refresh为true时重新计算
+func (s *AnalyticsService) CourseAnalytics(courseID uint, start, end time.Time, refresh bool) (*CourseAnalytics, error) {
+	ctx := context.Background()
+	cacheKey := fmt.Sprintf("analytics:course:%d:%s:%s", courseID, start.Format("20060102"), end.Format("20060102"))
+
+	if !refresh {
+		if cached, err := redis.GetCache(ctx, cacheKey); err == nil {
+			var result CourseAnalytics
+			if err := json.Unmarshal([]byte(cached), &result); err == nil {
+				return &result, nil
+			}
+		}
+	}
+
+	result, err := s.compute(courseID, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	if data, err := json.Marshal(result); err == nil {
+		redis.SetCache(ctx, cacheKey, data, analyticsCacheTTL)
+	}
+	return result, nil
+}
+
+func (s *AnalyticsService) compute(courseID uint, start, end time.Time) (*CourseAnalytics, error) {
+	// end为包含当天的结束日期
+	until := end.AddDate(0, 0, 1)
+
+	result := &CourseAnalytics{
+		CourseID:    courseID,
+		Start:       start,
+		End:         end,
+		GeneratedAt: time.Now(),
+	}
+
+	var studentIDs []uint
+	if err := s.db.Model(&models.Enrollment{}).
+		Where("course_id = ? AND status = ?", courseID, models.EnrollmentActive).
+		Pluck("user_id", &studentIDs).Error; err != nil {
+		return nil, err
+	}
+	result.EnrolledCount = len(studentIDs)
+
+	var answers []activityRow
+	if err := s.db.Model(&models.StudentAnswer{}).
+		Select("student_answers.user_id, student_answers.created_at AS at").
+		Joins("JOIN exercises ON exercises.id = student_answers.exercise_id").
+		Where("exercises.course_id = ? AND student_answers.created_at >= ? AND student_answers.created_at < ?", courseID, start, until).
+		Scan(&answers).Error; err != nil {
+		return nil, err
+	}
+
+	var records []recordRow
+	if err := s.db.Model(&models.ExerciseRecord{}).
+		Select("exercise_records.user_id, exercise_records.exercise_id, exercise_records.score, exercise_records.updated_at AS at").
+		Joins("JOIN exercises ON exercises.id = exercise_records.exercise_id").
+		Where("exercises.course_id = ? AND exercise_records.status = ? AND exercise_records.updated_at >= ? AND exercise_records.updated_at < ?",
+			courseID, "completed", start, until).
+		Scan(&records).Error; err != nil {
+		return nil, err
+	}
+
+	var chats []chatRow
+	if err := s.db.Model(&models.ChatMessage{}).
+		Select("chat_sessions.user_id, chat_messages.created_at AS at, chat_messages.content").
+		Joins("JOIN chat_sessions ON chat_sessions.id = chat_messages.session_id").
+		Where("chat_sessions.course_id = ? AND chat_messages.role = ? AND chat_messages.created_at >= ? AND chat_messages.created_at < ?",
+			courseID, models.ChatRoleUser, start, until).
+		Scan(&chats).Error; err != nil {
+		return nil, err
+	}
+	result.TotalChatCount = len(chats)
+	result.TotalSubmission = len(records)
+
+	var progress []models.LearningProgress
+	if err := s.db.Where("course_id = ?", courseID).Find(&progress).Error; err != nil {
+		return nil, err
+	}
+
+	enrolled := make(map[uint]bool, len(studentIDs))
+	for _, id := range studentIDs {
+		enrolled[id] = true
+	}
+
+	result.ActiveStudents = s.dailyActive(start, end, enrolled, answers, records, chats, progress)
+
+	chapters, err := s.chapterAnalytics(courseID, enrolled, progress)
+	if err != nil {
+		return nil, err
+	}
+	result.Chapters = chapters
+
+	exercises, err := s.exerciseAnalytics(courseID, enrolled, records)
+	if err != nil {
+		return nil, err
+	}
+	result.Exercises = exercises
+
+	topics, err := s.chatTopics(courseID, chats)
+	if err != nil {
+		return nil, err
+	}
+	result.ChatTopics = topics
+
+	students, err := s.studentAnalytics(courseID, studentIDs, answers, records, chats, progress)
+	if err != nil {
+		return nil, err
+	}
+	result.Students = students
+
+	return result, nil
+}
+
+func (s *AnalyticsService) dailyActive(start, end time.Time, enrolled map[uint]bool, answers []activityRow, records []recordRow, chats []chatRow, progress []models.LearningProgress) []DailyActive {
+	days := make(map[string]map[uint]bool)
+	mark := func(userID uint, at time.Time) {
+		if !enrolled[userID] {
+			return
+		}
+		day := at.Format("2006-01-02")
+		if days[day] == nil {
+			days[day] = make(map[uint]bool)
+		}
+		days[day][userID] = true
+	}
+
+	for _, a := range answers {
+		mark(a.UserID, a.At)
+	}
+	for _, r := range records {
+		mark(r.UserID, r.At)
+	}
+	for _, c := range chats {
+		mark(c.UserID, c.At)
+	}
+	until := end.AddDate(0, 0, 1)
+	for _, p := range progress {
+		if !p.LastStudyAt.Before(start) && p.LastStudyAt.Before(until) {
+			mark(p.UserID, p.LastStudyAt)
+		}
+	}
+
+	var result []DailyActive
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		day := d.Format("2006-01-02")
+		result = append(result, DailyActive{Date: day, Count: len(days[day])})
+	}
+	return result
+}
+
+func (s *AnalyticsService) chapterAnalytics(courseID uint, enrolled map[uint]bool, progress []models.LearningProgress) ([]ChapterAnalytics, error) {
+	var chapters []models.Chapter
+	if err := s.db.Where("course_id = ?", courseID).Order("`order` ASC, id ASC").Find(&chapters).Error; err != nil {
+		return nil, err
+	}
+
+	type agg struct {
+		completed int
+		total     float64
+	}
+	byChapter := make(map[uint]*agg)
+	for _, p := range progress {
+		if !enrolled[p.UserID] {
+			continue
+		}
+		a := byChapter[p.ChapterID]
+		if a == nil {
+			a = &agg{}
+			byChapter[p.ChapterID] = a
+		}
+		a.total += p.Progress
+		if p.Completed || p.Progress >= 100 {
+			a.completed++
+		}
+	}
+
+	result := make([]ChapterAnalytics, 0, len(chapters))
+	for _, ch := range chapters {
+		item := ChapterAnalytics{ChapterID: ch.ID, Title: ch.Title}
+		if a := byChapter[ch.ID]; a != nil && len(enrolled) > 0 {
+			item.CompletedCount = a.completed
+			item.CompletionRate = float64(a.completed) / float64(len(enrolled)) * 100
+			item.AvgProgress = a.total / float64(len(enrolled))
+		}
+		result = append(result, item)
+	}
+	return result, nil
+}
+
+func (s *AnalyticsService) exerciseAnalytics(courseID uint, enrolled map[uint]bool, records []recordRow) ([]ExerciseAnalytics, error) {
+	var exercises []models.Exercise
+	if err := s.db.Where("course_id = ?", courseID).Order("id ASC").Find(&exercises).Error; err != nil {
+		return nil, err
+	}
+
+	type agg struct {
+		users      map[uint]bool
+		scoreTotal int
+		count      int
+	}
+	byExercise := make(map[uint]*agg)
+	for _, r := range records {
+		// 只统计在读学生，教师试做和已退课学生的记录不计入
+		if !enrolled[r.UserID] {
+			continue
+		}
+		a := byExercise[r.ExerciseID]
+		if a == nil {
+			a = &agg{users: make(map[uint]bool)}
+			byExercise[r.ExerciseID] = a
+		}
+		a.users[r.UserID] = true
+		a.scoreTotal += r.Score
+		a.count++
+	}
+
+	result := make([]ExerciseAnalytics, 0, len(exercises))
+	for _, e := range exercises {
+		item := ExerciseAnalytics{ExerciseID: e.ID, Title: e.Title, Type: e.Type}
+		if a := byExercise[e.ID]; a != nil {
+			item.SubmittedCount = len(a.users)
+			item.AvgScore = float64(a.scoreTotal) / float64(a.count)
+			if len(enrolled) > 0 {
+				item.SubmissionRate = float64(len(a.users)) / float64(len(enrolled)) * 100
+			}
+		}
+		result = append(result, item)
+	}
+	return result, nil
+}
+
+// chatTopics 按课程知识点标题和关键词统计学生提问涉及的主题
+func (s *AnalyticsService) chatTopics(courseID uint, chats []chatRow) ([]ChatTopic, error) {
+	var knowledge []models.Knowledge
+	if err := s.db.Joins("JOIN chapters ON chapters.id = knowledges.chapter_id").
+		Where("chapters.course_id = ?", courseID).
+		Find(&knowledge).Error; err != nil {
+		return nil, err
+	}
+
+	var topics []ChatTopic
+	for _, k := range knowledge {
+		terms := []string{strings.ToLower(k.Title)}
+		for _, kw := range strings.Split(k.Keywords, ",") {
+			if kw = strings.ToLower(strings.TrimSpace(kw)); kw != "" {
+				terms = append(terms, kw)
+			}
+		}
+
+		count := 0
+		for _, c := range chats {
+			content := strings.ToLower(c.Content)
+			for _, term := range terms {
+				if term != "" && strings.Contains(content, term) {
+					count++
+					break
+				}
+			}
+		}
+		if count > 0 {
+			topics = append(topics, ChatTopic{KnowledgeID: k.ID, Title: k.Title, Count: count})
+		}
+	}
+
+	sort.Slice(topics, func(i, j int) bool { return topics[i].Count > topics[j].Count })
+	if len(topics) > 10 {
+		topics = topics[:10]
+	}
+	return topics, nil
+}
+
+func (s *AnalyticsService) studentAnalytics(courseID uint, studentIDs []uint, answers []activityRow, records []recordRow, chats []chatRow, progress []models.LearningProgress) ([]StudentAnalytics, error) {
+	if len(studentIDs) == 0 {
+		return []StudentAnalytics{}, nil
+	}
+
+	var users []models.User
+	if err := s.db.Where("id IN ?", studentIDs).Order("username ASC").Find(&users).Error; err != nil {
+		return nil, err
+	}
+
+	var profiles []models.UserProfile
+	if err := s.db.Where("user_id IN ?", studentIDs).Find(&profiles).Error; err != nil {
+		return nil, err
+	}
+	classByUser := make(map[uint]string, len(profiles))
+	for _, p := range profiles {
+		classByUser[p.UserID] = p.Class
+	}
+
+	var chapterCount int64
+	s.db.Model(&models.Chapter{}).Where("course_id = ?", courseID).Count(&chapterCount)
+
+	rows := make(map[uint]*StudentAnalytics, len(users))
+	result := make([]StudentAnalytics, len(users))
+	for i, u := range users {
+		result[i] = StudentAnalytics{
+			UserID:   u.ID,
+			Username: u.Username,
+			RealName: u.RealName,
+			Class:    classByUser[u.ID],
+		}
+		rows[u.ID] = &result[i]
+	}
+
+	touch := func(row *StudentAnalytics, at time.Time) {
+		if row.LastActivityAt == nil || at.After(*row.LastActivityAt) {
+			t := at
+			row.LastActivityAt = &t
+		}
+	}
+
+	for _, p := range progress {
+		if row := rows[p.UserID]; row != nil {
+			row.Progress += p.Progress
+			row.TimeSpent += p.TimeSpent
+			touch(row, p.LastStudyAt)
+		}
+	}
+	scoreTotals := make(map[uint]int)
+	for _, r := range records {
+		if row := rows[r.UserID]; row != nil {
+			row.CompletedExercises++
+			scoreTotals[r.UserID] += r.Score
+			touch(row, r.At)
+		}
+	}
+	for _, a := range answers {
+		if row := rows[a.UserID]; row != nil {
+			touch(row, a.At)
+		}
+	}
+	for _, c := range chats {
+		if row := rows[c.UserID]; row != nil {
+			row.ChatCount++
+			touch(row, c.At)
+		}
+	}
+
+	for i := range result {
+		row := &result[i]
+		if chapterCount > 0 {
+			row.Progress /= float64(chapterCount)
+		}
+		if row.CompletedExercises > 0 {
+			row.AvgScore = float64(scoreTotals[row.UserID]) / float64(row.CompletedExercises)
+		}
+	}
+	return result, nil
+}
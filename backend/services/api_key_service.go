@@ -0,0 +1,331 @@
+package services
+
+import (
+	"backend/database"
+	"backend/models"
+	"backend/utils"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	apiKeyPrefixLength = 12
+	apiKeySecretLength = 40
+	apiKeyLastUsedStep = time.Minute // 最近使用时间的更新间隔，避免每个请求都写数据库
+	APIKeyTokenPrefix  = "sk_"
+)
+
+var (
+	ErrUnknownScope    = errors.New("授权范围不存在")
+	ErrScopeRequired   = errors.New("请至少选择一个授权范围")
+	ErrAPIKeyInvalid   = errors.New("API密钥无效")
+	ErrAPIKeyExpired   = errors.New("API密钥已过期或已撤销")
+	ErrServiceDisabled = errors.New("服务账户已停用")
+)
+
+// APIScope 服务账户的授权范围，由若干权限组成；只读范围只允许GET请求
+type APIScope struct {
+	Code        string   `json:"code"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+	ReadOnly    bool     `json:"read_only"`
+}
+
+// 可授予服务账户的范围，不包含学校管理、角色权限和服务账户管理，避免密钥被用来提升权限
+var apiScopes = []APIScope{
+	{Code: "grades:read", Name: "成绩只读", Description: "读取课程学生名单、学情分析、掌握度和风险预警",
+		Permissions: []string{models.PermCourseAnalytics, models.PermCourseStudents}, ReadOnly: true},
+	{Code: "students:write", Name: "选课管理", Description: "批量导入、添加和移出选课学生",
+		Permissions: []string{models.PermCourseStudents}},
+	{Code: "content:write", Name: "内容管理", Description: "编辑课程、上传和删除资料、管理练习和备课内容",
+		Permissions: []string{models.PermCourseUpdate, models.PermMaterialUpload, models.PermMaterialDelete,
+			models.PermExerciseManage, models.PermLessonPlan}},
+	{Code: "users:read", Name: "用户只读", Description: "查询本校用户、注册申请和注销申请",
+		Permissions: []string{models.PermUserManage}, ReadOnly: true},
+	{Code: "users:write", Name: "用户管理", Description: "创建、导入和管理本校用户",
+		Permissions: []string{models.PermUserManage}},
+}
+
+// APIKeyIdentity API密钥认证通过后的调用方
+type APIKeyIdentity struct {
+	Key     *models.APIKey
+	Account *models.ServiceAccount
+}
+
+// APIKeyService 服务账户与API密钥
+type APIKeyService struct {
+	db *gorm.DB
+}
+
+// NewAPIKeyService 创建API密钥服务实例
+func NewAPIKeyService() *APIKeyService {
+	return &APIKeyService{db: database.DB}
+}
+
+// Scopes 所有可授予的范围
+func (s *APIKeyService) Scopes() []APIScope {
+	return apiScopes
+}
+
+// NormalizeScopes 校验并去重授权范围，按定义顺序返回逗号分隔的字符串
+func (s *APIKeyService) NormalizeScopes(codes []string) (string, error) {
+	if len(codes) == 0 {
+		return "", ErrScopeRequired
+	}
+	selected := map[string]bool{}
+	for _, code := range codes {
+		if findScope(code) == nil {
+			return "", fmt.Errorf("%w: %s", ErrUnknownScope, code)
+		}
+		selected[code] = true
+	}
+	list := make([]string, 0, len(selected))
+	for _, scope := range apiScopes {
+		if selected[scope.Code] {
+			list = append(list, scope.Code)
+		}
+	}
+	return strings.Join(list, ","), nil
+}
+
+func findScope(code string) *APIScope {
+	for i := range apiScopes {
+		if apiScopes[i].Code == code {
+			return &apiScopes[i]
+		}
+	}
+	return nil
+}
+
+// ScopeAllows 授权范围是否允许以method方法使用permission权限
+func (s *APIKeyService) ScopeAllows(scopes []string, permission, method string) bool {
+	readOnly := method == http.MethodGet || method == http.MethodHead
+	for _, code := range scopes {
+		scope := findScope(code)
+		if scope == nil || (scope.ReadOnly && !readOnly) {
+			continue
+		}
+		for _, p := range scope.Permissions {
+			if p == permission {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ScopePermissions 授权范围包含的权限编码
+func (s *APIKeyService) ScopePermissions(scopes []string) []string {
+	set := map[string]bool{}
+	for _, code := range scopes {
+		if scope := findScope(code); scope != nil {
+			for _, p := range scope.Permissions {
+				set[p] = true
+			}
+		}
+	}
+	codes := make([]string, 0, len(set))
+	for code := range set {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// CreateAccount 创建服务账户及其对应的用户，该用户的密码随机生成且不会返回，因此无法登录
+func (s *APIKeyService) CreateAccount(tenantID uint, name, description, scopes string, createdBy uint) (*models.ServiceAccount, error) {
+	if err := NewTenantService().CheckUserQuota(tenantID, 1); err != nil {
+		return nil, err
+	}
+	suffix, err := utils.GenerateRandomString(10)
+	if err != nil {
+		return nil, err
+	}
+	password, err := utils.GenerateRandomString(32)
+	if err != nil {
+		return nil, err
+	}
+	hashedPassword, err := utils.HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	username := "svc_" + strings.ToLower(suffix)
+	account := models.ServiceAccount{
+		TenantID:    tenantID,
+		Name:        name,
+		Description: description,
+		Scopes:      scopes,
+		Status:      models.ServiceAccountActive,
+		CreatedBy:   createdBy,
+		User: models.User{
+			TenantID: tenantID,
+			Username: username,
+			Password: hashedPassword,
+			Email:    username + "@service.invalid",
+			RealName: name,
+			Role:     models.RoleService,
+			Status:   models.UserStatusActive,
+		},
+	}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&account.User).Error; err != nil {
+			return err
+		}
+		account.UserID = account.User.ID
+		return tx.Omit("User").Create(&account).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+// SetStatus 启用或停用服务账户，停用后其所有密钥都无法使用
+func (s *APIKeyService) SetStatus(account *models.ServiceAccount, status int) error {
+	userStatus := models.UserStatusActive
+	if status != models.ServiceAccountActive {
+		userStatus = models.UserStatusDisabled
+	}
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(account).Update("status", status).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.User{}).Where("id = ?", account.UserID).Update("status", userStatus).Error
+	})
+}
+
+// DeleteAccount 删除服务账户，撤销全部密钥并删除对应用户
+func (s *APIKeyService) DeleteAccount(account *models.ServiceAccount) error {
+	now := time.Now()
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.APIKey{}).
+			Where("service_account_id = ? AND revoked_at IS NULL", account.ID).
+			Update("revoked_at", now).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.User{}).Where("id = ?", account.UserID).
+			Update("status", models.UserStatusDisabled).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&models.User{}, account.UserID).Error; err != nil {
+			return err
+		}
+		return tx.Delete(account).Error
+	})
+}
+
+// CreateKey 为服务账户生成新密钥，返回的完整密钥只有这一次机会获取
+func (s *APIKeyService) CreateKey(account *models.ServiceAccount, name string, ttl time.Duration, createdBy uint) (*models.APIKey, string, error) {
+	key, token, err := s.newKey(account, name, ttl, createdBy)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := s.db.Create(key).Error; err != nil {
+		return nil, "", err
+	}
+	return key, token, nil
+}
+
+// RotateKey 生成替代旧密钥的新密钥，旧密钥在grace时间后失效，grace为0时立即撤销，便于调用方平滑切换
+func (s *APIKeyService) RotateKey(account *models.ServiceAccount, old *models.APIKey, grace, ttl time.Duration, createdBy uint) (*models.APIKey, string, error) {
+	now := time.Now()
+	if !old.Active(now) {
+		return nil, "", ErrAPIKeyExpired
+	}
+	key, token, err := s.newKey(account, old.Name, ttl, createdBy)
+	if err != nil {
+		return nil, "", err
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(key).Error; err != nil {
+			return err
+		}
+		updates := map[string]interface{}{"rotated_to": key.ID}
+		if grace <= 0 {
+			updates["revoked_at"] = now
+		} else if expiresAt := now.Add(grace); expiresAt.Before(old.ExpiresAt) {
+			updates["expires_at"] = expiresAt
+		}
+		return tx.Model(old).Updates(updates).Error
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return key, token, nil
+}
+
+// RevokeKey 立即撤销密钥
+func (s *APIKeyService) RevokeKey(key *models.APIKey) error {
+	if key.RevokedAt != nil {
+		return nil
+	}
+	return s.db.Model(key).Update("revoked_at", time.Now()).Error
+}
+
+func (s *APIKeyService) newKey(account *models.ServiceAccount, name string, ttl time.Duration, createdBy uint) (*models.APIKey, string, error) {
+	prefix, err := utils.GenerateRandomString(apiKeyPrefixLength)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := utils.GenerateRandomString(apiKeySecretLength)
+	if err != nil {
+		return nil, "", err
+	}
+	key := &models.APIKey{
+		TenantID:         account.TenantID,
+		ServiceAccountID: account.ID,
+		Name:             name,
+		Prefix:           prefix,
+		KeyHash:          hashToken(secret),
+		ExpiresAt:        time.Now().Add(ttl),
+		CreatedBy:        createdBy,
+	}
+	return key, APIKeyTokenPrefix + prefix + "_" + secret, nil
+}
+
+// Authenticate 校验请求携带的API密钥，密钥必须属于当前学校、未过期未撤销且服务账户处于启用状态
+func (s *APIKeyService) Authenticate(tenantID uint, token, ip string) (*APIKeyIdentity, error) {
+	rest, ok := strings.CutPrefix(token, APIKeyTokenPrefix)
+	if !ok {
+		return nil, ErrAPIKeyInvalid
+	}
+	prefix, secret, ok := strings.Cut(rest, "_")
+	if !ok || len(prefix) != apiKeyPrefixLength || secret == "" {
+		return nil, ErrAPIKeyInvalid
+	}
+
+	var key models.APIKey
+	if err := s.db.Where("prefix = ?", prefix).First(&key).Error; err != nil {
+		return nil, ErrAPIKeyInvalid
+	}
+	if key.TenantID != tenantID || subtle.ConstantTimeCompare([]byte(key.KeyHash), []byte(hashToken(secret))) != 1 {
+		return nil, ErrAPIKeyInvalid
+	}
+	now := time.Now()
+	if !key.Active(now) {
+		return nil, ErrAPIKeyExpired
+	}
+
+	var account models.ServiceAccount
+	if err := s.db.Preload("User").First(&account, key.ServiceAccountID).Error; err != nil {
+		return nil, ErrAPIKeyInvalid
+	}
+	if account.Status != models.ServiceAccountActive || account.User.Status != models.UserStatusActive {
+		return nil, ErrServiceDisabled
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= apiKeyLastUsedStep || key.LastUsedIP != ip {
+		s.db.Model(&key).UpdateColumns(map[string]interface{}{"last_used_at": now, "last_used_ip": ip})
+	}
+	return &APIKeyIdentity{Key: &key, Account: &account}, nil
+}
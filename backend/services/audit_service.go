@@ -0,0 +1,255 @@
+package services
+
+import (
+	"backend/database"
+	"backend/models"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+	"gorm.io/gorm"
+)
+
+const (
+	auditQueueSize     = 1024
+	auditBatchSize     = 100
+	auditFlushInterval = time.Second
+	AuditExportLimit   = 50000 // 单次导出的最大条数
+)
+
+var (
+	ErrAuditExportFormat = errors.New("不支持的导出格式，仅支持csv和xlsx")
+
+	auditQueue     chan models.AuditLog
+	auditQueueOnce sync.Once
+	auditQueueMu   sync.RWMutex  // 保护关闭队列与Record入队之间的并发
+	auditClosed    bool          // 队列已关闭，之后的日志同步写入
+	auditDrained   chan struct{} // 关闭队列后写入协程写完剩余日志时关闭
+
+	// 对比变更时忽略的字段
+	auditIgnoredFields = map[string]bool{"created_at": true, "updated_at": true, "deleted_at": true}
+	auditExportHeader  = []string{"ID", "时间", "操作人ID", "操作人", "操作", "对象类型", "对象ID", "变更", "详情", "IP", "User-Agent", "请求ID"}
+)
+
+// AuditChange 单个字段的变更前后值
+type AuditChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// AuditService 操作审计服务
+type AuditService struct {
+	db *gorm.DB
+}
+
+// NewAuditService 创建审计服务实例
+func NewAuditService() *AuditService {
+	return &AuditService{db: database.DB}
+}
+
+// StartWriter 启动后台写入协程，之后Record只把日志放入队列，按批写入数据库
+func (s *AuditService) StartWriter() {
+	auditQueueOnce.Do(func() {
+		auditQueue = make(chan models.AuditLog, auditQueueSize)
+		auditDrained = make(chan struct{})
+		go s.runWriter()
+		log.Printf("Audit log writer started, queue size %d", auditQueueSize)
+	})
+}
+
+func (s *AuditService) runWriter() {
+	ticker := time.NewTicker(auditFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]models.AuditLog, 0, auditBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := s.db.CreateInBatches(batch, auditBatchSize).Error; err != nil {
+			log.Printf("写入审计日志失败: %v，丢失%d条", err, len(batch))
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case entry, ok := <-auditQueue:
+			if !ok {
+				flush()
+				close(auditDrained)
+				return
+			}
+			batch = append(batch, entry)
+			if len(batch) >= auditBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// StopWriter 停止接收异步日志，等待写入协程把队列中剩余的日志写入数据库，用于服务退出前。
+// 之后的Record改为同步写入
+func (s *AuditService) StopWriter(ctx context.Context) error {
+	auditQueueMu.Lock()
+	if auditQueue == nil || auditClosed {
+		auditQueueMu.Unlock()
+		return nil
+	}
+	auditClosed = true
+	close(auditQueue)
+	auditQueueMu.Unlock()
+
+	select {
+	case <-auditDrained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Record 写入一条审计日志，detail会序列化为JSON；写入失败只记录日志不影响业务。
+// 后台写入已启动时异步写入，队列已满时退回同步写入，保证日志不丢失
+func (s *AuditService) Record(entry models.AuditLog, detail interface{}) {
+	if detail != nil {
+		if data, err := json.Marshal(detail); err == nil {
+			entry.Detail = string(data)
+		}
+	}
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+
+	auditQueueMu.RLock()
+	if auditQueue != nil && !auditClosed {
+		select {
+		case auditQueue <- entry:
+			auditQueueMu.RUnlock()
+			return
+		default:
+		}
+	}
+	auditQueueMu.RUnlock()
+
+	if err := s.db.Create(&entry).Error; err != nil {
+		log.Printf("写入审计日志失败: %v", err)
+	}
+}
+
+// Diff 对比两个对象序列化后的字段，返回发生变化的字段；json:"-"的字段（如密码）不会出现在结果中
+func (s *AuditService) Diff(before, after interface{}) map[string]AuditChange {
+	beforeFields := auditFields(before)
+	afterFields := auditFields(after)
+
+	changes := map[string]AuditChange{}
+	for key, value := range afterFields {
+		if auditIgnoredFields[key] {
+			continue
+		}
+		old, ok := beforeFields[key]
+		if !ok || !reflect.DeepEqual(old, value) {
+			changes[key] = AuditChange{Before: old, After: value}
+		}
+	}
+	for key, old := range beforeFields {
+		if _, ok := afterFields[key]; !ok && !auditIgnoredFields[key] {
+			changes[key] = AuditChange{Before: old, After: nil}
+		}
+	}
+	return changes
+}
+
+// 把结构体或map转为字段map，嵌套对象整体比较
+func auditFields(v interface{}) map[string]interface{} {
+	fields := map[string]interface{}{}
+	if v == nil {
+		return fields
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fields
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return map[string]interface{}{}
+	}
+	return fields
+}
+
+// Export 按格式导出审计日志，format为csv或xlsx
+func (s *AuditService) Export(w io.Writer, format string, logs []models.AuditLog) error {
+	switch format {
+	case "csv":
+		// 写入BOM，避免Excel打开中文乱码
+		if _, err := w.Write([]byte("\xEF\xBB\xBF")); err != nil {
+			return err
+		}
+		writer := csv.NewWriter(w)
+		if err := writer.Write(auditExportHeader); err != nil {
+			return err
+		}
+		for _, entry := range logs {
+			if err := writer.Write(auditExportRow(entry)); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	case "xlsx":
+		f := excelize.NewFile()
+		defer f.Close()
+		sheet := f.GetSheetName(0)
+		rows := make([][]string, 0, len(logs)+1)
+		rows = append(rows, auditExportHeader)
+		for _, entry := range logs {
+			rows = append(rows, auditExportRow(entry))
+		}
+		for i, row := range rows {
+			cell, err := excelize.CoordinatesToCellName(1, i+1)
+			if err != nil {
+				return err
+			}
+			if err := f.SetSheetRow(sheet, cell, &row); err != nil {
+				return err
+			}
+		}
+		return f.Write(w)
+	default:
+		return ErrAuditExportFormat
+	}
+}
+
+func auditExportRow(entry models.AuditLog) []string {
+	return []string{
+		strconv.FormatUint(uint64(entry.ID), 10),
+		entry.CreatedAt.Format("2006-01-02 15:04:05"),
+		strconv.FormatUint(uint64(entry.ActorID), 10),
+		exportCell(entry.ActorName),
+		exportCell(entry.Action),
+		exportCell(entry.TargetType),
+		strconv.FormatUint(uint64(entry.TargetID), 10),
+		exportCell(entry.Changes),
+		exportCell(entry.Detail),
+		exportCell(entry.IP),
+		exportCell(entry.UserAgent),
+		exportCell(entry.RequestID),
+	}
+}
+
+// 登录失败时记录的用户名等内容来自外部输入，以公式字符开头时加单引号，防止在Excel中被当作公式执行
+func exportCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
@@ -0,0 +1,188 @@
+package services
+
+import (
+	"backend/database"
+	"backend/models"
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrChatNothingToRegenerate = errors.New("当前分支没有可以重新生成的AI回复")
+	ErrChatMessageNotEditable  = errors.New("只能编辑自己发送的消息")
+)
+
+// ChatMessageNode 消息树中的节点，Children按发送顺序排列
+type ChatMessageNode struct {
+	models.ChatMessage
+	Children []*ChatMessageNode `json:"children"`
+}
+
+// ChatService 聊天会话的消息树。每条消息通过ParentID指向上一条消息，
+// 重新生成回复或编辑提问时在同一父消息下新增分支，会话的CurrentMessageID指向当前分支的最后一条消息
+type ChatService struct {
+	db *gorm.DB
+}
+
+// NewChatService 创建聊天服务实例
+func NewChatService() *ChatService {
+	return &ChatService{db: database.DB}
+}
+
+// Messages 会话的全部消息，按发送顺序排列
+func (s *ChatService) Messages(sessionID uint) ([]models.ChatMessage, error) {
+	var messages []models.ChatMessage
+	err := s.db.Preload("Sender").Where("session_id = ?", sessionID).Order("id ASC").Find(&messages).Error
+	return messages, err
+}
+
+// ActivePath 当前分支从第一条消息到最后一条消息的路径
+func (s *ChatService) ActivePath(session *models.ChatSession) ([]models.ChatMessage, error) {
+	messages, err := s.Messages(session.ID)
+	if err != nil || len(messages) == 0 {
+		return messages, err
+	}
+	return activePath(messages, session.CurrentMessageID), nil
+}
+
+func activePath(messages []models.ChatMessage, current *uint) []models.ChatMessage {
+	byID := make(map[uint]models.ChatMessage, len(messages))
+	for _, m := range messages {
+		byID[m.ID] = m
+	}
+	// 没有记录当前分支时取最新的消息
+	leaf, ok := messages[len(messages)-1], true
+	if current != nil {
+		leaf, ok = byID[*current]
+	}
+	if !ok {
+		return nil
+	}
+
+	var path []models.ChatMessage
+	for {
+		path = append(path, leaf)
+		if leaf.ParentID == nil {
+			break
+		}
+		parent, ok := byID[*leaf.ParentID]
+		if !ok {
+			break
+		}
+		leaf = parent
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
+
+// Tree 会话的完整消息树，返回所有根消息
+func (s *ChatService) Tree(sessionID uint) ([]*ChatMessageNode, error) {
+	messages, err := s.Messages(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make(map[uint]*ChatMessageNode, len(messages))
+	for _, m := range messages {
+		nodes[m.ID] = &ChatMessageNode{ChatMessage: m, Children: []*ChatMessageNode{}}
+	}
+	roots := []*ChatMessageNode{}
+	for _, m := range messages {
+		node := nodes[m.ID]
+		if m.ParentID != nil {
+			if parent, ok := nodes[*m.ParentID]; ok {
+				parent.Children = append(parent.Children, node)
+				continue
+			}
+		}
+		roots = append(roots, node)
+	}
+	return roots, nil
+}
+
+// LatestLeaf 从指定消息沿最新的子消息向下，找到该分支的最后一条消息，用于切换分支
+func (s *ChatService) LatestLeaf(sessionID, messageID uint) (uint, error) {
+	leaf := messageID
+	for {
+		var child models.ChatMessage
+		err := s.db.Select("id").Where("session_id = ? AND parent_id = ?", sessionID, leaf).
+			Order("id DESC").First(&child).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return leaf, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		leaf = child.ID
+	}
+}
+
+// Append 在当前分支末尾添加消息，并把当前分支指向新消息
+func (s *ChatService) Append(session *models.ChatSession, message *models.ChatMessage) error {
+	message.SessionID = session.ID
+	if message.ParentID == nil {
+		message.ParentID = session.CurrentMessageID
+	}
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(message).Error; err != nil {
+			return err
+		}
+		return s.setCurrent(tx, session, message.ID)
+	})
+}
+
+// SetCurrent 切换会话当前分支
+func (s *ChatService) SetCurrent(session *models.ChatSession, messageID uint) error {
+	return s.setCurrent(s.db, session, messageID)
+}
+
+func (s *ChatService) setCurrent(tx *gorm.DB, session *models.ChatSession, messageID uint) error {
+	if err := tx.Model(session).Update("current_message_id", messageID).Error; err != nil {
+		return err
+	}
+	session.CurrentMessageID = &messageID
+	return nil
+}
+
+// RegenerateTarget 重新生成当前分支最后一条AI回复时对应的提问。
+// 最后一条是AI回复时在其提问下新增回复分支；最后一条是还没有回复的提问时直接为它生成回复
+func (s *ChatService) RegenerateTarget(session *models.ChatSession) (*models.ChatMessage, error) {
+	path, err := s.ActivePath(session)
+	if err != nil {
+		return nil, err
+	}
+	i := len(path) - 1
+	if i >= 0 && path[i].Role == models.ChatRoleAssistant {
+		i--
+	}
+	if i >= 0 && path[i].Role == models.ChatRoleUser {
+		return &path[i], nil
+	}
+	return nil, ErrChatNothingToRegenerate
+}
+
+// BranchFrom 编辑提问：在原提问的父消息下新增一条提问作为新分支，原分支保留
+func (s *ChatService) BranchFrom(session *models.ChatSession, original *models.ChatMessage, content string) (*models.ChatMessage, error) {
+	if original.SessionID != session.ID || original.Role != models.ChatRoleUser {
+		return nil, ErrChatMessageNotEditable
+	}
+	message := &models.ChatMessage{
+		ParentID:    original.ParentID,
+		Role:        models.ChatRoleUser,
+		Content:     content,
+		MessageType: "text",
+	}
+	if err := s.db.Transaction(func(tx *gorm.DB) error {
+		message.SessionID = session.ID
+		if err := tx.Create(message).Error; err != nil {
+			return err
+		}
+		return s.setCurrent(tx, session, message.ID)
+	}); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
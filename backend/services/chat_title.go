@@ -0,0 +1,201 @@
+package services
+
+import (
+	"backend/models"
+	"log"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	chatTitleMaxRunes   = 20
+	chatTagMaxRunes     = 10
+	chatTagMaxCount     = 3
+	chatKnowledgeLimit  = 5
+	chatTitleTrimTokens = " \t\r\n\"'“”‘’「」《》【】#*"
+)
+
+// 启发式标题去掉的开头客套语
+var chatTitleFillers = []string{"请问一下", "请问", "老师好", "老师", "你好", "您好", "我想问", "帮我"}
+
+// SummarizeAsync 根据会话的第一轮问答在后台生成标题、主题标签并关联知识点，每个会话只执行一次。
+// AI不可用或配额用尽时使用本地规则生成；先占用tagged_at避免并发重复生成，失败时清除，下一轮对话后重试
+func (s *ChatService) SummarizeAsync(tenantID uint, session *models.ChatSession, question, answer string) {
+	if session.TaggedAt != nil {
+		return
+	}
+	now := time.Now()
+	result := s.db.Model(&models.ChatSession{}).Where("id = ? AND tagged_at IS NULL", session.ID).
+		UpdateColumn("tagged_at", now)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return
+	}
+	session.TaggedAt = &now
+
+	go func(session models.ChatSession) {
+		if err := s.summarize(tenantID, &session, question, answer); err != nil {
+			log.Printf("生成会话%d的标题和标签失败: %v", session.ID, err)
+			if err := s.db.Model(&models.ChatSession{}).Where("id = ?", session.ID).
+				UpdateColumn("tagged_at", nil).Error; err != nil {
+				log.Printf("清除会话%d的标签生成标记失败: %v", session.ID, err)
+			}
+		}
+	}(*session)
+}
+
+func (s *ChatService) summarize(tenantID uint, session *models.ChatSession, question, answer string) error {
+	knowledge, err := s.detectKnowledge(session, question+"\n"+answer)
+	if err != nil {
+		return err
+	}
+
+	var title string
+	var tags []string
+	tenantService := NewTenantService()
+	if tenant, err := tenantService.Get(tenantID); err == nil && tenantService.ConsumeAIRequest(tenantID) == nil {
+		title, tags, err = NewAIServiceForTenant(tenant).SummarizeChat(question, answer)
+		if err != nil {
+			log.Printf("AI生成会话%d标题失败，使用本地规则: %v", session.ID, err)
+		}
+	}
+
+	title = cleanChatTitle(title)
+	if title == "" {
+		title = heuristicChatTitle(question)
+	}
+	if tags = normalizeChatTags(tags); len(tags) == 0 {
+		for _, k := range knowledge {
+			tags = append(tags, k.Title)
+		}
+		tags = normalizeChatTags(tags)
+	}
+
+	// 只更新标题和标签，不改变会话在列表中的排序
+	updates := map[string]interface{}{"tags": strings.Join(tags, ",")}
+	if session.AutoTitle {
+		updates["title"] = title
+	}
+	if err := s.db.Model(session).UpdateColumns(updates).Error; err != nil {
+		return err
+	}
+	if len(knowledge) == 0 {
+		return nil
+	}
+	return s.db.Model(session).Association("Knowledge").Replace(knowledge)
+}
+
+// detectKnowledge 按知识点标题和关键词匹配对话内容，关联课程会话所属课程的知识点，
+// 未关联课程的会话匹配学生在读课程的知识点，按命中的词数排序
+func (s *ChatService) detectKnowledge(session *models.ChatSession, text string) ([]models.Knowledge, error) {
+	var courseIDs []uint
+	if session.CourseID != nil {
+		courseIDs = []uint{*session.CourseID}
+	} else {
+		ids, err := NewEnrollmentService().EnrolledCourseIDs(session.UserID)
+		if err != nil {
+			return nil, err
+		}
+		courseIDs = ids
+	}
+	if len(courseIDs) == 0 {
+		return nil, nil
+	}
+
+	var candidates []models.Knowledge
+	if err := s.db.Joins("JOIN chapters ON chapters.id = knowledges.chapter_id").
+		Where("chapters.course_id IN ?", courseIDs).
+		Find(&candidates).Error; err != nil {
+		return nil, err
+	}
+
+	content := strings.ToLower(text)
+	type match struct {
+		knowledge models.Knowledge
+		hits      int
+	}
+	var matches []match
+	for _, k := range candidates {
+		terms := []string{strings.ToLower(k.Title)}
+		for _, kw := range strings.Split(k.Keywords, ",") {
+			if kw = strings.ToLower(strings.TrimSpace(kw)); kw != "" {
+				terms = append(terms, kw)
+			}
+		}
+		hits := 0
+		for _, term := range terms {
+			if term != "" && strings.Contains(content, term) {
+				hits++
+			}
+		}
+		if hits > 0 {
+			matches = append(matches, match{knowledge: k, hits: hits})
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].hits > matches[j].hits })
+	if len(matches) > chatKnowledgeLimit {
+		matches = matches[:chatKnowledgeLimit]
+	}
+	knowledge := make([]models.Knowledge, 0, len(matches))
+	for _, m := range matches {
+		knowledge = append(knowledge, m.knowledge)
+	}
+	return knowledge, nil
+}
+
+// 本地规则生成标题：取提问第一行，去掉客套语和结尾标点后截断
+func heuristicChatTitle(question string) string {
+	line := strings.TrimSpace(question)
+	if i := strings.IndexAny(line, "\r\n"); i >= 0 {
+		line = line[:i]
+	}
+	line = strings.Trim(line, chatTitleTrimTokens)
+	// 客套语可能连续出现，如“老师，请问”
+	for trimmed := ""; trimmed != line; {
+		trimmed = line
+		for _, filler := range chatTitleFillers {
+			line = strings.TrimPrefix(line, filler)
+		}
+		line = strings.TrimLeftFunc(line, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsPunct(r) })
+	}
+	if title := cleanChatTitle(line); title != "" {
+		return title
+	}
+	return models.DefaultChatTitle
+}
+
+// 去掉引号、换行和结尾标点，截断到标题最大长度
+func cleanChatTitle(title string) string {
+	title = strings.Join(strings.Fields(title), " ")
+	title = strings.Trim(title, chatTitleTrimTokens)
+	title = strings.TrimRightFunc(title, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsPunct(r) })
+	runes := []rune(title)
+	if len(runes) > chatTitleMaxRunes {
+		title = string(runes[:chatTitleMaxRunes])
+	}
+	return title
+}
+
+// 标签去掉空白、井号和逗号，截断、去重后最多保留chatTagMaxCount个
+func normalizeChatTags(tags []string) []string {
+	seen := map[string]bool{}
+	result := make([]string, 0, chatTagMaxCount)
+	for _, tag := range tags {
+		tag = strings.NewReplacer(",", "", "，", "", "#", "").Replace(strings.TrimSpace(tag))
+		tag = strings.Trim(tag, chatTitleTrimTokens)
+		if runes := []rune(tag); len(runes) > chatTagMaxRunes {
+			tag = string(runes[:chatTagMaxRunes])
+		}
+		if tag == "" || seen[strings.ToLower(tag)] {
+			continue
+		}
+		seen[strings.ToLower(tag)] = true
+		result = append(result, tag)
+		if len(result) == chatTagMaxCount {
+			break
+		}
+	}
+	return result
+}
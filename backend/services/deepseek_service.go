@@ -79,7 +79,10 @@ type DeepSeekUsage struct {
 }
 
 func NewDeepSeekService() *DeepSeekService {
-	cfg := config.GlobalConfig.AI
+	return newDeepSeekService(config.GlobalConfig.AI)
+}
+
+func newDeepSeekService(cfg config.AIConfig) *DeepSeekService {
 	client := &http.Client{
 		Timeout: time.Duration(cfg.Timeout) * time.Second,
 	}
@@ -0,0 +1,170 @@
+package services
+
+import (
+	"backend/database"
+	"backend/models"
+	"backend/utils"
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// inviteCodeLength 课程邀请码长度
+const inviteCodeLength = 8
+
+// ErrEnrollmentRemoved 被教师移出课程的学生不能自行重新加入或退课
+var ErrEnrollmentRemoved = errors.New("你已被教师移出该课程，请联系教师重新添加")
+
+// EnrollmentService 选课服务
+type EnrollmentService struct {
+	db *gorm.DB
+}
+
+// NewEnrollmentService 创建选课服务实例
+func NewEnrollmentService() *EnrollmentService {
+	return &EnrollmentService{db: database.DB}
+}
+
+// GenerateInviteCode 生成一个未被使用的课程邀请码
+func (s *EnrollmentService) GenerateInviteCode() (string, error) {
+	for i := 0; i < 5; i++ {
+		code, err := utils.GenerateRandomString(inviteCodeLength)
+		if err != nil {
+			return "", err
+		}
+		var count int64
+		if err := s.db.Model(&models.Course{}).Where("invite_code = ?", code).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return code, nil
+		}
+	}
+	return "", errors.New("生成邀请码失败")
+}
+
+// IsEnrolled 学生是否在读该课程
+func (s *EnrollmentService) IsEnrolled(userID, courseID uint) bool {
+	var count int64
+	s.db.Model(&models.Enrollment{}).
+		Where("user_id = ? AND course_id = ? AND status = ?", userID, courseID, models.EnrollmentActive).
+		Count(&count)
+	return count > 0
+}
+
+// CanAccessCourse 本校管理员、课程教师、协作教师和助教以及在读学生可以访问课程
+func (s *EnrollmentService) CanAccessCourse(userID uint, role string, courseID uint) bool {
+	permissionService := NewPermissionService()
+	if !permissionService.SameTenant(userID, courseID) {
+		return false
+	}
+	if models.UserRole(role).IsAdmin() {
+		return true
+	}
+	if permissionService.CourseRole(userID, courseID) != "" {
+		return true
+	}
+	return s.IsEnrolled(userID, courseID)
+}
+
+// EnrolledCourseIDs 学生在读的课程ID
+func (s *EnrollmentService) EnrolledCourseIDs(userID uint) ([]uint, error) {
+	var ids []uint
+	err := s.db.Model(&models.Enrollment{}).
+		Where("user_id = ? AND status = ?", userID, models.EnrollmentActive).
+		Pluck("course_id", &ids).Error
+	return ids, err
+}
+
+// Enroll 将学生加入课程，已退课的记录会重新激活；被教师移出的记录只能由教师重新添加
+func (s *EnrollmentService) Enroll(courseID, userID uint, source models.EnrollmentSource) (*models.Enrollment, bool, error) {
+	var enrollment models.Enrollment
+	err := s.db.Where("course_id = ? AND user_id = ?", courseID, userID).First(&enrollment).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		enrollment = models.Enrollment{
+			CourseID: courseID,
+			UserID:   userID,
+			Status:   models.EnrollmentActive,
+			Source:   source,
+		}
+		if err := s.db.Create(&enrollment).Error; err != nil {
+			return nil, false, err
+		}
+		return &enrollment, true, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	if enrollment.Status == models.EnrollmentActive {
+		return &enrollment, false, nil
+	}
+	if enrollment.Status == models.EnrollmentRemoved && source == models.EnrollmentSourceSelf {
+		return nil, false, ErrEnrollmentRemoved
+	}
+
+	updates := map[string]interface{}{
+		"status": models.EnrollmentActive,
+		"source": source,
+	}
+	if err := s.db.Model(&enrollment).Updates(updates).Error; err != nil {
+		return nil, false, err
+	}
+	return &enrollment, true, nil
+}
+
+// EnrollClass 按班级批量添加课程所属学校的学生，返回新加入的人数
+func (s *EnrollmentService) EnrollClass(courseID uint, class, grade string) (int, error) {
+	query := s.db.Model(&models.UserProfile{}).
+		Joins("JOIN users ON users.id = user_profiles.user_id AND users.deleted_at IS NULL").
+		Joins("JOIN courses ON courses.id = ? AND courses.tenant_id = users.tenant_id", courseID).
+		Where("users.role = ? AND user_profiles.class = ?", models.RoleStudent, class)
+	if grade != "" {
+		query = query.Where("user_profiles.grade = ?", grade)
+	}
+
+	var userIDs []uint
+	if err := query.Pluck("user_profiles.user_id", &userIDs).Error; err != nil {
+		return 0, err
+	}
+
+	added := 0
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		txService := &EnrollmentService{db: tx}
+		for _, userID := range userIDs {
+			_, created, err := txService.Enroll(courseID, userID, models.EnrollmentSourceClass)
+			if err != nil {
+				return err
+			}
+			if created {
+				added++
+			}
+		}
+		return nil
+	})
+	return added, err
+}
+
+// Drop 学生自行退课，被教师移出的记录保持移出状态
+func (s *EnrollmentService) Drop(courseID, userID uint) error {
+	var enrollment models.Enrollment
+	if err := s.db.Where("course_id = ? AND user_id = ?", courseID, userID).First(&enrollment).Error; err != nil {
+		return err
+	}
+	switch enrollment.Status {
+	case models.EnrollmentRemoved:
+		return ErrEnrollmentRemoved
+	case models.EnrollmentDropped:
+		return nil
+	}
+	return s.db.Model(&enrollment).Update("status", models.EnrollmentDropped).Error
+}
+
+// SetStatus 修改选课状态
+func (s *EnrollmentService) SetStatus(courseID, userID uint, status models.EnrollmentStatus) error {
+	var enrollment models.Enrollment
+	if err := s.db.Where("course_id = ? AND user_id = ?", courseID, userID).First(&enrollment).Error; err != nil {
+		return err
+	}
+	return s.db.Model(&enrollment).Update("status", status).Error
+}
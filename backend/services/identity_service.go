@@ -0,0 +1,281 @@
+package services
+
+import (
+	"backend/database"
+	"backend/models"
+	"backend/utils"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrExternalAccountNotFound = errors.New("该账户尚未在平台开通，请联系管理员")
+	ErrExternalAccountNoEmail  = errors.New("身份信息中缺少邮箱，无法创建账户")
+	ErrExternalEmailConflict   = errors.New("该邮箱已被平台其他账户使用，请联系管理员绑定")
+)
+
+var usernameInvalidChars = regexp.MustCompile(`[^A-Za-z0-9_.\-]`)
+
+// ExternalIdentity 外部身份源（OIDC、LDAP）返回的用户信息
+type ExternalIdentity struct {
+	TenantID      uint // 登录请求所属学校，只在该学校内查找和创建账户
+	Provider      string
+	Subject       string // 在身份源中唯一且不变的标识
+	Username      string
+	Email         string
+	EmailVerified bool
+	RealName      string
+	Role          models.UserRole // 自动创建账户时使用的角色
+	Department    string
+	Class         string
+}
+
+// IdentityService 外部身份与平台账户的关联
+type IdentityService struct {
+	db *gorm.DB
+}
+
+// NewIdentityService 创建外部身份服务实例
+func NewIdentityService() *IdentityService {
+	return &IdentityService{db: database.DB}
+}
+
+// Resolve 查找外部身份绑定的账户；未绑定时按邮箱关联已有账户，或自动创建新账户
+func (s *IdentityService) Resolve(ident ExternalIdentity, autoProvision, linkByEmail bool) (*models.User, error) {
+	db := s.db.Scopes(database.TenantScope(ident.TenantID))
+	var identity models.UserIdentity
+	err := db.Preload("User").
+		Where("provider = ? AND subject = ?", ident.Provider, ident.Subject).
+		First(&identity).Error
+	if err == nil {
+		if identity.User.ID == 0 {
+			return nil, ErrExternalAccountNotFound
+		}
+		if ident.Email != "" && identity.Email != ident.Email {
+			s.db.Model(&identity).Update("email", ident.Email)
+		}
+		return &identity.User, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if ident.Email != "" {
+		var existing models.User
+		err := db.Unscoped().Where("email = ?", ident.Email).First(&existing).Error
+		if err == nil {
+			// 账户邮箱是用户自行填写的，无法确认归属，不按邮箱关联
+			if !linkByEmail || !ident.EmailVerified || existing.EmailUnverified || existing.DeletedAt.Valid {
+				return nil, ErrExternalEmailConflict
+			}
+			if err := s.db.Create(&models.UserIdentity{
+				TenantID: ident.TenantID,
+				UserID:   existing.ID,
+				Provider: ident.Provider,
+				Subject:  ident.Subject,
+				Email:    ident.Email,
+			}).Error; err != nil {
+				return nil, err
+			}
+			return &existing, nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+	}
+
+	if !autoProvision {
+		return nil, ErrExternalAccountNotFound
+	}
+	if ident.Email == "" {
+		return nil, ErrExternalAccountNoEmail
+	}
+	return s.provision(ident)
+}
+
+// HasIdentity 账户是否绑定了指定身份源
+func (s *IdentityService) HasIdentity(userID uint, provider string) bool {
+	var count int64
+	s.db.Model(&models.UserIdentity{}).Where("user_id = ? AND provider = ?", userID, provider).Count(&count)
+	return count > 0
+}
+
+// provision 为外部身份创建平台账户，密码随机生成，只能通过该身份源登录
+func (s *IdentityService) provision(ident ExternalIdentity) (*models.User, error) {
+	if err := NewTenantService().CheckUserQuota(ident.TenantID, 1); err != nil {
+		return nil, err
+	}
+	username, err := s.uniqueUsername(ident)
+	if err != nil {
+		return nil, err
+	}
+	password, err := utils.GenerateRandomString(32)
+	if err != nil {
+		return nil, err
+	}
+	hashedPassword, err := utils.HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	role := ident.Role
+	if !role.IsValid() {
+		role = models.RoleStudent
+	}
+	user := models.User{
+		TenantID: ident.TenantID,
+		Username: username,
+		Password: hashedPassword,
+		Email:    ident.Email,
+		RealName: ident.RealName,
+		Role:     role,
+		Status:   models.UserStatusActive,
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		profile := models.UserProfile{
+			UserID:     user.ID,
+			Department: ident.Department,
+			Class:      ident.Class,
+		}
+		if err := tx.Create(&profile).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.UserIdentity{
+			TenantID: ident.TenantID,
+			UserID:   user.ID,
+			Provider: ident.Provider,
+			Subject:  ident.Subject,
+			Email:    ident.Email,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// uniqueUsername 根据身份信息生成学校内未被占用的用户名，冲突时追加数字后缀
+func (s *IdentityService) uniqueUsername(ident ExternalIdentity) (string, error) {
+	base := ident.Username
+	if base == "" {
+		base, _, _ = strings.Cut(ident.Email, "@")
+	}
+	base = usernameInvalidChars.ReplaceAllString(base, "")
+	if len(base) < 3 {
+		base = ident.Provider + "_" + base
+	}
+	if len(base) > 40 {
+		base = base[:40]
+	}
+
+	candidate := base
+	for i := 1; i <= 100; i++ {
+		var count int64
+		if err := s.db.Unscoped().Model(&models.User{}).Scopes(database.TenantScope(ident.TenantID)).
+			Where("username = ?", candidate).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return candidate, nil
+		}
+		candidate = fmt.Sprintf("%s%d", base, i)
+	}
+	return "", errors.New("无法生成可用的用户名")
+}
+
+// ssoTenantAllowed 学校是否配置为使用该身份源。身份源是全局配置，只能用于明确指定的学校，
+// 未指定时只用于默认学校，避免身份源中的用户登录并自动开通其他学校的账户
+func ssoTenantAllowed(tenants []string, tenantID uint) bool {
+	tenantService := NewTenantService()
+	tenant, err := tenantService.Get(tenantID)
+	if err != nil {
+		return false
+	}
+	if len(tenants) == 0 {
+		return strings.EqualFold(tenant.Code, tenantService.DefaultCode())
+	}
+	for _, code := range tenants {
+		if strings.EqualFold(strings.TrimSpace(code), tenant.Code) {
+			return true
+		}
+	}
+	return false
+}
+
+// roleFromGroups 根据外部身份所属的组确定自动创建账户时的角色
+func roleFromGroups(groups, adminGroups, teacherGroups []string) models.UserRole {
+	has := func(targets []string) bool {
+		for _, g := range groups {
+			for _, t := range targets {
+				if strings.EqualFold(strings.TrimSpace(g), t) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	switch {
+	case has(adminGroups):
+		return models.RoleAdmin
+	case has(teacherGroups):
+		return models.RoleTeacher
+	default:
+		return models.RoleStudent
+	}
+}
+
+// SyncProfile 用身份源中的最新信息更新账户姓名、邮箱以及院系班级，空值不覆盖
+func (s *IdentityService) SyncProfile(user *models.User, ident ExternalIdentity) error {
+	updates := map[string]interface{}{}
+	if ident.RealName != "" && ident.RealName != user.RealName {
+		updates["real_name"] = ident.RealName
+	}
+	if ident.Email != "" && ident.Email != user.Email {
+		// 邮箱已被其他账户使用时保持原邮箱
+		var count int64
+		s.db.Unscoped().Model(&models.User{}).Scopes(database.TenantScope(user.TenantID)).
+			Where("email = ? AND id <> ?", ident.Email, user.ID).Count(&count)
+		if count == 0 {
+			updates["email"] = ident.Email
+			updates["email_unverified"] = !ident.EmailVerified
+		}
+	} else if ident.Email != "" && ident.EmailVerified && user.EmailUnverified {
+		// 身份源确认了用户自行填写的邮箱
+		updates["email_unverified"] = false
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if len(updates) > 0 {
+			if err := tx.Model(user).Updates(updates).Error; err != nil {
+				return err
+			}
+		}
+		if ident.Department == "" && ident.Class == "" {
+			return nil
+		}
+
+		var profile models.UserProfile
+		if err := tx.Where(models.UserProfile{UserID: user.ID}).FirstOrCreate(&profile).Error; err != nil {
+			return err
+		}
+		profileUpdates := map[string]interface{}{}
+		if ident.Department != "" && ident.Department != profile.Department {
+			profileUpdates["department"] = ident.Department
+		}
+		if ident.Class != "" && ident.Class != profile.Class {
+			profileUpdates["class"] = ident.Class
+		}
+		if len(profileUpdates) == 0 {
+			return nil
+		}
+		return tx.Model(&profile).Updates(profileUpdates).Error
+	})
+}
@@ -0,0 +1,351 @@
+package services
+
+import (
+	"backend/database"
+	"backend/models"
+	"backend/utils"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"path/filepath"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+	"gorm.io/gorm"
+)
+
+const (
+	// MaxImportRows 单次导入的最大行数
+	MaxImportRows = 1000
+	// importPasswordLength 导入时生成的初始密码长度
+	importPasswordLength = 10
+)
+
+// 导入文件列名，支持英文字段名和中文表头
+var importColumnAliases = map[string]string{
+	"username":   "username",
+	"用户名":        "username",
+	"real_name":  "real_name",
+	"姓名":         "real_name",
+	"email":      "email",
+	"邮箱":         "email",
+	"student_id": "student_id",
+	"学号":         "student_id",
+	"department": "department",
+	"院系":         "department",
+	"major":      "major",
+	"专业":         "major",
+	"grade":      "grade",
+	"年级":         "grade",
+	"class":      "class",
+	"班级":         "class",
+}
+
+var importRequiredColumns = []string{"username", "real_name", "email", "student_id"}
+
+// StudentImportRow 导入文件中的一行学生数据
+type StudentImportRow struct {
+	Line       int    `json:"line"`
+	Username   string `json:"username"`
+	RealName   string `json:"real_name"`
+	Email      string `json:"email"`
+	StudentID  string `json:"student_id"`
+	Department string `json:"department"`
+	Major      string `json:"major"`
+	Grade      string `json:"grade"`
+	Class      string `json:"class"`
+}
+
+// StudentImportResult 单行导入结果，初始密码仅在本次结果中返回
+type StudentImportResult struct {
+	Line     int      `json:"line"`
+	Username string   `json:"username"`
+	Success  bool     `json:"success"`
+	UserID   uint     `json:"user_id,omitempty"`
+	Password string   `json:"password,omitempty"`
+	Enrolled bool     `json:"enrolled"`
+	Errors   []string `json:"errors,omitempty"`
+}
+
+// StudentImportReport 导入结果汇总
+type StudentImportReport struct {
+	Total   int                   `json:"total"`
+	Created int                   `json:"created"`
+	Failed  int                   `json:"failed"`
+	Results []StudentImportResult `json:"results"`
+}
+
+// ImportService 学生批量导入服务
+type ImportService struct {
+	db *gorm.DB
+}
+
+// NewImportService 创建学生批量导入服务实例
+func NewImportService() *ImportService {
+	return &ImportService{db: database.DB}
+}
+
+// ParseStudentFile 按扩展名解析CSV或XLSX文件，第一行为表头
+func (s *ImportService) ParseStudentFile(filename string, r io.Reader) ([]StudentImportRow, error) {
+	var records [][]string
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv":
+		reader := csv.NewReader(r)
+		reader.FieldsPerRecord = -1
+		reader.TrimLeadingSpace = true
+		rows, err := reader.ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("CSV文件格式错误: %v", err)
+		}
+		records = rows
+	case ".xlsx":
+		f, err := excelize.OpenReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("XLSX文件格式错误: %v", err)
+		}
+		defer f.Close()
+		rows, err := f.GetRows(f.GetSheetName(0))
+		if err != nil {
+			return nil, fmt.Errorf("读取工作表失败: %v", err)
+		}
+		records = rows
+	default:
+		return nil, errors.New("仅支持CSV或XLSX文件")
+	}
+
+	if len(records) == 0 {
+		return nil, errors.New("文件内容为空")
+	}
+
+	columns := make(map[string]int)
+	for i, header := range records[0] {
+		// 去掉Excel导出CSV时可能带有的BOM
+		header = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(header, "\ufeff")))
+		if field, ok := importColumnAliases[header]; ok {
+			columns[field] = i
+		}
+	}
+	for _, field := range importRequiredColumns {
+		if _, ok := columns[field]; !ok {
+			return nil, fmt.Errorf("缺少必需列: %s", field)
+		}
+	}
+
+	cell := func(record []string, field string) string {
+		i, ok := columns[field]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	var rows []StudentImportRow
+	for i, record := range records[1:] {
+		if strings.TrimSpace(strings.Join(record, "")) == "" {
+			continue
+		}
+		rows = append(rows, StudentImportRow{
+			Line:       i + 2,
+			Username:   cell(record, "username"),
+			RealName:   cell(record, "real_name"),
+			Email:      cell(record, "email"),
+			StudentID:  cell(record, "student_id"),
+			Department: cell(record, "department"),
+			Major:      cell(record, "major"),
+			Grade:      cell(record, "grade"),
+			Class:      cell(record, "class"),
+		})
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("文件中没有学生数据")
+	}
+	if len(rows) > MaxImportRows {
+		return nil, fmt.Errorf("单次最多导入%d行", MaxImportRows)
+	}
+	return rows, nil
+}
+
+// ImportStudents 校验并逐行在学校内创建学生账户，courseID不为0时同时加入课程。
+// 每行在独立事务中创建用户、档案和选课记录，单行失败不影响其他行。
+func (s *ImportService) ImportStudents(tenantID uint, rows []StudentImportRow, courseID uint) (*StudentImportReport, error) {
+	rowErrors, err := s.validate(tenantID, rows)
+	if err != nil {
+		return nil, err
+	}
+	valid := 0
+	for _, errs := range rowErrors {
+		if len(errs) == 0 {
+			valid++
+		}
+	}
+	if err := NewTenantService().CheckUserQuota(tenantID, valid); err != nil {
+		return nil, err
+	}
+
+	report := &StudentImportReport{Total: len(rows)}
+	for i, row := range rows {
+		result := StudentImportResult{Line: row.Line, Username: row.Username, Errors: rowErrors[i]}
+		if len(result.Errors) == 0 {
+			if err := s.createStudent(tenantID, row, courseID, &result); err != nil {
+				result.Errors = append(result.Errors, "创建账户失败: "+err.Error())
+			}
+		}
+
+		result.Success = len(result.Errors) == 0
+		if result.Success {
+			report.Created++
+		} else {
+			report.Failed++
+			result.UserID, result.Password, result.Enrolled = 0, "", false
+		}
+		report.Results = append(report.Results, result)
+	}
+	return report, nil
+}
+
+// validate 校验每一行的必填项、格式以及与文件内其他行和已有账户的冲突
+func (s *ImportService) validate(tenantID uint, rows []StudentImportRow) ([][]string, error) {
+	var usernames, emails, studentIDs []string
+	for _, row := range rows {
+		usernames = append(usernames, row.Username)
+		emails = append(emails, row.Email)
+		studentIDs = append(studentIDs, row.StudentID)
+	}
+
+	// 软删除的账户仍占用唯一索引，需要一并检查；用户名、邮箱和学号只需在学校内唯一
+	users := s.db.Unscoped().Model(&models.User{}).Scopes(database.TenantScope(tenantID)).Session(&gorm.Session{})
+	var existingUsernames, existingEmails, existingStudentIDs []string
+	if err := users.Where("username IN ?", usernames).
+		Pluck("username", &existingUsernames).Error; err != nil {
+		return nil, err
+	}
+	if err := users.Where("email IN ?", emails).
+		Pluck("email", &existingEmails).Error; err != nil {
+		return nil, err
+	}
+	if err := s.db.Model(&models.UserProfile{}).
+		Joins("JOIN users ON users.id = user_profiles.user_id").
+		Where("users.tenant_id = ? AND user_profiles.student_id IN ?", tenantID, studentIDs).
+		Pluck("user_profiles.student_id", &existingStudentIDs).Error; err != nil {
+		return nil, err
+	}
+	taken := func(values []string) map[string]bool {
+		m := make(map[string]bool, len(values))
+		for _, v := range values {
+			m[strings.ToLower(v)] = true
+		}
+		return m
+	}
+	takenUsernames, takenEmails, takenStudentIDs := taken(existingUsernames), taken(existingEmails), taken(existingStudentIDs)
+
+	seenUsernames := make(map[string]int)
+	seenEmails := make(map[string]int)
+	seenStudentIDs := make(map[string]int)
+	rowErrors := make([][]string, len(rows))
+	for i, row := range rows {
+		var errs []string
+
+		username := strings.ToLower(row.Username)
+		switch {
+		case row.Username == "":
+			errs = append(errs, "用户名不能为空")
+		case len(row.Username) < 3 || len(row.Username) > 50:
+			errs = append(errs, "用户名长度应为3-50个字符")
+		case takenUsernames[username]:
+			errs = append(errs, "用户名已存在")
+		case seenUsernames[username] > 0:
+			errs = append(errs, fmt.Sprintf("用户名与第%d行重复", seenUsernames[username]))
+		}
+
+		if row.RealName == "" {
+			errs = append(errs, "姓名不能为空")
+		} else if len([]rune(row.RealName)) > 50 {
+			errs = append(errs, "姓名过长")
+		}
+
+		email := strings.ToLower(row.Email)
+		if row.Email == "" {
+			errs = append(errs, "邮箱不能为空")
+		} else if addr, err := mail.ParseAddress(row.Email); err != nil || addr.Address != row.Email {
+			errs = append(errs, "邮箱格式错误")
+		} else if takenEmails[email] {
+			errs = append(errs, "邮箱已被使用")
+		} else if seenEmails[email] > 0 {
+			errs = append(errs, fmt.Sprintf("邮箱与第%d行重复", seenEmails[email]))
+		}
+
+		studentID := strings.ToLower(row.StudentID)
+		if row.StudentID == "" {
+			errs = append(errs, "学号不能为空")
+		} else if takenStudentIDs[studentID] {
+			errs = append(errs, "学号已存在")
+		} else if seenStudentIDs[studentID] > 0 {
+			errs = append(errs, fmt.Sprintf("学号与第%d行重复", seenStudentIDs[studentID]))
+		}
+
+		if seenUsernames[username] == 0 {
+			seenUsernames[username] = row.Line
+		}
+		if seenEmails[email] == 0 {
+			seenEmails[email] = row.Line
+		}
+		if seenStudentIDs[studentID] == 0 {
+			seenStudentIDs[studentID] = row.Line
+		}
+		rowErrors[i] = errs
+	}
+	return rowErrors, nil
+}
+
+func (s *ImportService) createStudent(tenantID uint, row StudentImportRow, courseID uint, result *StudentImportResult) error {
+	password, err := utils.GeneratePassword(importPasswordLength, row.Username)
+	if err != nil {
+		return err
+	}
+	hashedPassword, err := utils.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		user := models.User{
+			TenantID: tenantID,
+			Username: row.Username,
+			Password: hashedPassword,
+			Email:    row.Email,
+			RealName: row.RealName,
+			Role:     models.RoleStudent,
+			Status:   models.UserStatusActive,
+			// 初始密码由系统生成并交给教师分发，首次登录后必须修改
+			MustChangePassword: true,
+		}
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+
+		profile := models.UserProfile{
+			UserID:     user.ID,
+			Department: row.Department,
+			Major:      row.Major,
+			Grade:      row.Grade,
+			Class:      row.Class,
+			StudentID:  row.StudentID,
+		}
+		if err := tx.Create(&profile).Error; err != nil {
+			return err
+		}
+
+		if courseID != 0 {
+			if _, _, err := (&EnrollmentService{db: tx}).Enroll(courseID, user.ID, models.EnrollmentSourceTeacher); err != nil {
+				return err
+			}
+			result.Enrolled = true
+		}
+
+		result.UserID = user.ID
+		result.Password = password
+		return nil
+	})
+}
@@ -0,0 +1,234 @@
+package services
+
+import (
+	"backend/config"
+	"backend/database"
+	"backend/models"
+	"backend/utils"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-ldap/ldap/v3"
+	"gorm.io/gorm"
+)
+
+const (
+	ldapProviderName = "ldap"
+	ldapTimeout      = 10 * time.Second
+)
+
+var (
+	ErrInvalidCredentials       = errors.New("用户名或密码错误")
+	ErrDirectoryManagedPassword = errors.New("该账户密码由学校统一身份系统管理，请在统一身份系统中修改")
+)
+
+// LDAPService LDAP/Active Directory登录：先用服务账户查找用户，再以用户DN和密码绑定验证
+type LDAPService struct {
+	db  *gorm.DB
+	cfg config.LDAPConfig
+}
+
+// NewLDAPService 创建LDAP认证服务实例
+func NewLDAPService() *LDAPService {
+	cfg := config.GlobalConfig.LDAP
+	if cfg.UserFilter == "" {
+		cfg.UserFilter = "(uid=%s)"
+	}
+	if cfg.UsernameAttr == "" {
+		cfg.UsernameAttr = "uid"
+	}
+	if cfg.IDAttr == "" {
+		cfg.IDAttr = cfg.UsernameAttr
+	}
+	if cfg.EmailAttr == "" {
+		cfg.EmailAttr = "mail"
+	}
+	if cfg.NameAttr == "" {
+		cfg.NameAttr = "cn"
+	}
+	if cfg.DepartmentAttr == "" {
+		cfg.DepartmentAttr = "department"
+	}
+	if cfg.GroupAttr == "" {
+		cfg.GroupAttr = "memberOf"
+	}
+	return &LDAPService{db: database.DB, cfg: cfg}
+}
+
+// EnabledFor 学校是否使用LDAP登录
+func (s *LDAPService) EnabledFor(tenantID uint) bool {
+	return s.cfg.Enabled && ssoTenantAllowed(s.cfg.Tenants, tenantID)
+}
+
+// AllowsLocalLogin 启用LDAP后该角色是否仍可使用本地密码登录
+func (s *LDAPService) AllowsLocalLogin(role models.UserRole) bool {
+	for _, r := range s.cfg.LocalRoles {
+		if models.UserRole(r) == role {
+			return true
+		}
+	}
+	return false
+}
+
+// ManagesPassword 学校使用LDAP时，绑定了目录账户且不允许本地登录的用户密码由目录服务管理
+func (s *LDAPService) ManagesPassword(user *models.User) bool {
+	return s.EnabledFor(user.TenantID) && !s.AllowsLocalLogin(user.Role) &&
+		NewIdentityService().HasIdentity(user.ID, ldapProviderName)
+}
+
+// Login 通过LDAP验证用户名密码并返回学校内对应的平台账户；
+// 目录验证未通过时，local_roles中的角色可继续使用本地密码登录，保证目录服务故障时管理员仍可登录
+func (s *LDAPService) Login(tenantID uint, username, password string) (*models.User, error) {
+	if !s.EnabledFor(tenantID) {
+		return nil, ErrInvalidCredentials
+	}
+	ident, ldapErr := s.Authenticate(username, password)
+	if ldapErr == nil {
+		ident.TenantID = tenantID
+		identityService := NewIdentityService()
+		user, err := identityService.Resolve(*ident, s.cfg.AutoProvision, s.cfg.LinkByEmail)
+		if err != nil {
+			return nil, err
+		}
+		if err := identityService.SyncProfile(user, *ident); err != nil {
+			return nil, err
+		}
+		return user, nil
+	}
+
+	var user models.User
+	if err := s.db.Scopes(database.TenantScope(tenantID)).Where("username = ?", username).First(&user).Error; err == nil &&
+		s.AllowsLocalLogin(user.Role) && utils.CheckPassword(password, user.Password) {
+		return &user, nil
+	}
+	if errors.Is(ldapErr, ErrInvalidCredentials) {
+		return nil, ErrInvalidCredentials
+	}
+	return nil, ldapErr
+}
+
+// Authenticate 在目录中查找用户并验证密码，成功时返回映射后的身份信息
+func (s *LDAPService) Authenticate(username, password string) (*ExternalIdentity, error) {
+	// 空密码会被服务器当作匿名绑定而返回成功，必须拒绝
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := s.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if s.cfg.BindDN != "" {
+		if err := conn.Bind(s.cfg.BindDN, s.cfg.BindPassword); err != nil {
+			return nil, fmt.Errorf("LDAP服务账户绑定失败: %v", err)
+		}
+	}
+
+	attributes := []string{s.cfg.IDAttr, s.cfg.UsernameAttr, s.cfg.EmailAttr, s.cfg.NameAttr, s.cfg.DepartmentAttr, s.cfg.GroupAttr}
+	if s.cfg.ClassAttr != "" {
+		attributes = append(attributes, s.cfg.ClassAttr)
+	}
+	result, err := conn.Search(ldap.NewSearchRequest(
+		s.cfg.BaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		2, int(ldapTimeout.Seconds()), false,
+		fmt.Sprintf(s.cfg.UserFilter, ldap.EscapeFilter(username)),
+		attributes,
+		nil,
+	))
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, fmt.Errorf("LDAP查找用户失败: %v", err)
+	}
+	// 查不到或匹配到多个条目都视为认证失败
+	if result == nil || len(result.Entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	entry := result.Entries[0]
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("LDAP用户绑定失败: %v", err)
+	}
+
+	return s.identityFromEntry(entry, username), nil
+}
+
+func (s *LDAPService) dial() (*ldap.Conn, error) {
+	u, err := url.Parse(s.cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("LDAP地址配置错误: %v", err)
+	}
+	tlsConfig := &tls.Config{
+		ServerName:         u.Hostname(),
+		InsecureSkipVerify: s.cfg.InsecureSkipVerify,
+	}
+
+	conn, err := ldap.DialURL(s.cfg.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: ldapTimeout}),
+		ldap.DialWithTLSConfig(tlsConfig),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("连接LDAP服务器失败: %v", err)
+	}
+	conn.SetTimeout(ldapTimeout)
+
+	if s.cfg.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("LDAP StartTLS失败: %v", err)
+		}
+	}
+	return conn, nil
+}
+
+// identityFromEntry 按配置的属性名映射用户信息，并根据所属组确定角色
+func (s *LDAPService) identityFromEntry(entry *ldap.Entry, username string) *ExternalIdentity {
+	// objectGUID等二进制属性转为十六进制保存
+	subject := entry.GetAttributeValue(s.cfg.IDAttr)
+	if raw := entry.GetRawAttributeValue(s.cfg.IDAttr); len(raw) > 0 && !utf8.Valid(raw) {
+		subject = hex.EncodeToString(raw)
+	}
+	if subject == "" {
+		subject = strings.ToLower(entry.DN)
+	}
+	if name := entry.GetAttributeValue(s.cfg.UsernameAttr); name != "" {
+		username = name
+	}
+
+	var class string
+	if s.cfg.ClassAttr != "" {
+		class = entry.GetAttributeValue(s.cfg.ClassAttr)
+	}
+
+	// 组属性一般是组的DN，同时支持按组DN或组名（第一个RDN的值）匹配
+	var groups []string
+	for _, group := range entry.GetAttributeValues(s.cfg.GroupAttr) {
+		groups = append(groups, group)
+		if dn, err := ldap.ParseDN(group); err == nil && len(dn.RDNs) > 0 && len(dn.RDNs[0].Attributes) > 0 {
+			groups = append(groups, dn.RDNs[0].Attributes[0].Value)
+		}
+	}
+
+	return &ExternalIdentity{
+		Provider:      ldapProviderName,
+		Subject:       subject,
+		Username:      username,
+		Email:         strings.TrimSpace(entry.GetAttributeValue(s.cfg.EmailAttr)),
+		EmailVerified: true, // 目录中的邮箱由学校统一维护
+		RealName:      strings.TrimSpace(entry.GetAttributeValue(s.cfg.NameAttr)),
+		Role:          roleFromGroups(groups, s.cfg.AdminGroups, s.cfg.TeacherGroups),
+		Department:    strings.TrimSpace(entry.GetAttributeValue(s.cfg.DepartmentAttr)),
+		Class:         strings.TrimSpace(class),
+	}
+}
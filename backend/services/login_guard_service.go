@@ -0,0 +1,188 @@
+package services
+
+import (
+	"backend/config"
+	"backend/database"
+	"backend/models"
+	"backend/redis"
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	goredis "github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultLoginWindow          = 15 // 分钟
+	defaultLoginMaxAttempts     = 5
+	defaultLoginMaxIPAttempts   = 20
+	defaultLoginLockoutDuration = 15   // 分钟
+	defaultLoginDelayBase       = 500  // 毫秒
+	defaultLoginDelayMax        = 5000 // 毫秒
+)
+
+// LoginGuardService 登录防暴力破解：按用户名和IP统计滑动窗口内的失败次数，
+// 失败后逐次增加响应延迟，超过阈值后临时锁定
+type LoginGuardService struct {
+	db  *gorm.DB
+	cfg config.LoginConfig
+}
+
+// NewLoginGuardService 创建登录防护服务实例
+func NewLoginGuardService() *LoginGuardService {
+	cfg := config.GlobalConfig.Login
+	if cfg.Window <= 0 {
+		cfg.Window = defaultLoginWindow
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = defaultLoginMaxAttempts
+	}
+	if cfg.MaxIPAttempts <= 0 {
+		cfg.MaxIPAttempts = defaultLoginMaxIPAttempts
+	}
+	if cfg.LockoutDuration <= 0 {
+		cfg.LockoutDuration = defaultLoginLockoutDuration
+	}
+	if cfg.DelayBase <= 0 {
+		cfg.DelayBase = defaultLoginDelayBase
+	}
+	if cfg.DelayMax <= 0 {
+		cfg.DelayMax = defaultLoginDelayMax
+	}
+	return &LoginGuardService{db: database.DB, cfg: cfg}
+}
+
+func loginFailKey(kind, value string) string {
+	return fmt.Sprintf("login:fail:%s:%s", kind, value)
+}
+
+func loginLockKey(kind, value string) string {
+	return fmt.Sprintf("login:lock:%s:%s", kind, value)
+}
+
+// 不同学校可以有同名用户，按学校区分用户名的失败记录。
+// 数据库比较用户名时不区分大小写并忽略结尾空格，计数前统一转为小写并去掉首尾空白，避免换一种写法绕过锁定
+func loginUser(tenantID uint, username string) string {
+	return fmt.Sprintf("%d:%s", tenantID, strings.ToLower(strings.TrimSpace(username)))
+}
+
+// LockedFor 返回用户名或IP剩余的锁定时长，未锁定时为0
+func (s *LoginGuardService) LockedFor(tenantID uint, username, ip string) time.Duration {
+	ctx := context.Background()
+	var remaining time.Duration
+	for _, key := range []string{loginLockKey("user", loginUser(tenantID, username)), loginLockKey("ip", ip)} {
+		ttl, err := redis.RDB.TTL(ctx, key).Result()
+		if err != nil {
+			log.Printf("检查登录锁定状态失败: %v", err)
+			continue
+		}
+		if ttl > remaining {
+			remaining = ttl
+		}
+	}
+	return remaining
+}
+
+// Delay 按该用户名窗口内的失败次数计算本次登录前的等待时间
+func (s *LoginGuardService) Delay(tenantID uint, username string) time.Duration {
+	failures, err := s.failures(loginFailKey("user", loginUser(tenantID, username)))
+	if err != nil || failures == 0 {
+		return 0
+	}
+
+	delay := s.cfg.DelayBase
+	for i := int64(1); i < failures && delay < s.cfg.DelayMax; i++ {
+		delay *= 2
+	}
+	if delay > s.cfg.DelayMax {
+		delay = s.cfg.DelayMax
+	}
+	return time.Duration(delay) * time.Millisecond
+}
+
+// RecordFailure 记录一次登录失败，达到阈值时锁定并返回true
+func (s *LoginGuardService) RecordFailure(tenantID uint, username, ip string) bool {
+	userFailures, err := s.addFailure(loginFailKey("user", loginUser(tenantID, username)))
+	if err != nil {
+		log.Printf("记录登录失败次数失败: %v", err)
+		return false
+	}
+	ipFailures, err := s.addFailure(loginFailKey("ip", ip))
+	if err != nil {
+		log.Printf("记录登录失败次数失败: %v", err)
+		return false
+	}
+
+	ctx := context.Background()
+	lockout := time.Duration(s.cfg.LockoutDuration) * time.Minute
+	locked := false
+	if ipFailures >= int64(s.cfg.MaxIPAttempts) {
+		redis.SetCache(ctx, loginLockKey("ip", ip), time.Now().Unix(), lockout)
+		locked = true
+	}
+	if userFailures >= int64(s.cfg.MaxAttempts) {
+		// 只在首次锁定时通知，避免锁定期间重复发送
+		if ok, _ := redis.RDB.SetNX(ctx, loginLockKey("user", loginUser(tenantID, username)), time.Now().Unix(), lockout).Result(); ok {
+			s.notifyLockout(tenantID, username, ip)
+		}
+		locked = true
+	}
+	return locked
+}
+
+// Reset 登录成功后清除该用户名的失败记录
+func (s *LoginGuardService) Reset(tenantID uint, username string) {
+	redis.DeleteCache(context.Background(), loginFailKey("user", loginUser(tenantID, username)))
+}
+
+// UnlockUser 解除用户名的锁定并清除失败记录
+func (s *LoginGuardService) UnlockUser(tenantID uint, username string) error {
+	key := loginUser(tenantID, username)
+	return redis.RDB.Del(context.Background(), loginLockKey("user", key), loginFailKey("user", key)).Err()
+}
+
+// UnlockIP 解除IP的锁定并清除失败记录
+func (s *LoginGuardService) UnlockIP(ip string) error {
+	return redis.RDB.Del(context.Background(), loginLockKey("ip", ip), loginFailKey("ip", ip)).Err()
+}
+
+// addFailure 在有序集合中记录失败时间，移除窗口外的记录后返回窗口内的失败次数
+func (s *LoginGuardService) addFailure(key string) (int64, error) {
+	ctx := context.Background()
+	now := time.Now()
+	window := time.Duration(s.cfg.Window) * time.Minute
+
+	pipe := redis.RDB.TxPipeline()
+	pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(now.Add(-window).UnixNano(), 10))
+	pipe.ZAdd(ctx, key, &goredis.Z{Score: float64(now.UnixNano()), Member: now.UnixNano()})
+	count := pipe.ZCard(ctx, key)
+	pipe.Expire(ctx, key, window)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return count.Val(), nil
+}
+
+// failures 返回窗口内的失败次数
+func (s *LoginGuardService) failures(key string) (int64, error) {
+	ctx := context.Background()
+	since := time.Now().Add(-time.Duration(s.cfg.Window) * time.Minute).UnixNano()
+	return redis.RDB.ZCount(ctx, key, strconv.FormatInt(since, 10), "+inf").Result()
+}
+
+func (s *LoginGuardService) notifyLockout(tenantID uint, username, ip string) {
+	var user models.User
+	if err := s.db.Scopes(database.TenantScope(tenantID)).Where("username = ?", username).First(&user).Error; err != nil {
+		return
+	}
+
+	content := fmt.Sprintf("您的账户在%d分钟内连续%d次登录失败（最近一次来自IP %s），已被临时锁定%d分钟。如非本人操作，请及时修改密码。",
+		s.cfg.Window, s.cfg.MaxAttempts, ip, s.cfg.LockoutDuration)
+	if err := NewNotificationService().Notify(user.ID, "login_lockout", "账户登录已被临时锁定", content, "/profile"); err != nil {
+		log.Printf("发送登录锁定通知失败: %v", err)
+	}
+}
@@ -0,0 +1,142 @@
+package services
+
+import (
+	"backend/config"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	texttemplate "text/template"
+	"time"
+)
+
+const smtpDialTimeout = 10 * time.Second
+
+var ErrMailNotConfigured = errors.New("未配置SMTP邮件服务")
+
+// MailService SMTP邮件发送服务
+type MailService struct {
+	cfg config.SMTPConfig
+}
+
+// NewMailService 创建邮件服务实例
+func NewMailService() *MailService {
+	return &MailService{cfg: config.GlobalConfig.SMTP}
+}
+
+// SendTemplate 使用同名的文本模板和HTML模板渲染邮件后发送
+func (s *MailService) SendTemplate(to, subject string, text *texttemplate.Template, html *htmltemplate.Template, data interface{}) error {
+	var textBody, htmlBody bytes.Buffer
+	if err := text.Execute(&textBody, data); err != nil {
+		return err
+	}
+	if err := html.Execute(&htmlBody, data); err != nil {
+		return err
+	}
+	return s.Send(to, subject, textBody.String(), htmlBody.String())
+}
+
+// Send 发送包含纯文本和HTML两种格式的邮件
+func (s *MailService) Send(to, subject, textBody, htmlBody string) error {
+	if s.cfg.Host == "" || s.cfg.From == "" {
+		return ErrMailNotConfigured
+	}
+
+	msg, err := s.buildMessage(to, subject, textBody, htmlBody)
+	if err != nil {
+		return err
+	}
+
+	addr := net.JoinHostPort(s.cfg.Host, strconv.Itoa(s.cfg.Port))
+	var auth smtp.Auth
+	if s.cfg.Username != "" {
+		auth = smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, s.cfg.Host)
+	}
+
+	if !s.cfg.TLS {
+		// 服务器支持时net/smtp会自动使用STARTTLS
+		return smtp.SendMail(addr, auth, s.cfg.From, []string{to}, msg)
+	}
+
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: smtpDialTimeout}, "tcp", addr, &tls.Config{ServerName: s.cfg.Host})
+	if err != nil {
+		return err
+	}
+	client, err := smtp.NewClient(conn, s.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if auth != nil {
+		if err := client.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(s.cfg.From); err != nil {
+		return err
+	}
+	if err := client.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// buildMessage 生成multipart/alternative格式的邮件内容
+func (s *MailService) buildMessage(to, subject, textBody, htmlBody string) ([]byte, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=UTF-8", textBody},
+		{"text/html; charset=UTF-8", htmlBody},
+	} {
+		w, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	from := mail.Address{Name: s.cfg.FromName, Address: s.cfg.From}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", from.String())
+	fmt.Fprintf(&msg, "To: %s\r\n", (&mail.Address{Address: to}).String())
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.BEncoding.Encode("UTF-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", writer.Boundary())
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
@@ -0,0 +1,49 @@
+package services
+
+import (
+	htmltemplate "html/template"
+	texttemplate "text/template"
+)
+
+// PasswordResetMailData 重置密码邮件模板数据
+type PasswordResetMailData struct {
+	Name    string
+	Link    string
+	Minutes int
+}
+
+const passwordResetSubject = "重置密码 / Reset your password"
+
+var passwordResetText = texttemplate.Must(texttemplate.New("password_reset").Parse(`{{.Name}}，您好：
+
+我们收到了重置您账户密码的请求。请在{{.Minutes}}分钟内打开以下链接设置新密码：
+{{.Link}}
+
+该链接只能使用一次。如果这不是您本人的操作，请忽略本邮件，您的密码不会改变。
+
+----
+
+Hello {{.Name}},
+
+We received a request to reset the password for your account. Open the link below within {{.Minutes}} minutes to choose a new password:
+{{.Link}}
+
+The link can only be used once. If you did not request this, you can ignore this email and your password will stay the same.
+`))
+
+var passwordResetHTML = htmltemplate.Must(htmltemplate.New("password_reset").Parse(`<!DOCTYPE html>
+<html>
+<body style="font-family: Arial, 'Microsoft YaHei', sans-serif; color: #333; line-height: 1.6;">
+  <p>{{.Name}}，您好：</p>
+  <p>我们收到了重置您账户密码的请求。请在{{.Minutes}}分钟内点击下方按钮设置新密码：</p>
+  <p><a href="{{.Link}}" style="display: inline-block; padding: 10px 20px; background: #409eff; color: #fff; text-decoration: none; border-radius: 4px;">重置密码</a></p>
+  <p>该链接只能使用一次。如果这不是您本人的操作，请忽略本邮件，您的密码不会改变。</p>
+  <hr style="border: none; border-top: 1px solid #eee;">
+  <p>Hello {{.Name}},</p>
+  <p>We received a request to reset the password for your account. Click the button below within {{.Minutes}} minutes to choose a new password:</p>
+  <p><a href="{{.Link}}" style="display: inline-block; padding: 10px 20px; background: #409eff; color: #fff; text-decoration: none; border-radius: 4px;">Reset password</a></p>
+  <p>The link can only be used once. If you did not request this, you can ignore this email and your password will stay the same.</p>
+  <p style="color: #999; font-size: 12px;">{{.Link}}</p>
+</body>
+</html>
+`))
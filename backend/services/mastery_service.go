@@ -0,0 +1,131 @@
+package services
+
+import (
+	"backend/database"
+	"backend/models"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 贝叶斯知识追踪（BKT）参数
+const (
+	bktInitial = 0.3  // 初始掌握概率 P(L0)
+	bktLearn   = 0.1  // 每次练习后习得概率 P(T)
+	bktSlip    = 0.1  // 已掌握但答错的概率 P(S)
+	bktGuess   = 0.25 // 未掌握但猜对的概率 P(G)
+
+	// MasteryThreshold 掌握度达到该值视为已掌握
+	MasteryThreshold = 0.85
+	// WeakMasteryThreshold 掌握度低于该值视为薄弱知识点
+	WeakMasteryThreshold = 0.5
+)
+
+// MasteryService 知识点掌握度服务
+type MasteryService struct {
+	db *gorm.DB
+}
+
+// NewMasteryService 创建掌握度服务实例
+func NewMasteryService() *MasteryService {
+	return &MasteryService{db: database.DB}
+}
+
+// UpdateMastery 根据一次作答结果更新掌握概率，难度越高猜对概率越低、失误概率越高
+func UpdateMastery(prior float64, isCorrect bool, difficulty int) float64 {
+	if difficulty < 1 || difficulty > 5 {
+		difficulty = 3
+	}
+	slip := bktSlip + 0.02*float64(difficulty-3)
+	guess := bktGuess - 0.04*float64(difficulty-3)
+
+	var posterior float64
+	if isCorrect {
+		posterior = prior * (1 - slip) / (prior*(1-slip) + (1-prior)*guess)
+	} else {
+		posterior = prior * slip / (prior*slip + (1-prior)*(1-guess))
+	}
+
+	return posterior + (1-posterior)*bktLearn
+}
+
+// RecordAnswer 记录一次已评分的作答，更新对应知识点的掌握度；题目未关联知识点时返回nil
+func (s *MasteryService) RecordAnswer(userID uint, question *models.Question, isCorrect bool) (*models.KnowledgeMastery, error) {
+	if question.KnowledgeID == nil {
+		return nil, nil
+	}
+
+	var knowledge models.Knowledge
+	if err := s.db.Preload("Chapter").First(&knowledge, *question.KnowledgeID).Error; err != nil {
+		return nil, err
+	}
+
+	difficulty := question.Difficulty
+	if difficulty == 0 {
+		difficulty = knowledge.Difficulty
+	}
+
+	var mastery models.KnowledgeMastery
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		// 首次作答时创建初始记录，已存在时不做处理，并发的首次作答也只会有一条记录
+		initial := models.KnowledgeMastery{
+			UserID:         userID,
+			KnowledgeID:    knowledge.ID,
+			CourseID:       knowledge.Chapter.CourseID,
+			ChapterID:      knowledge.ChapterID,
+			Mastery:        bktInitial,
+			LastAnsweredAt: time.Now(),
+		}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&initial).Error; err != nil {
+			return err
+		}
+
+		// 锁定记录后再计算，避免并发作答互相覆盖
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ? AND knowledge_id = ?", userID, knowledge.ID).
+			First(&mastery).Error; err != nil {
+			return err
+		}
+		mastery.Mastery = UpdateMastery(mastery.Mastery, isCorrect, difficulty)
+		mastery.Attempts++
+		if isCorrect {
+			mastery.CorrectCount++
+		}
+		mastery.LastAnsweredAt = time.Now()
+		return tx.Model(&mastery).Updates(map[string]interface{}{
+			"mastery":          mastery.Mastery,
+			"attempts":         mastery.Attempts,
+			"correct_count":    mastery.CorrectCount,
+			"last_answered_at": mastery.LastAnsweredAt,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	mastery.Knowledge = knowledge
+	return &mastery, nil
+}
+
+// GetUserMastery 获取学生的知识点掌握度，courseID为0时返回全部课程
+func (s *MasteryService) GetUserMastery(userID, courseID uint) ([]models.KnowledgeMastery, error) {
+	var masteries []models.KnowledgeMastery
+	query := s.db.Preload("Knowledge").Where("user_id = ?", userID)
+	if courseID != 0 {
+		query = query.Where("course_id = ?", courseID)
+	}
+
+	err := query.Order("mastery ASC").Find(&masteries).Error
+	return masteries, err
+}
+
+// GetCourseMastery 获取课程内所有学生的知识点掌握度
+func (s *MasteryService) GetCourseMastery(courseID uint) ([]models.KnowledgeMastery, error) {
+	var masteries []models.KnowledgeMastery
+	err := s.db.Preload("Knowledge").
+		Where("course_id = ?", courseID).
+		Order("user_id ASC, mastery ASC").
+		Find(&masteries).Error
+	return masteries, err
+}
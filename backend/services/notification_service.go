@@ -0,0 +1,30 @@
+package services
+
+import (
+	"backend/database"
+	"backend/models"
+
+	"gorm.io/gorm"
+)
+
+// NotificationService 站内通知服务
+type NotificationService struct {
+	db *gorm.DB
+}
+
+// NewNotificationService 创建通知服务实例
+func NewNotificationService() *NotificationService {
+	return &NotificationService{db: database.DB}
+}
+
+// Notify 向用户发送一条站内通知
+func (s *NotificationService) Notify(userID uint, notifyType, title, content, link string) error {
+	notification := models.Notification{
+		UserID:  userID,
+		Type:    notifyType,
+		Title:   title,
+		Content: content,
+		Link:    link,
+	}
+	return s.db.Create(&notification).Error
+}
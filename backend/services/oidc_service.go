@@ -0,0 +1,310 @@
+package services
+
+import (
+	"backend/config"
+	"backend/models"
+	"backend/redis"
+	"backend/utils"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	goredis "github.com/go-redis/redis/v8"
+	"golang.org/x/oauth2"
+)
+
+const (
+	oidcProviderName = "oidc"
+	oidcStateTTL     = 10 * time.Minute
+	oidcLoginCodeTTL = time.Minute
+	oidcTimeout      = 10 * time.Second
+)
+
+var (
+	ErrOIDCDisabled     = errors.New("未启用统一身份认证登录")
+	ErrOIDCInvalidState = errors.New("登录请求已过期，请重新登录")
+	ErrOIDCInvalidCode  = errors.New("登录凭证无效或已过期")
+)
+
+// 身份提供方的发现文档只需获取一次
+var (
+	oidcProvider   *oidc.Provider
+	oidcProviderMu sync.Mutex
+)
+
+// oidcState 发起登录时保存的状态，回调时校验
+type oidcState struct {
+	TenantID uint   `json:"tenant_id"`
+	Verifier string `json:"verifier"`
+	Nonce    string `json:"nonce"`
+	Redirect string `json:"redirect"`
+}
+
+// OIDCService 统一身份认证（OIDC授权码+PKCE）登录
+type OIDCService struct {
+	cfg config.OIDCConfig
+}
+
+// NewOIDCService 创建统一身份认证服务实例
+func NewOIDCService() *OIDCService {
+	cfg := config.GlobalConfig.OIDC
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{oidc.ScopeOpenID, "profile", "email"}
+	}
+	if cfg.UsernameClaim == "" {
+		cfg.UsernameClaim = "preferred_username"
+	}
+	if cfg.NameClaim == "" {
+		cfg.NameClaim = "name"
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = "groups"
+	}
+	return &OIDCService{cfg: cfg}
+}
+
+// EnabledFor 学校是否可以使用统一身份认证登录
+func (s *OIDCService) EnabledFor(tenantID uint) bool {
+	return s.cfg.Enabled && ssoTenantAllowed(s.cfg.Tenants, tenantID)
+}
+
+// FrontendURL 登录完成后跳转的前端页面
+func (s *OIDCService) FrontendURL() string {
+	return s.cfg.FrontendURL
+}
+
+func (s *OIDCService) provider(ctx context.Context) (*oidc.Provider, error) {
+	oidcProviderMu.Lock()
+	defer oidcProviderMu.Unlock()
+	if oidcProvider != nil {
+		return oidcProvider, nil
+	}
+	provider, err := oidc.NewProvider(ctx, s.cfg.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("获取身份提供方配置失败: %v", err)
+	}
+	oidcProvider = provider
+	return provider, nil
+}
+
+func (s *OIDCService) oauthConfig(provider *oidc.Provider) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     s.cfg.ClientID,
+		ClientSecret: s.cfg.ClientSecret,
+		RedirectURL:  s.cfg.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       s.cfg.Scopes,
+	}
+}
+
+// AuthURL 生成跳转到身份提供方的授权地址，tenantID为发起登录的学校，redirect为登录后前端要返回的页面。
+// 返回的state需要写入发起登录的浏览器，回调时校验
+func (s *OIDCService) AuthURL(tenantID uint, redirect string) (string, string, error) {
+	if !s.EnabledFor(tenantID) {
+		return "", "", ErrOIDCDisabled
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), oidcTimeout)
+	defer cancel()
+	provider, err := s.provider(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	stateToken, err := utils.GenerateRandomString(32)
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := utils.GenerateRandomString(32)
+	if err != nil {
+		return "", "", err
+	}
+	state := oidcState{
+		TenantID: tenantID,
+		Verifier: oauth2.GenerateVerifier(),
+		Nonce:    nonce,
+		Redirect: redirect,
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return "", "", err
+	}
+	if err := redis.SetCache(ctx, "oidc:state:"+stateToken, data, oidcStateTTL); err != nil {
+		return "", "", err
+	}
+
+	return s.oauthConfig(provider).AuthCodeURL(stateToken,
+		oauth2.S256ChallengeOption(state.Verifier),
+		oidc.Nonce(nonce),
+	), stateToken, nil
+}
+
+// OIDCStateTTL 登录请求state的有效期(秒)
+func OIDCStateTTL() int {
+	return int(oidcStateTTL.Seconds())
+}
+
+// HandleCallback 用授权码换取令牌并校验ID Token，返回对应的平台账户和登录后要返回的页面。
+// browserState为发起登录时写入浏览器的state，必须与回调参数一致，防止登录CSRF
+func (s *OIDCService) HandleCallback(code, stateToken, browserState string) (*models.User, string, error) {
+	if !s.cfg.Enabled {
+		return nil, "", ErrOIDCDisabled
+	}
+	if stateToken == "" || subtle.ConstantTimeCompare([]byte(stateToken), []byte(browserState)) != 1 {
+		return nil, "", ErrOIDCInvalidState
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), oidcTimeout)
+	defer cancel()
+
+	// state只能使用一次
+	key := "oidc:state:" + stateToken
+	raw, err := redis.GetCache(ctx, key)
+	if errors.Is(err, goredis.Nil) {
+		return nil, "", ErrOIDCInvalidState
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	redis.DeleteCache(ctx, key)
+	var state oidcState
+	if err := json.Unmarshal([]byte(raw), &state); err != nil {
+		return nil, "", ErrOIDCInvalidState
+	}
+	if !ssoTenantAllowed(s.cfg.Tenants, state.TenantID) {
+		return nil, state.Redirect, ErrOIDCDisabled
+	}
+
+	ident, err := s.exchange(ctx, &state, code)
+	if err != nil {
+		return nil, state.Redirect, err
+	}
+	ident.TenantID = state.TenantID
+	user, err := NewIdentityService().Resolve(ident, s.cfg.AutoProvision, s.cfg.LinkByEmail)
+	return user, state.Redirect, err
+}
+
+// exchange 用授权码换取令牌，校验ID Token的签名、受众和nonce，返回身份提供方声明的用户信息
+func (s *OIDCService) exchange(ctx context.Context, state *oidcState, code string) (ExternalIdentity, error) {
+	provider, err := s.provider(ctx)
+	if err != nil {
+		return ExternalIdentity{}, err
+	}
+	oauthConfig := s.oauthConfig(provider)
+	token, err := oauthConfig.Exchange(ctx, code, oauth2.VerifierOption(state.Verifier))
+	if err != nil {
+		return ExternalIdentity{}, fmt.Errorf("授权码换取令牌失败: %v", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return ExternalIdentity{}, errors.New("身份提供方未返回id_token")
+	}
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: s.cfg.ClientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		return ExternalIdentity{}, fmt.Errorf("id_token校验失败: %v", err)
+	}
+	if idToken.Nonce != state.Nonce {
+		return ExternalIdentity{}, errors.New("id_token的nonce不匹配")
+	}
+
+	claims := map[string]interface{}{}
+	if err := idToken.Claims(&claims); err != nil {
+		return ExternalIdentity{}, err
+	}
+	// ID Token中缺少的声明从UserInfo接口补充
+	if userInfo, err := provider.UserInfo(ctx, oauth2.StaticTokenSource(token)); err == nil {
+		extra := map[string]interface{}{}
+		if userInfo.Claims(&extra) == nil {
+			for k, v := range extra {
+				if _, exists := claims[k]; !exists {
+					claims[k] = v
+				}
+			}
+		}
+	}
+
+	return s.identityFromClaims(idToken.Subject, claims), nil
+}
+
+// identityFromClaims 按配置的声明名称映射用户信息，并根据所属组确定角色
+func (s *OIDCService) identityFromClaims(subject string, claims map[string]interface{}) ExternalIdentity {
+	str := func(name string) string {
+		v, _ := claims[name].(string)
+		return strings.TrimSpace(v)
+	}
+
+	// 未声明email_verified时视为未验证，不能按邮箱关联已有账户
+	emailVerified := false
+	if v, ok := claims["email_verified"]; ok {
+		switch b := v.(type) {
+		case bool:
+			emailVerified = b
+		case string:
+			emailVerified, _ = strconv.ParseBool(b)
+		}
+	}
+
+	return ExternalIdentity{
+		Provider:      oidcProviderName,
+		Subject:       subject,
+		Username:      str(s.cfg.UsernameClaim),
+		Email:         str("email"),
+		EmailVerified: emailVerified,
+		RealName:      str(s.cfg.NameClaim),
+		Role:          roleFromGroups(claimStrings(claims[s.cfg.GroupsClaim]), s.cfg.AdminGroups, s.cfg.TeacherGroups),
+	}
+}
+
+// claimStrings 兼容组声明为字符串数组或以逗号分隔的字符串
+func claimStrings(v interface{}) []string {
+	switch value := v.(type) {
+	case []interface{}:
+		var result []string
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	case string:
+		return strings.Split(value, ",")
+	}
+	return nil
+}
+
+// IssueLoginCode 生成一次性登录code，前端用它换取平台令牌，避免令牌出现在跳转地址中
+func (s *OIDCService) IssueLoginCode(userID uint) (string, error) {
+	code, err := utils.GenerateRandomString(32)
+	if err != nil {
+		return "", err
+	}
+	if err := redis.SetCache(context.Background(), "oidc:login:"+code, userID, oidcLoginCodeTTL); err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// ExchangeLoginCode 校验并作废一次性登录code，返回对应的用户ID
+func (s *OIDCService) ExchangeLoginCode(code string) (uint, error) {
+	ctx := context.Background()
+	key := "oidc:login:" + code
+	pipe := redis.RDB.TxPipeline()
+	get := pipe.Get(ctx, key)
+	pipe.Del(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		if errors.Is(err, goredis.Nil) {
+			return 0, ErrOIDCInvalidCode
+		}
+		return 0, err
+	}
+	userID, err := strconv.ParseUint(get.Val(), 10, 64)
+	if err != nil {
+		return 0, ErrOIDCInvalidCode
+	}
+	return uint(userID), nil
+}
@@ -0,0 +1,217 @@
+package services
+
+import (
+	"backend/config"
+	"backend/models"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	mockOIDCClientID = "course-platform"
+	mockOIDCCode     = "auth-code"
+)
+
+// mockOIDCServer 本地模拟的身份提供方，提供发现文档、JWKS、令牌和UserInfo接口
+type mockOIDCServer struct {
+	*httptest.Server
+	key      *rsa.PrivateKey
+	verifier string                 // 令牌接口要求的PKCE code_verifier
+	claims   map[string]interface{} // 签入ID Token的声明
+	userInfo map[string]interface{}
+}
+
+func newMockOIDCServer(t *testing.T) *mockOIDCServer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockOIDCServer{key: key, userInfo: map[string]interface{}{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{
+			"issuer":                                m.URL,
+			"authorization_endpoint":                m.URL + "/authorize",
+			"token_endpoint":                        m.URL + "/token",
+			"jwks_uri":                              m.URL + "/jwks",
+			"userinfo_endpoint":                     m.URL + "/userinfo",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test",
+				"use": "sig",
+				"alg": "RS256",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("code") != mockOIDCCode || r.FormValue("code_verifier") != m.verifier {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJSON(w, map[string]string{"error": "invalid_grant"})
+			return
+		}
+		writeJSON(w, map[string]interface{}{
+			"access_token": "access-token",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     m.signIDToken(t),
+		})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, m.userInfo)
+	})
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (m *mockOIDCServer) signIDToken(t *testing.T) string {
+	t.Helper()
+	claims := map[string]interface{}{
+		"iss": m.URL,
+		"aud": mockOIDCClientID,
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	for k, v := range m.claims {
+		claims[k] = v
+	}
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(input))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, m.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// newTestOIDCService 创建指向模拟身份提供方的服务，并清除缓存的发现文档
+func newTestOIDCService(t *testing.T, issuer string) *OIDCService {
+	t.Helper()
+	oidcProvider = nil
+	t.Cleanup(func() { oidcProvider = nil })
+	return &OIDCService{cfg: config.OIDCConfig{
+		Enabled:       true,
+		Issuer:        issuer,
+		ClientID:      mockOIDCClientID,
+		ClientSecret:  "secret",
+		RedirectURL:   "http://localhost/api/v1/auth/oidc/callback",
+		Scopes:        []string{"openid", "profile", "email"},
+		UsernameClaim: "preferred_username",
+		NameClaim:     "name",
+		GroupsClaim:   "groups",
+		TeacherGroups: []string{"teachers"},
+	}}
+}
+
+func TestOIDCExchange(t *testing.T) {
+	server := newMockOIDCServer(t)
+	service := newTestOIDCService(t, server.URL)
+	state := &oidcState{Verifier: oauth2.GenerateVerifier(), Nonce: "nonce-1"}
+	server.verifier = state.Verifier
+	server.claims = map[string]interface{}{
+		"sub":                "idp-user-1",
+		"nonce":              "nonce-1",
+		"preferred_username": "zhangsan",
+		"name":               "张三",
+		"email":              "zhangsan@example.edu",
+	}
+	// ID Token中没有的组信息从UserInfo补充
+	server.userInfo = map[string]interface{}{"sub": "idp-user-1", "groups": []string{"teachers"}}
+
+	ident, err := service.exchange(context.Background(), state, mockOIDCCode)
+	if err != nil {
+		t.Fatalf("exchange: %v", err)
+	}
+	if ident.Subject != "idp-user-1" || ident.Username != "zhangsan" || ident.RealName != "张三" {
+		t.Errorf("unexpected identity: %+v", ident)
+	}
+	if ident.Role != models.RoleTeacher {
+		t.Errorf("role = %q, want %q", ident.Role, models.RoleTeacher)
+	}
+	if ident.EmailVerified {
+		t.Error("email without email_verified claim must not be treated as verified")
+	}
+}
+
+func TestOIDCExchangeRejects(t *testing.T) {
+	server := newMockOIDCServer(t)
+	service := newTestOIDCService(t, server.URL)
+	server.claims = map[string]interface{}{"sub": "idp-user-1", "nonce": "nonce-1"}
+
+	tests := []struct {
+		name  string
+		state *oidcState
+	}{
+		{"PKCE verifier不匹配", &oidcState{Verifier: oauth2.GenerateVerifier(), Nonce: "nonce-1"}},
+		{"nonce不匹配", &oidcState{Verifier: "expected-verifier", Nonce: "nonce-2"}},
+	}
+	server.verifier = "expected-verifier"
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := service.exchange(context.Background(), tt.state, mockOIDCCode); err == nil {
+				t.Fatal("expected exchange to fail")
+			}
+		})
+	}
+}
+
+func TestOIDCCallbackRequiresBrowserState(t *testing.T) {
+	service := newTestOIDCService(t, "http://127.0.0.1:0")
+	for _, browserState := range []string{"", "other-state"} {
+		if _, _, err := service.HandleCallback(mockOIDCCode, "state-1", browserState); !errors.Is(err, ErrOIDCInvalidState) {
+			t.Errorf("browser state %q: err = %v, want ErrOIDCInvalidState", browserState, err)
+		}
+	}
+}
+
+func TestOIDCEmailVerifiedClaim(t *testing.T) {
+	service := newTestOIDCService(t, "http://127.0.0.1:0")
+	tests := []struct {
+		claim interface{}
+		want  bool
+	}{
+		{nil, false},
+		{true, true},
+		{false, false},
+		{"true", true},
+		{"false", false},
+	}
+	for _, tt := range tests {
+		claims := map[string]interface{}{"email": "a@example.edu"}
+		if tt.claim != nil {
+			claims["email_verified"] = tt.claim
+		}
+		if got := service.identityFromClaims("sub", claims).EmailVerified; got != tt.want {
+			t.Errorf("email_verified=%v: got %v, want %v", tt.claim, got, tt.want)
+		}
+	}
+}
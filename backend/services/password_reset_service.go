@@ -0,0 +1,176 @@
+package services
+
+import (
+	"backend/config"
+	"backend/database"
+	"backend/models"
+	"backend/redis"
+	"backend/utils"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"time"
+
+	goredis "github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+const (
+	resetTokenLength   = 32
+	resetThrottle      = time.Minute // 同一用户两次申请的最短间隔
+	defaultResetExpire = 30          // 分钟
+)
+
+var ErrInvalidResetToken = errors.New("重置链接无效或已过期")
+
+// PasswordResetService 通过邮件找回密码
+type PasswordResetService struct {
+	db  *gorm.DB
+	cfg config.ResetConfig
+}
+
+// NewPasswordResetService 创建找回密码服务实例
+func NewPasswordResetService() *PasswordResetService {
+	cfg := config.GlobalConfig.Reset
+	if cfg.Expire <= 0 {
+		cfg.Expire = defaultResetExpire
+	}
+	return &PasswordResetService{db: database.DB, cfg: cfg}
+}
+
+func resetTokenKey(token string) string {
+	return "password_reset:token:" + hashToken(token)
+}
+
+func resetUserKey(userID uint) string {
+	return fmt.Sprintf("password_reset:user:%d", userID)
+}
+
+// RequestReset 为学校内邮箱对应的正常账户生成重置令牌并发送邮件。
+// 邮箱不存在、密码由LDAP目录管理或申请过于频繁时静默忽略，避免泄露账户是否存在。
+func (s *PasswordResetService) RequestReset(tenantID uint, email string) error {
+	var user models.User
+	if err := s.db.Scopes(database.TenantScope(tenantID)).Where("email = ? AND status = ?", email, models.UserStatusActive).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	// 目录管理密码的账户登录时不使用本地密码，重置没有意义
+	if NewLDAPService().ManagesPassword(&user) {
+		return nil
+	}
+
+	ctx := context.Background()
+	throttled, err := redis.RDB.SetNX(ctx, fmt.Sprintf("password_reset:throttle:%d", user.ID), 1, resetThrottle).Result()
+	if err != nil {
+		return err
+	}
+	if !throttled {
+		return nil
+	}
+
+	token, err := utils.GenerateRandomString(resetTokenLength)
+	if err != nil {
+		return err
+	}
+	expire := time.Duration(s.cfg.Expire) * time.Minute
+
+	// 每个用户只保留最新的重置令牌
+	if previous, err := redis.GetCache(ctx, resetUserKey(user.ID)); err == nil {
+		redis.DeleteCache(ctx, "password_reset:token:"+previous)
+	}
+	pipe := redis.RDB.TxPipeline()
+	pipe.Set(ctx, resetTokenKey(token), user.ID, expire)
+	pipe.Set(ctx, resetUserKey(user.ID), hashToken(token), expire)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+
+	name := user.RealName
+	if name == "" {
+		name = user.Username
+	}
+	data := PasswordResetMailData{
+		Name:    name,
+		Link:    s.resetLink(token),
+		Minutes: s.cfg.Expire,
+	}
+	return NewMailService().SendTemplate(user.Email, passwordResetSubject, passwordResetText, passwordResetHTML, data)
+}
+
+func (s *PasswordResetService) resetLink(token string) string {
+	link := s.cfg.URL
+	if link == "" {
+		link = "/reset-password"
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return link + "?token=" + url.QueryEscape(token)
+	}
+	query := u.Query()
+	query.Set("token", token)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// ConfirmReset 校验令牌并设置新密码，令牌使用后立即失效，用户的所有登录会话同时注销
+func (s *PasswordResetService) ConfirmReset(token, newPassword string) error {
+	ctx := context.Background()
+	value, err := redis.GetCache(ctx, resetTokenKey(token))
+	if errors.Is(err, goredis.Nil) {
+		return ErrInvalidResetToken
+	}
+	if err != nil {
+		return err
+	}
+	userID, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return ErrInvalidResetToken
+	}
+
+	var user models.User
+	if err := s.db.First(&user, userID).Error; err != nil || user.Status != models.UserStatusActive {
+		return ErrInvalidResetToken
+	}
+	// 申请后才改为由目录管理密码的账户不能再重置
+	if NewLDAPService().ManagesPassword(&user) {
+		return ErrDirectoryManagedPassword
+	}
+	// 密码不符合要求时保留令牌，允许用户重新提交
+	if err := utils.ValidatePasswordStrength(newPassword, user.Username); err != nil {
+		return err
+	}
+
+	// 删除成功才算使用了令牌，保证并发提交时只有一次生效
+	deleted, err := redis.RDB.Del(ctx, resetTokenKey(token)).Result()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrInvalidResetToken
+	}
+
+	hashedPassword, err := utils.HashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+	if err := s.db.Model(&user).Updates(map[string]interface{}{
+		"password":             hashedPassword,
+		"must_change_password": false,
+	}).Error; err != nil {
+		return err
+	}
+
+	redis.DeleteCache(ctx, resetUserKey(user.ID))
+	if err := NewTokenService().RevokeAll(user.ID); err != nil {
+		log.Printf("注销用户%d的登录会话失败: %v", user.ID, err)
+	}
+	if err := NewLoginGuardService().UnlockUser(user.TenantID, user.Username); err != nil {
+		log.Printf("解除用户%d的登录锁定失败: %v", user.ID, err)
+	}
+	return nil
+}
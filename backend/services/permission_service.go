@@ -0,0 +1,317 @@
+package services
+
+import (
+	"backend/database"
+	"backend/models"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 角色权限映射的缓存时间，管理员修改后立即失效
+const permissionCacheTTL = time.Minute
+
+var (
+	ErrUnknownPermission = errors.New("权限不存在")
+	ErrRoleLockout       = errors.New("不能移除平台管理员的角色管理权限")
+)
+
+// 内置权限
+var builtinPermissions = []models.Permission{
+	{Code: models.PermTenantManage, Name: "学校管理", Description: "创建学校、设置配额和学校管理员"},
+	{Code: models.PermUserManage, Name: "用户管理", Description: "管理用户、注册审核和邀请码"},
+	{Code: models.PermRoleManage, Name: "角色管理", Description: "调整各角色拥有的权限"},
+	{Code: models.PermAuditView, Name: "审计日志", Description: "查询和导出本校的操作审计日志"},
+	{Code: models.PermServiceAccount, Name: "服务账户", Description: "管理供外部系统调用接口的服务账户和API密钥"},
+	{Code: models.PermCourseCreate, Name: "创建课程", Description: "创建新课程"},
+	{Code: models.PermCourseUpdate, Name: "编辑课程", Description: "修改课程信息"},
+	{Code: models.PermCourseDelete, Name: "删除课程", Description: "删除课程"},
+	{Code: models.PermCourseMembers, Name: "课程成员", Description: "添加或移除协作教师和助教"},
+	{Code: models.PermCourseStudents, Name: "学生管理", Description: "管理选课学生、批量导入和邀请码"},
+	{Code: models.PermCourseAnalytics, Name: "学情分析", Description: "查看学情分析、知识点掌握度和风险预警"},
+	{Code: models.PermMaterialUpload, Name: "上传资料", Description: "上传教学资料，可删除自己上传的资料"},
+	{Code: models.PermMaterialDelete, Name: "删除资料", Description: "删除课程内任意教学资料"},
+	{Code: models.PermExerciseManage, Name: "练习管理", Description: "创建练习、AI生成题目、标注题目知识点"},
+	{Code: models.PermLessonPlan, Name: "备课生成", Description: "使用AI生成备课内容"},
+	{Code: models.PermChatReview, Name: "对话辅导", Description: "查看课程内学生的AI对话并人工回复"},
+}
+
+// 内置角色及默认权限，只在角色首次创建时写入，之后以数据库中的配置为准。
+// 角色权限由所有学校共用，因此学校管理员不能调整
+var builtinRoles = []struct {
+	role        models.Role
+	permissions []string
+}{
+	{models.Role{Name: string(models.RoleSuperAdmin), DisplayName: "平台管理员", Scope: models.RoleScopeGlobal}, allPermissionCodes()},
+	{models.Role{Name: string(models.RoleAdmin), DisplayName: "学校管理员", Scope: models.RoleScopeGlobal}, allPermissionCodes(
+		models.PermTenantManage, models.PermRoleManage,
+	)},
+	{models.Role{Name: string(models.RoleTeacher), DisplayName: "教师", Scope: models.RoleScopeGlobal}, []string{
+		models.PermCourseCreate,
+	}},
+	{models.Role{Name: string(models.RoleStudent), DisplayName: "学生", Scope: models.RoleScopeGlobal}, nil},
+	{models.Role{Name: models.CourseRoleOwner, DisplayName: "课程负责人", Scope: models.RoleScopeCourse}, []string{
+		models.PermCourseUpdate, models.PermCourseDelete, models.PermCourseMembers, models.PermCourseStudents,
+		models.PermCourseAnalytics, models.PermMaterialUpload, models.PermMaterialDelete,
+		models.PermExerciseManage, models.PermLessonPlan, models.PermChatReview,
+	}},
+	{models.Role{Name: models.CourseRoleCoTeacher, DisplayName: "协作教师", Scope: models.RoleScopeCourse}, []string{
+		models.PermCourseUpdate, models.PermCourseStudents, models.PermCourseAnalytics,
+		models.PermMaterialUpload, models.PermMaterialDelete, models.PermExerciseManage, models.PermLessonPlan,
+		models.PermChatReview,
+	}},
+	{models.Role{Name: models.CourseRoleAssistant, DisplayName: "助教", Scope: models.RoleScopeCourse}, []string{
+		models.PermCourseAnalytics, models.PermMaterialUpload, models.PermExerciseManage, models.PermChatReview,
+	}},
+}
+
+// allPermissionCodes 所有内置权限，排除except中的权限
+func allPermissionCodes(except ...string) []string {
+	codes := make([]string, 0, len(builtinPermissions))
+	for _, p := range builtinPermissions {
+		excluded := false
+		for _, e := range except {
+			if p.Code == e {
+				excluded = true
+			}
+		}
+		if !excluded {
+			codes = append(codes, p.Code)
+		}
+	}
+	return codes
+}
+
+// 角色名 -> 权限编码集合
+var permissionCache = struct {
+	sync.RWMutex
+	roles    map[string]map[string]bool
+	loadedAt time.Time
+}{}
+
+// InvalidatePermissionCache 角色权限变更后清除缓存
+func InvalidatePermissionCache() {
+	permissionCache.Lock()
+	permissionCache.roles = nil
+	permissionCache.Unlock()
+}
+
+// PermissionService 基于角色的权限校验，全局角色来自User.Role，课程角色来自课程负责人和CourseMember
+type PermissionService struct {
+	db *gorm.DB
+}
+
+// NewPermissionService 创建权限服务实例
+func NewPermissionService() *PermissionService {
+	return &PermissionService{db: database.DB}
+}
+
+// Seed 写入缺少的内置权限和角色，启动时调用。
+// 默认权限只在角色或权限首次创建时授予，不会覆盖管理员之后的调整
+func (s *PermissionService) Seed() error {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		created := map[string]bool{}
+		for _, p := range builtinPermissions {
+			permission := p
+			result := tx.Where(models.Permission{Code: p.Code}).
+				Attrs(models.Permission{Name: p.Name, Description: p.Description}).
+				FirstOrCreate(&permission)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected > 0 {
+				created[p.Code] = true
+			}
+		}
+
+		for _, builtin := range builtinRoles {
+			var role models.Role
+			result := tx.Where(models.Role{Name: builtin.role.Name}).Attrs(builtin.role).FirstOrCreate(&role)
+			if result.Error != nil {
+				return result.Error
+			}
+			var grant []string
+			for _, code := range builtin.permissions {
+				if result.RowsAffected > 0 || created[code] {
+					grant = append(grant, code)
+				}
+			}
+			if len(grant) == 0 {
+				continue
+			}
+			var permissions []models.Permission
+			if err := tx.Where("code IN ?", grant).Find(&permissions).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&role).Association("Permissions").Append(permissions); err != nil {
+				return err
+			}
+		}
+
+		// 升级到多学校版本时，角色权限改为由平台管理员统一调整
+		if created[models.PermTenantManage] {
+			if err := tx.Exec(`DELETE FROM role_permissions WHERE role_id IN (SELECT id FROM roles WHERE name = ?)
+				AND permission_id IN (SELECT id FROM permissions WHERE code = ?)`,
+				string(models.RoleAdmin), models.PermRoleManage).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	InvalidatePermissionCache()
+	return err
+}
+
+// rolePermissions 读取所有角色的权限映射
+func (s *PermissionService) rolePermissions() map[string]map[string]bool {
+	permissionCache.RLock()
+	roles, loadedAt := permissionCache.roles, permissionCache.loadedAt
+	permissionCache.RUnlock()
+	if roles != nil && time.Since(loadedAt) < permissionCacheTTL {
+		return roles
+	}
+
+	var list []models.Role
+	if err := s.db.Preload("Permissions").Find(&list).Error; err != nil {
+		// 数据库异常时沿用旧缓存，没有缓存则拒绝所有权限
+		if roles != nil {
+			return roles
+		}
+		return map[string]map[string]bool{}
+	}
+	roles = make(map[string]map[string]bool, len(list))
+	for _, role := range list {
+		codes := make(map[string]bool, len(role.Permissions))
+		for _, p := range role.Permissions {
+			codes[p.Code] = true
+		}
+		roles[role.Name] = codes
+	}
+
+	permissionCache.Lock()
+	permissionCache.roles = roles
+	permissionCache.loadedAt = time.Now()
+	permissionCache.Unlock()
+	return roles
+}
+
+// CourseRole 用户在课程内的角色，课程负责人优先，不是课程成员时返回空
+func (s *PermissionService) CourseRole(userID, courseID uint) string {
+	if courseID == 0 {
+		return ""
+	}
+	var course models.Course
+	if err := s.db.Select("id", "teacher_id").First(&course, courseID).Error; err != nil {
+		return ""
+	}
+	if course.TeacherID == userID {
+		return models.CourseRoleOwner
+	}
+	var member models.CourseMember
+	if err := s.db.Where("course_id = ? AND user_id = ?", courseID, userID).First(&member).Error; err != nil {
+		return ""
+	}
+	return member.Role
+}
+
+// SameTenant 课程与用户是否属于同一学校，课程不存在时返回false
+func (s *PermissionService) SameTenant(userID, courseID uint) bool {
+	var count int64
+	s.db.Model(&models.Course{}).
+		Joins("JOIN users ON users.id = ? AND users.tenant_id = courses.tenant_id", userID).
+		Where("courses.id = ?", courseID).
+		Count(&count)
+	return count > 0
+}
+
+// Can 检查用户是否拥有权限：全局角色的权限在本校所有课程生效，courseID不为0时再检查课程内角色
+func (s *PermissionService) Can(userID uint, role string, permission string, courseID uint) bool {
+	// 其他学校的课程一律无权限，平台管理员也不例外
+	if courseID != 0 && !s.SameTenant(userID, courseID) {
+		return false
+	}
+	roles := s.rolePermissions()
+	if roles[role][permission] {
+		return true
+	}
+	if courseRole := s.CourseRole(userID, courseID); courseRole != "" {
+		return roles[courseRole][permission]
+	}
+	return false
+}
+
+// CourseUsersWith 课程内拥有指定权限的教师：课程负责人，以及课程角色或全局角色拥有该权限的课程成员
+func (s *PermissionService) CourseUsersWith(course *models.Course, permission string) []uint {
+	userIDs := []uint{course.TeacherID}
+	var members []models.CourseMember
+	if err := s.db.Preload("User").Where("course_id = ?", course.ID).Find(&members).Error; err != nil {
+		return userIDs
+	}
+	for _, member := range members {
+		if member.UserID != course.TeacherID && s.Can(member.UserID, string(member.User.Role), permission, course.ID) {
+			userIDs = append(userIDs, member.UserID)
+		}
+	}
+	return userIDs
+}
+
+// Permissions 用户的有效权限编码，courseID不为0时包含课程内角色的权限
+func (s *PermissionService) Permissions(userID uint, role string, courseID uint) []string {
+	roles := s.rolePermissions()
+	set := map[string]bool{}
+	for code := range roles[role] {
+		set[code] = true
+	}
+	if courseRole := s.CourseRole(userID, courseID); courseRole != "" {
+		for code := range roles[courseRole] {
+			set[code] = true
+		}
+	}
+
+	codes := make([]string, 0, len(set))
+	for code := range set {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// SetRolePermissions 替换角色的权限
+func (s *PermissionService) SetRolePermissions(role *models.Role, codes []string) error {
+	var permissions []models.Permission
+	if len(codes) > 0 {
+		if err := s.db.Where("code IN ?", codes).Find(&permissions).Error; err != nil {
+			return err
+		}
+	}
+	found := map[string]bool{}
+	for _, p := range permissions {
+		found[p.Code] = true
+	}
+	for _, code := range codes {
+		if !found[code] {
+			return ErrUnknownPermission
+		}
+	}
+	// 防止平台管理员失去修改权限的能力而无法恢复
+	if role.Name == string(models.RoleSuperAdmin) && !found[models.PermRoleManage] {
+		return ErrRoleLockout
+	}
+
+	association := s.db.Model(role).Association("Permissions")
+	var err error
+	if len(permissions) == 0 {
+		err = association.Clear()
+	} else {
+		err = association.Replace(permissions)
+	}
+	if err != nil {
+		return err
+	}
+	role.Permissions = permissions
+	InvalidatePermissionCache()
+	return nil
+}
@@ -0,0 +1,626 @@
+package services
+
+import (
+	"archive/zip"
+	"backend/config"
+	"backend/database"
+	"backend/models"
+	"backend/redis"
+	"backend/utils"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	privacyTaskInterval   = 10 * time.Minute
+	staleExportPending    = time.Minute      // 创建后超过该时间仍未开始生成，视为服务重启丢失
+	staleExportProcessing = 30 * time.Minute // 生成中超过该时间视为中断
+	anonymizedRealName    = "已注销用户"
+)
+
+var (
+	ErrExportInProgress  = errors.New("已有正在生成的数据导出，请等待完成后再申请")
+	ErrExportNotReady    = errors.New("导出文件尚未生成或已过期")
+	ErrDeletionPending   = errors.New("已提交注销申请，请勿重复提交")
+	ErrDeletionNotFound  = errors.New("没有待处理的注销申请")
+	ErrDeletionAdmin     = errors.New("管理员账户不能自助注销，请联系其他管理员处理")
+	ErrAlreadyAnonymized = errors.New("该账户已注销")
+)
+
+// PrivacyService 个人数据导出与账户注销
+type PrivacyService struct {
+	db  *gorm.DB
+	cfg config.PrivacyConfig
+}
+
+// NewPrivacyService 创建个人数据服务实例
+func NewPrivacyService() *PrivacyService {
+	cfg := config.GlobalConfig.Privacy
+	if cfg.ExportDir == "" {
+		cfg.ExportDir = "exports"
+	}
+	if cfg.ExportExpire <= 0 {
+		cfg.ExportExpire = 72
+	}
+	if cfg.DeletionGraceDays < 0 {
+		cfg.DeletionGraceDays = 0
+	}
+	return &PrivacyService{db: database.DB, cfg: cfg}
+}
+
+// StartScheduler 定期执行到期的注销申请、清理过期的导出文件并重新生成中断的导出，多实例部署时通过Redis锁保证只有一个实例执行
+func (s *PrivacyService) StartScheduler() {
+	go func() {
+		ticker := time.NewTicker(privacyTaskInterval)
+		defer ticker.Stop()
+		for {
+			s.runLocked()
+			<-ticker.C
+		}
+	}()
+	log.Printf("Privacy task scheduler started, interval %v", privacyTaskInterval)
+}
+
+func (s *PrivacyService) runLocked() {
+	ok, err := redis.RDB.SetNX(context.Background(), "privacy:tasks:lock", time.Now().Unix(), privacyTaskInterval/2).Result()
+	if err != nil || !ok {
+		return
+	}
+
+	if err := s.processDueDeletions(); err != nil {
+		log.Printf("执行账户注销失败: %v", err)
+	}
+	if err := s.cleanupExports(); err != nil {
+		log.Printf("清理过期数据导出失败: %v", err)
+	}
+	if err := s.resumeStaleExports(); err != nil {
+		log.Printf("重新生成数据导出失败: %v", err)
+	}
+}
+
+// RequestExport 申请导出个人数据，导出文件在后台生成
+func (s *PrivacyService) RequestExport(user *models.User) (*models.DataExport, error) {
+	var running int64
+	if err := s.db.Model(&models.DataExport{}).
+		Where("user_id = ? AND status IN ?", user.ID, []string{models.DataExportPending, models.DataExportProcessing}).
+		Count(&running).Error; err != nil {
+		return nil, err
+	}
+	if running > 0 {
+		return nil, ErrExportInProgress
+	}
+
+	export := models.DataExport{
+		TenantID: user.TenantID,
+		UserID:   user.ID,
+		Status:   models.DataExportPending,
+	}
+	if err := s.db.Create(&export).Error; err != nil {
+		return nil, err
+	}
+
+	go s.generateExport(export.ID)
+	return &export, nil
+}
+
+// ExportFile 返回可下载的导出文件路径
+func (s *PrivacyService) ExportFile(export *models.DataExport) (string, error) {
+	if export.Status != models.DataExportCompleted || export.FilePath == "" ||
+		(export.ExpiresAt != nil && export.ExpiresAt.Before(time.Now())) {
+		return "", ErrExportNotReady
+	}
+	if _, err := os.Stat(export.FilePath); err != nil {
+		return "", ErrExportNotReady
+	}
+	return export.FilePath, nil
+}
+
+// generateExport 生成导出文件，先把状态从pending改为processing，避免多个实例重复生成
+func (s *PrivacyService) generateExport(exportID uint) {
+	result := s.db.Model(&models.DataExport{}).
+		Where("id = ? AND status = ?", exportID, models.DataExportPending).
+		Update("status", models.DataExportProcessing)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return
+	}
+
+	var export models.DataExport
+	if err := s.db.First(&export, exportID).Error; err != nil {
+		return
+	}
+
+	path, size, err := s.writeExport(&export)
+	if err != nil {
+		log.Printf("生成用户%d的数据导出失败: %v", export.UserID, err)
+		s.db.Model(&export).Updates(map[string]interface{}{
+			"status": models.DataExportFailed,
+			"error":  "生成导出文件失败，请重新申请",
+		})
+		return
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(time.Duration(s.cfg.ExportExpire) * time.Hour)
+	if err := s.db.Model(&export).Updates(map[string]interface{}{
+		"status":       models.DataExportCompleted,
+		"file_path":    path,
+		"file_size":    size,
+		"completed_at": now,
+		"expires_at":   expiresAt,
+	}).Error; err != nil {
+		os.Remove(path)
+		log.Printf("更新数据导出%d状态失败: %v", export.ID, err)
+		return
+	}
+
+	NewNotificationService().Notify(export.UserID, "data_export", "个人数据导出已完成",
+		fmt.Sprintf("您申请的个人数据已打包完成，请在%s前下载。", expiresAt.Format("2006-01-02 15:04")),
+		"/profile/privacy")
+}
+
+// 导出文件内容：个人资料、对话、答题、练习记录、学习进度、选课和通知，每类数据一个JSON文件
+func (s *PrivacyService) writeExport(export *models.DataExport) (string, int64, error) {
+	userID := export.UserID
+
+	var user models.User
+	if err := s.db.First(&user, userID).Error; err != nil {
+		return "", 0, err
+	}
+	var profile models.UserProfile
+	s.db.Where("user_id = ?", userID).Limit(1).Find(&profile)
+	var identities []models.UserIdentity
+	s.db.Where("user_id = ?", userID).Find(&identities)
+
+	var sessions []models.ChatSession
+	if err := s.db.Preload("Messages").Where("user_id = ?", userID).Order("id ASC").Find(&sessions).Error; err != nil {
+		return "", 0, err
+	}
+	var records []models.ExerciseRecord
+	if err := s.db.Where("user_id = ?", userID).Order("id ASC").Find(&records).Error; err != nil {
+		return "", 0, err
+	}
+	var answers []models.StudentAnswer
+	if err := s.db.Preload("Question", func(db *gorm.DB) *gorm.DB {
+		return db.Select("id", "title", "type", "score")
+	}).Where("user_id = ?", userID).Order("id ASC").Find(&answers).Error; err != nil {
+		return "", 0, err
+	}
+	var chapterProgress []models.LearningProgress
+	var knowledgeProgress []models.KnowledgeProgress
+	var mastery []models.KnowledgeMastery
+	s.db.Where("user_id = ?", userID).Find(&chapterProgress)
+	s.db.Where("user_id = ?", userID).Find(&knowledgeProgress)
+	s.db.Where("user_id = ?", userID).Find(&mastery)
+	var enrollments []models.Enrollment
+	s.db.Preload("Course", func(db *gorm.DB) *gorm.DB {
+		return db.Select("id", "name", "subject")
+	}).Where("user_id = ?", userID).Find(&enrollments)
+	var notifications []models.Notification
+	s.db.Where("user_id = ?", userID).Find(&notifications)
+
+	files := []struct {
+		name string
+		data interface{}
+		drop []string // 去掉未加载的关联对象，避免导出大量空字段
+	}{
+		{"profile.json", map[string]interface{}{"user": user, "profile": profile, "identities": identities}, nil},
+		{"chat_sessions.json", sessions, []string{"user", "course", "chapter", "messages.session"}},
+		{"exercise_records.json", records, []string{"user", "exercise"}},
+		{"answers.json", answers, []string{"user", "exercise", "question.exercise"}},
+		{"progress.json", map[string]interface{}{
+			"chapters":  exportRows(chapterProgress, "user", "course", "chapter"),
+			"knowledge": exportRows(knowledgeProgress, "knowledge"),
+			"mastery":   exportRows(mastery, "knowledge"),
+		}, nil},
+		{"enrollments.json", enrollments, []string{"user", "course.teacher", "course.chapters"}},
+		{"notifications.json", notifications, nil},
+	}
+
+	dir := filepath.Join(s.cfg.ExportDir, "tenants", fmt.Sprint(export.TenantID))
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", 0, err
+	}
+	suffix, err := utils.GenerateRandomString(16)
+	if err != nil {
+		return "", 0, err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%d-%d-%s.zip", userID, export.ID, suffix))
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return "", 0, err
+	}
+	zw := zip.NewWriter(f)
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		data := file.data
+		if len(file.drop) > 0 {
+			data = exportRows(data, file.drop...)
+		}
+		if err = writeZipJSON(zw, file.name, data); err != nil {
+			break
+		}
+		names = append(names, file.name)
+	}
+	if err == nil {
+		err = writeZipJSON(zw, "manifest.json", map[string]interface{}{
+			"user_id":      user.ID,
+			"username":     user.Username,
+			"generated_at": time.Now(),
+			"files":        names,
+		})
+	}
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", 0, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", 0, err
+	}
+	return path, info.Size(), nil
+}
+
+func writeZipJSON(zw *zip.Writer, name string, data interface{}) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(data)
+}
+
+// exportRows 把记录列表转为map列表并去掉指定字段，messages.session表示去掉每条消息中的session字段
+func exportRows(v interface{}, drop ...string) interface{} {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var rows []map[string]interface{}
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return v
+	}
+	for _, row := range rows {
+		for _, key := range drop {
+			dropField(row, key)
+		}
+	}
+	return rows
+}
+
+func dropField(row map[string]interface{}, key string) {
+	field, rest, nested := strings.Cut(key, ".")
+	if !nested {
+		delete(row, key)
+		return
+	}
+	switch child := row[field].(type) {
+	case map[string]interface{}:
+		dropField(child, rest)
+	case []interface{}:
+		for _, item := range child {
+			if m, ok := item.(map[string]interface{}); ok {
+				dropField(m, rest)
+			}
+		}
+	}
+}
+
+// 删除过期的导出文件
+func (s *PrivacyService) cleanupExports() error {
+	var exports []models.DataExport
+	if err := s.db.Where("status = ? AND expires_at < ?", models.DataExportCompleted, time.Now()).
+		Find(&exports).Error; err != nil {
+		return err
+	}
+	for _, export := range exports {
+		os.Remove(export.FilePath)
+		s.db.Model(&export).Updates(map[string]interface{}{
+			"status":    models.DataExportExpired,
+			"file_path": "",
+		})
+	}
+	return nil
+}
+
+// 服务重启等原因中断的导出重新生成
+func (s *PrivacyService) resumeStaleExports() error {
+	now := time.Now()
+	if err := s.db.Model(&models.DataExport{}).
+		Where("status = ? AND updated_at < ?", models.DataExportProcessing, now.Add(-staleExportProcessing)).
+		Update("status", models.DataExportPending).Error; err != nil {
+		return err
+	}
+
+	var ids []uint
+	if err := s.db.Model(&models.DataExport{}).
+		Where("status = ? AND updated_at < ?", models.DataExportPending, now.Add(-staleExportPending)).
+		Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+	for _, id := range ids {
+		s.generateExport(id)
+	}
+	return nil
+}
+
+// PendingDeletion 用户待执行的注销申请，没有时返回nil
+func (s *PrivacyService) PendingDeletion(userID uint) (*models.AccountDeletion, error) {
+	var deletion models.AccountDeletion
+	err := s.db.Where("user_id = ? AND status = ?", userID, models.AccountDeletionPending).First(&deletion).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &deletion, nil
+}
+
+// RequestDeletion 用户申请注销账户，冷静期结束后执行匿名化
+func (s *PrivacyService) RequestDeletion(user *models.User, reason string) (*models.AccountDeletion, error) {
+	if user.Role.IsAdmin() {
+		return nil, ErrDeletionAdmin
+	}
+	pending, err := s.PendingDeletion(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if pending != nil {
+		return nil, ErrDeletionPending
+	}
+
+	deletion := models.AccountDeletion{
+		TenantID:    user.TenantID,
+		UserID:      user.ID,
+		Status:      models.AccountDeletionPending,
+		Reason:      reason,
+		RequestedBy: user.ID,
+		ScheduledAt: time.Now().AddDate(0, 0, s.cfg.DeletionGraceDays),
+	}
+	if err := s.db.Create(&deletion).Error; err != nil {
+		return nil, err
+	}
+	return &deletion, nil
+}
+
+// CancelDeletion 冷静期内撤销注销申请
+func (s *PrivacyService) CancelDeletion(userID uint) (*models.AccountDeletion, error) {
+	pending, err := s.PendingDeletion(userID)
+	if err != nil {
+		return nil, err
+	}
+	if pending == nil {
+		return nil, ErrDeletionNotFound
+	}
+	if err := s.db.Model(pending).Update("status", models.AccountDeletionCancelled).Error; err != nil {
+		return nil, err
+	}
+	return pending, nil
+}
+
+// DeleteNow 管理员立即注销账户，不经过冷静期
+func (s *PrivacyService) DeleteNow(user *models.User, operatorID uint, reason string) (*models.AccountDeletion, error) {
+	if user.AnonymizedAt != nil {
+		return nil, ErrAlreadyAnonymized
+	}
+	deletion, err := s.PendingDeletion(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if deletion == nil {
+		deletion = &models.AccountDeletion{
+			TenantID:    user.TenantID,
+			UserID:      user.ID,
+			Status:      models.AccountDeletionPending,
+			Reason:      reason,
+			RequestedBy: operatorID,
+			ScheduledAt: time.Now(),
+		}
+		if err := s.db.Create(deletion).Error; err != nil {
+			return nil, err
+		}
+	}
+	if err := s.Anonymize(deletion); err != nil {
+		return nil, err
+	}
+	return deletion, nil
+}
+
+// 执行冷静期已结束的注销申请
+func (s *PrivacyService) processDueDeletions() error {
+	var deletions []models.AccountDeletion
+	if err := s.db.Where("status = ? AND scheduled_at <= ?", models.AccountDeletionPending, time.Now()).
+		Find(&deletions).Error; err != nil {
+		return err
+	}
+	for i := range deletions {
+		if err := s.Anonymize(&deletions[i]); err != nil {
+			// 账户已被管理员提前匿名化，申请直接标记为完成，不再重试
+			if errors.Is(err, ErrAlreadyAnonymized) {
+				now := time.Now()
+				if err := s.db.Model(&deletions[i]).Updates(map[string]interface{}{
+					"status":       models.AccountDeletionCompleted,
+					"completed_at": now,
+				}).Error; err != nil {
+					log.Printf("更新用户%d的注销申请失败: %v", deletions[i].UserID, err)
+				}
+				continue
+			}
+			log.Printf("注销用户%d失败: %v", deletions[i].UserID, err)
+			continue
+		}
+		NewAuditService().Record(models.AuditLog{
+			TenantID:   deletions[i].TenantID,
+			Action:     "user.anonymize",
+			TargetType: "user",
+			TargetID:   deletions[i].UserID,
+		}, map[string]interface{}{"deletion_id": deletions[i].ID, "scheduled": true})
+	}
+	return nil
+}
+
+// Anonymize 匿名化账户：清除姓名、邮箱、头像等个人信息，删除对话、通知、外部身份和导出文件，
+// 保留选课、练习记录、得分和学习进度，使课程统计数据不受影响
+func (s *PrivacyService) Anonymize(deletion *models.AccountDeletion) error {
+	userID := deletion.UserID
+	var user models.User
+	var exportFiles []string
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().First(&user, userID).Error; err != nil {
+			return err
+		}
+		if user.AnonymizedAt != nil {
+			return ErrAlreadyAnonymized
+		}
+
+		// 随机密码，使账户无法再登录
+		random, err := utils.GenerateRandomString(32)
+		if err != nil {
+			return err
+		}
+		password, err := utils.HashPassword(random)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		anonymizedName := fmt.Sprintf("deleted_%d", user.ID)
+		anonymizedEmail := fmt.Sprintf("deleted_%d@anonymized.invalid", user.ID)
+		if err := tx.Unscoped().Model(&models.User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+			"username":      anonymizedName,
+			"email":         anonymizedEmail,
+			"real_name":     anonymizedRealName,
+			"phone":         "",
+			"avatar":        "",
+			"password":      password,
+			"status":        models.UserStatusDisabled,
+			"anonymized_at": now,
+			"deleted_at":    now,
+		}).Error; err != nil {
+			return err
+		}
+
+		// 个人资料、登录方式和站内数据直接删除
+		for _, model := range []interface{}{
+			&models.UserProfile{}, &models.UserIdentity{}, &models.TwoFactor{}, &models.RecoveryCode{},
+			&models.Notification{}, &models.RiskAlert{}, &models.CourseMember{},
+		} {
+			if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Unscoped().
+			Where("session_id IN (?)", tx.Unscoped().Model(&models.ChatSession{}).Select("id").Where("user_id = ?", user.ID)).
+			Delete(&models.ChatMessage{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM chat_session_knowledge WHERE chat_session_id IN (SELECT id FROM chat_sessions WHERE user_id = ?)",
+			user.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(&models.ChatSession{}).Error; err != nil {
+			return err
+		}
+
+		// 保留得分用于统计，清除作答内容和反馈
+		if err := tx.Unscoped().Model(&models.StudentAnswer{}).Where("user_id = ?", user.ID).
+			Updates(map[string]interface{}{"answer": "", "feedback": ""}).Error; err != nil {
+			return err
+		}
+
+		// 审计日志保留，清除其中的个人信息
+		if err := scrubAuditLogs(tx, &user, anonymizedName, anonymizedEmail); err != nil {
+			return err
+		}
+
+		if err := tx.Model(&models.DataExport{}).Where("user_id = ? AND file_path <> ''", user.ID).
+			Pluck("file_path", &exportFiles).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.DataExport{}).Error; err != nil {
+			return err
+		}
+
+		deletion.Status = models.AccountDeletionCompleted
+		deletion.CompletedAt = &now
+		return tx.Save(deletion).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, path := range exportFiles {
+		os.Remove(path)
+	}
+	utils.RemoveAvatarFiles(user.TenantID, user.Avatar)
+	if err := NewTokenService().RevokeAll(user.ID); err != nil {
+		log.Printf("注销用户%d的登录会话失败: %v", user.ID, err)
+	}
+	return nil
+}
+
+// scrubAuditLogs 清除审计日志中注销用户的个人信息：该用户的操作和以其用户名、邮箱尝试登录失败的记录，
+// 操作人名称替换为匿名用户名；变更和详情中与用户名、邮箱、手机号完全相同的JSON字符串值替换为匿名值，
+// 姓名只在该用户操作或以该用户为对象的记录中替换，避免误改同名用户的记录
+func scrubAuditLogs(tx *gorm.DB, user *models.User, anonymizedName, anonymizedEmail string) error {
+	identifiers := []string{user.Username}
+	if user.Email != "" {
+		identifiers = append(identifiers, user.Email)
+	}
+	if err := tx.Model(&models.AuditLog{}).
+		Where("actor_id = ? OR (tenant_id = ? AND actor_id = 0 AND actor_name IN ?)", user.ID, user.TenantID, identifiers).
+		Update("actor_name", anonymizedName).Error; err != nil {
+		return err
+	}
+
+	tenantLogs := func() *gorm.DB {
+		return tx.Model(&models.AuditLog{}).Where("tenant_id = ?", user.TenantID)
+	}
+	userLogs := func() *gorm.DB {
+		return tenantLogs().Where("actor_id = ? OR (target_type = ? AND target_id = ?)", user.ID, "user", user.ID)
+	}
+	replacements := []struct {
+		value, anonymized string
+		scope             func() *gorm.DB
+	}{
+		{user.Username, anonymizedName, tenantLogs},
+		{user.Email, anonymizedEmail, tenantLogs},
+		{user.Phone, "", tenantLogs},
+		{user.RealName, anonymizedRealName, userLogs},
+	}
+	for _, r := range replacements {
+		if r.value == "" {
+			continue
+		}
+		// 按JSON编码后带引号的字符串匹配，只替换完整的字段值
+		old, _ := json.Marshal(r.value)
+		anonymized, _ := json.Marshal(r.anonymized)
+		pattern := "%" + string(old) + "%"
+		if err := r.scope().Where("changes LIKE ? OR detail LIKE ?", pattern, pattern).
+			Updates(map[string]interface{}{
+				"changes": gorm.Expr("REPLACE(changes, ?, ?)", string(old), string(anonymized)),
+				"detail":  gorm.Expr("REPLACE(detail, ?, ?)", string(old), string(anonymized)),
+			}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
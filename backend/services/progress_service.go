@@ -0,0 +1,256 @@
+package services
+
+import (
+	"backend/config"
+	"backend/database"
+	"backend/models"
+	"backend/redis"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// defaultIdleTimeout 默认空闲判定时长(秒)
+const defaultIdleTimeout = 300
+
+// ProgressService 学习进度服务
+type ProgressService struct {
+	db *gorm.DB
+}
+
+// CourseProgress 课程学习进度汇总
+type CourseProgress struct {
+	CourseID          uint                      `json:"course_id"`
+	Progress          float64                   `json:"progress"` // 0-100，按章节平均
+	TimeSpent         int                       `json:"time_spent"`
+	ChapterCount      int                       `json:"chapter_count"`
+	CompletedChapters int                       `json:"completed_chapters"`
+	LastStudyAt       *time.Time                `json:"last_study_at"`
+	Chapters          []models.LearningProgress `json:"chapters"`
+}
+
+// NewProgressService 创建学习进度服务实例
+func NewProgressService() *ProgressService {
+	return &ProgressService{db: database.DB}
+}
+
+func idleTimeout() time.Duration {
+	seconds := config.GlobalConfig.Progress.IdleTimeout
+	if seconds <= 0 {
+		seconds = defaultIdleTimeout
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// Heartbeat 记录一次学习心跳，距上次心跳未超过空闲时长的间隔计入学习时长
+func (s *ProgressService) Heartbeat(userID, chapterID uint) (*models.LearningProgress, error) {
+	progress, err := s.getOrCreate(userID, chapterID)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	now := time.Now()
+	idle := idleTimeout()
+	lastKey := fmt.Sprintf("progress:heartbeat:%d:%d", userID, chapterID)
+	secondsKey := fmt.Sprintf("progress:seconds:%d:%d", userID, chapterID)
+
+	var elapsed int64
+	if last, err := redis.GetCache(ctx, lastKey); err == nil {
+		if lastUnix, err := strconv.ParseInt(last, 10, 64); err == nil {
+			if gap := now.Sub(time.Unix(lastUnix, 0)); gap > 0 && gap <= idle {
+				elapsed = int64(gap.Seconds())
+			}
+		}
+	}
+	if err := redis.SetCache(ctx, lastKey, now.Unix(), 2*idle); err != nil {
+		return nil, err
+	}
+
+	// 秒数先在Redis中累计，满整分钟再写入数据库
+	minutes := 0
+	if elapsed > 0 {
+		total, err := redis.RDB.IncrBy(ctx, secondsKey, elapsed).Result()
+		if err != nil {
+			return nil, err
+		}
+		if total >= 60 {
+			minutes = int(total / 60)
+			if err := redis.RDB.DecrBy(ctx, secondsKey, int64(minutes)*60).Err(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	updates := map[string]interface{}{"last_study_at": now}
+	if minutes > 0 {
+		updates["time_spent"] = gorm.Expr("time_spent + ?", minutes)
+	}
+	if err := s.db.Model(progress).Updates(updates).Error; err != nil {
+		return nil, err
+	}
+
+	return progress, s.db.First(progress, progress.ID).Error
+}
+
+// CompleteChapter 标记章节已完成
+func (s *ProgressService) CompleteChapter(userID, chapterID uint) (*models.LearningProgress, error) {
+	progress, err := s.getOrCreate(userID, chapterID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	updates := map[string]interface{}{
+		"completed":     true,
+		"completed_at":  now,
+		"progress":      100,
+		"last_study_at": now,
+	}
+	if err := s.db.Model(progress).Updates(updates).Error; err != nil {
+		return nil, err
+	}
+	return progress, nil
+}
+
+// CompleteKnowledge 标记知识点已完成并重新计算章节进度
+func (s *ProgressService) CompleteKnowledge(userID, knowledgeID uint) (*models.LearningProgress, error) {
+	var knowledge models.Knowledge
+	if err := s.db.First(&knowledge, knowledgeID).Error; err != nil {
+		return nil, err
+	}
+
+	record := models.KnowledgeProgress{
+		UserID:      userID,
+		KnowledgeID: knowledge.ID,
+		ChapterID:   knowledge.ChapterID,
+		CompletedAt: time.Now(),
+	}
+	if err := s.db.Where("user_id = ? AND knowledge_id = ?", userID, knowledge.ID).
+		FirstOrCreate(&record).Error; err != nil {
+		return nil, err
+	}
+
+	return s.Recalculate(userID, knowledge.ChapterID)
+}
+
+// Recalculate 根据已完成知识点和已完成练习重新计算章节进度
+func (s *ProgressService) Recalculate(userID, chapterID uint) (*models.LearningProgress, error) {
+	progress, err := s.getOrCreate(userID, chapterID)
+	if err != nil {
+		return nil, err
+	}
+	if progress.Completed {
+		return progress, nil
+	}
+
+	var ratios []float64
+
+	var knowledgeTotal, knowledgeDone int64
+	s.db.Model(&models.Knowledge{}).Where("chapter_id = ?", chapterID).Count(&knowledgeTotal)
+	if knowledgeTotal > 0 {
+		s.db.Model(&models.KnowledgeProgress{}).
+			Where("user_id = ? AND chapter_id = ?", userID, chapterID).
+			Count(&knowledgeDone)
+		ratios = append(ratios, float64(knowledgeDone)/float64(knowledgeTotal))
+	}
+
+	var exerciseTotal, exerciseDone int64
+	s.db.Model(&models.Exercise{}).Where("chapter_id = ? AND status = ?", chapterID, 1).Count(&exerciseTotal)
+	if exerciseTotal > 0 {
+		s.db.Model(&models.ExerciseRecord{}).
+			Joins("JOIN exercises ON exercises.id = exercise_records.exercise_id").
+			Where("exercise_records.user_id = ? AND exercises.chapter_id = ? AND exercises.status = ? AND exercise_records.status = ?",
+				userID, chapterID, 1, "completed").
+			Distinct("exercise_records.exercise_id").
+			Count(&exerciseDone)
+		ratios = append(ratios, float64(exerciseDone)/float64(exerciseTotal))
+	}
+
+	value := 0.0
+	for _, r := range ratios {
+		value += r
+	}
+	if len(ratios) > 0 {
+		value = value / float64(len(ratios)) * 100
+	}
+
+	updates := map[string]interface{}{
+		"progress":      value,
+		"last_study_at": time.Now(),
+	}
+	if err := s.db.Model(progress).Updates(updates).Error; err != nil {
+		return nil, err
+	}
+	return progress, nil
+}
+
+// CourseRollup 汇总学生在课程内的学习进度，未学习的章节按0计入
+func (s *ProgressService) CourseRollup(userID, courseID uint) (*CourseProgress, error) {
+	var chapterCount int64
+	if err := s.db.Model(&models.Chapter{}).Where("course_id = ?", courseID).Count(&chapterCount).Error; err != nil {
+		return nil, err
+	}
+
+	var chapters []models.LearningProgress
+	if err := s.db.Preload("Chapter").
+		Where("user_id = ? AND course_id = ?", userID, courseID).
+		Find(&chapters).Error; err != nil {
+		return nil, err
+	}
+
+	rollup := &CourseProgress{
+		CourseID:     courseID,
+		ChapterCount: int(chapterCount),
+		Chapters:     chapters,
+	}
+
+	var total float64
+	for i := range chapters {
+		p := &chapters[i]
+		total += p.Progress
+		rollup.TimeSpent += p.TimeSpent
+		if p.Completed {
+			rollup.CompletedChapters++
+		}
+		if rollup.LastStudyAt == nil || p.LastStudyAt.After(*rollup.LastStudyAt) {
+			rollup.LastStudyAt = &p.LastStudyAt
+		}
+	}
+	if chapterCount > 0 {
+		rollup.Progress = total / float64(chapterCount)
+	}
+
+	return rollup, nil
+}
+
+func (s *ProgressService) getOrCreate(userID, chapterID uint) (*models.LearningProgress, error) {
+	var progress models.LearningProgress
+	err := s.db.Where("user_id = ? AND chapter_id = ?", userID, chapterID).First(&progress).Error
+	if err == nil {
+		return &progress, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	var chapter models.Chapter
+	if err := s.db.First(&chapter, chapterID).Error; err != nil {
+		return nil, err
+	}
+
+	progress = models.LearningProgress{
+		UserID:      userID,
+		CourseID:    chapter.CourseID,
+		ChapterID:   chapter.ID,
+		LastStudyAt: time.Now(),
+	}
+	if err := s.db.Create(&progress).Error; err != nil {
+		return nil, err
+	}
+	return &progress, nil
+}
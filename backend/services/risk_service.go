@@ -0,0 +1,374 @@
+package services
+
+import (
+	"backend/config"
+	"backend/database"
+	"backend/models"
+	"backend/redis"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 风险因素权重，合计100
+const (
+	riskWeightScoreDecline = 20
+	riskWeightStalled      = 15
+	riskWeightMissedExam   = 25
+	riskWeightInactive     = 20
+	riskWeightLowAccuracy  = 20
+
+	defaultRiskInterval       = 24 // 小时
+	defaultRiskInactiveDays   = 7
+	defaultRiskAlertThreshold = 40
+	riskHighThreshold         = 60
+
+	examGraceDays       = 7   // 考试发布超过该天数未完成视为缺考
+	lowAccuracyRate     = 0.6 // 正确率低于该值视为偏低
+	lowAccuracyMinCount = 5   // 至少作答该数量题目才判断正确率
+	scoreDeclineRate    = 0.2 // 近期成绩较此前下降超过该比例视为下滑
+)
+
+// RiskFactor 风险因素
+type RiskFactor struct {
+	Code        string  `json:"code"`
+	Description string  `json:"description"`
+	Weight      int     `json:"weight"`
+	Value       float64 `json:"value"`
+}
+
+// RiskService 学业风险预警服务
+type RiskService struct {
+	db  *gorm.DB
+	cfg config.RiskConfig
+}
+
+// NewRiskService 创建学业风险预警服务实例
+func NewRiskService() *RiskService {
+	cfg := config.GlobalConfig.Risk
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultRiskInterval
+	}
+	if cfg.InactiveDays <= 0 {
+		cfg.InactiveDays = defaultRiskInactiveDays
+	}
+	if cfg.AlertThreshold <= 0 {
+		cfg.AlertThreshold = defaultRiskAlertThreshold
+	}
+	return &RiskService{db: database.DB, cfg: cfg}
+}
+
+// StartScheduler 按配置间隔定期评估所有课程，多实例部署时通过Redis锁保证只有一个实例执行
+func (s *RiskService) StartScheduler() {
+	if !s.cfg.Enabled {
+		return
+	}
+
+	interval := time.Duration(s.cfg.Interval) * time.Hour
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			s.runLocked(interval)
+			<-ticker.C
+		}
+	}()
+	log.Printf("Risk alert scheduler started, interval %v", interval)
+}
+
+func (s *RiskService) runLocked(interval time.Duration) {
+	ctx := context.Background()
+	ok, err := redis.RDB.SetNX(ctx, "risk:evaluate:lock", time.Now().Unix(), interval/2).Result()
+	if err != nil || !ok {
+		return
+	}
+
+	if err := s.EvaluateAll(); err != nil {
+		log.Printf("学业风险评估失败: %v", err)
+	}
+}
+
+// EvaluateAll 评估所有正常课程
+func (s *RiskService) EvaluateAll() error {
+	var courseIDs []uint
+	if err := s.db.Model(&models.Course{}).Where("status = ?", 1).Pluck("id", &courseIDs).Error; err != nil {
+		return err
+	}
+
+	for _, courseID := range courseIDs {
+		if _, err := s.EvaluateCourse(courseID); err != nil {
+			log.Printf("课程%d风险评估失败: %v", courseID, err)
+		}
+	}
+	return nil
+}
+
+// EvaluateCourse 评估课程内所有在读学生，返回当前处于预警状态的学生数
+func (s *RiskService) EvaluateCourse(courseID uint) (int, error) {
+	var course models.Course
+	if err := s.db.First(&course, courseID).Error; err != nil {
+		return 0, err
+	}
+
+	var enrollments []models.Enrollment
+	if err := s.db.Preload("User").
+		Where("course_id = ? AND status = ?", courseID, models.EnrollmentActive).
+		Find(&enrollments).Error; err != nil {
+		return 0, err
+	}
+
+	alerted := 0
+	for _, enrollment := range enrollments {
+		score, factors, err := s.EvaluateStudent(&course, &enrollment)
+		if err != nil {
+			return alerted, err
+		}
+		if err := s.saveAlert(&course, &enrollment.User, score, factors); err != nil {
+			return alerted, err
+		}
+		if score >= s.cfg.AlertThreshold {
+			alerted++
+		}
+	}
+	return alerted, nil
+}
+
+// EvaluateStudent 计算学生在课程中的风险分和风险因素
+func (s *RiskService) EvaluateStudent(course *models.Course, enrollment *models.Enrollment) (int, []RiskFactor, error) {
+	userID := enrollment.UserID
+	now := time.Now()
+	inactiveSince := now.AddDate(0, 0, -s.cfg.InactiveDays)
+	var factors []RiskFactor
+
+	// 成绩下滑
+	var records []struct {
+		Score      int
+		TotalScore int
+	}
+	if err := s.db.Model(&models.ExerciseRecord{}).
+		Select("exercise_records.score, exercises.total_score").
+		Joins("JOIN exercises ON exercises.id = exercise_records.exercise_id").
+		Where("exercises.course_id = ? AND exercise_records.user_id = ? AND exercise_records.status = ?", course.ID, userID, "completed").
+		Order("exercise_records.updated_at ASC").
+		Scan(&records).Error; err != nil {
+		return 0, nil, err
+	}
+	if len(records) >= 2 {
+		normalized := make([]float64, len(records))
+		for i, r := range records {
+			normalized[i] = float64(r.Score)
+			if r.TotalScore > 0 {
+				normalized[i] = float64(r.Score) / float64(r.TotalScore) * 100
+			}
+		}
+		split := len(normalized) - 3
+		if split < 1 {
+			split = 1
+		}
+		previous, recent := average(normalized[:split]), average(normalized[split:])
+		if previous > 0 {
+			if decline := (previous - recent) / previous; decline >= scoreDeclineRate {
+				factors = append(factors, RiskFactor{
+					Code:        "score_decline",
+					Description: fmt.Sprintf("近期练习成绩较此前下降%.0f%%", decline*100),
+					Weight:      riskWeightScoreDecline,
+					Value:       decline,
+				})
+			}
+		}
+	}
+
+	// 学习进度停滞
+	var progress []models.LearningProgress
+	if err := s.db.Where("course_id = ? AND user_id = ?", course.ID, userID).Find(&progress).Error; err != nil {
+		return 0, nil, err
+	}
+	lastActivity := enrollment.CreatedAt
+	stalled := 0
+	for _, p := range progress {
+		if p.LastStudyAt.After(lastActivity) {
+			lastActivity = p.LastStudyAt
+		}
+		if !p.Completed && p.Progress < 100 && p.UpdatedAt.Before(inactiveSince) {
+			stalled++
+		}
+	}
+	if stalled > 0 {
+		factors = append(factors, RiskFactor{
+			Code:        "progress_stalled",
+			Description: fmt.Sprintf("%d个章节超过%d天没有进展", stalled, s.cfg.InactiveDays),
+			Weight:      riskWeightStalled,
+			Value:       float64(stalled),
+		})
+	}
+
+	// 缺考
+	var missed int64
+	if err := s.db.Model(&models.Exercise{}).
+		Where("course_id = ? AND type = ? AND status = ? AND created_at < ? AND created_at > ?",
+			course.ID, "exam", 1, now.AddDate(0, 0, -examGraceDays), enrollment.CreatedAt).
+		Where("id NOT IN (?)", s.db.Model(&models.ExerciseRecord{}).
+			Select("exercise_id").
+			Where("user_id = ? AND status = ? AND exercise_id IS NOT NULL", userID, "completed")).
+		Count(&missed).Error; err != nil {
+		return 0, nil, err
+	}
+	if missed > 0 {
+		factors = append(factors, RiskFactor{
+			Code:        "missed_exam",
+			Description: fmt.Sprintf("有%d场考试未参加", missed),
+			Weight:      riskWeightMissedExam,
+			Value:       float64(missed),
+		})
+	}
+
+	// 长期不活跃
+	var lastAnswer struct{ At *time.Time }
+	s.db.Model(&models.StudentAnswer{}).
+		Select("MAX(student_answers.created_at) AS at").
+		Joins("JOIN exercises ON exercises.id = student_answers.exercise_id").
+		Where("exercises.course_id = ? AND student_answers.user_id = ?", course.ID, userID).
+		Scan(&lastAnswer)
+	if lastAnswer.At != nil && lastAnswer.At.After(lastActivity) {
+		lastActivity = *lastAnswer.At
+	}
+	if lastActivity.Before(inactiveSince) {
+		days := int(now.Sub(lastActivity).Hours() / 24)
+		factors = append(factors, RiskFactor{
+			Code:        "inactive",
+			Description: fmt.Sprintf("已%d天没有学习记录", days),
+			Weight:      riskWeightInactive,
+			Value:       float64(days),
+		})
+	}
+
+	// 答题正确率偏低
+	var answerStats struct {
+		Total   int64
+		Correct int64
+	}
+	if err := s.db.Model(&models.StudentAnswer{}).
+		Select("COUNT(*) AS total, COALESCE(SUM(CASE WHEN student_answers.is_correct THEN 1 ELSE 0 END), 0) AS correct").
+		Joins("JOIN exercises ON exercises.id = student_answers.exercise_id").
+		Where("exercises.course_id = ? AND student_answers.user_id = ?", course.ID, userID).
+		Scan(&answerStats).Error; err != nil {
+		return 0, nil, err
+	}
+	if answerStats.Total >= lowAccuracyMinCount {
+		accuracy := float64(answerStats.Correct) / float64(answerStats.Total)
+		if accuracy < lowAccuracyRate {
+			factors = append(factors, RiskFactor{
+				Code:        "low_accuracy",
+				Description: fmt.Sprintf("答题正确率仅%.0f%%", accuracy*100),
+				Weight:      riskWeightLowAccuracy,
+				Value:       accuracy,
+			})
+		}
+	}
+
+	score := 0
+	for _, f := range factors {
+		score += f.Weight
+	}
+	return score, factors, nil
+}
+
+// saveAlert 更新学生的预警；风险分低于阈值时自动解除，已解除的预警只在风险分或等级上升时重新打开
+func (s *RiskService) saveAlert(course *models.Course, student *models.User, score int, factors []RiskFactor) error {
+	var alert models.RiskAlert
+	err := s.db.Where("course_id = ? AND user_id = ?", course.ID, student.ID).
+		Order("id DESC").First(&alert).Error
+	exists := err == nil
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	resolved := exists && alert.Status == models.RiskAlertResolved
+
+	if score < s.cfg.AlertThreshold {
+		if !exists || resolved {
+			return nil
+		}
+		return s.db.Model(&alert).Updates(map[string]interface{}{
+			"status":       models.RiskAlertResolved,
+			"score":        score,
+			"evaluated_at": time.Now(),
+		}).Error
+	}
+
+	level := models.RiskLevelMedium
+	if score >= riskHighThreshold {
+		level = models.RiskLevelHigh
+	}
+	factorsJSON, err := json.Marshal(factors)
+	if err != nil {
+		return err
+	}
+
+	escalated := !exists || (alert.Level != models.RiskLevelHigh && level == models.RiskLevelHigh)
+	if resolved {
+		// 教师已处理或此前已自动解除，风险没有加重时保持解除状态，避免每次评估重复预警
+		if score <= alert.Score && !escalated {
+			return nil
+		}
+		escalated = true
+	}
+	if exists {
+		updates := map[string]interface{}{
+			"score":        score,
+			"level":        level,
+			"factors":      string(factorsJSON),
+			"evaluated_at": time.Now(),
+		}
+		if resolved {
+			updates["status"] = models.RiskAlertOpen
+		}
+		err = s.db.Model(&alert).Updates(updates).Error
+	} else {
+		alert = models.RiskAlert{
+			CourseID:    course.ID,
+			UserID:      student.ID,
+			Score:       score,
+			Level:       level,
+			Factors:     string(factorsJSON),
+			Status:      models.RiskAlertOpen,
+			EvaluatedAt: time.Now(),
+		}
+		err = s.db.Create(&alert).Error
+	}
+	if err != nil {
+		return err
+	}
+
+	if escalated {
+		levelName := map[models.RiskLevel]string{models.RiskLevelMedium: "中", models.RiskLevelHigh: "高"}[level]
+		name := student.RealName
+		if name == "" {
+			name = student.Username
+		}
+		content := fmt.Sprintf("课程《%s》中的学生%s存在学业风险（风险等级：%s，风险分：%d），请及时关注。", course.Name, name, levelName, score)
+		// 通知课程负责人和有学情分析权限的协作教师、助教
+		for _, teacherID := range NewPermissionService().CourseUsersWith(course, models.PermCourseAnalytics) {
+			if err := NewNotificationService().Notify(teacherID, "risk_alert", "学生学业风险预警", content,
+				fmt.Sprintf("/courses/%d/risk-alerts", course.ID)); err != nil {
+				log.Printf("发送风险预警通知失败: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+func average(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	var total float64
+	for _, v := range values {
+		total += v
+	}
+	return total / float64(len(values))
+}
@@ -0,0 +1,230 @@
+package services
+
+import (
+	"backend/config"
+	"backend/database"
+	"backend/models"
+	"backend/redis"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	tenantCacheTTL     = time.Minute
+	defaultTenantName  = "默认学校"
+	aiQuotaKeyLifetime = 48 * time.Hour
+)
+
+var (
+	ErrTenantNotFound      = errors.New("学校不存在")
+	ErrTenantDisabled      = errors.New("该学校已停用")
+	ErrUserQuotaExceeded   = errors.New("已达到本校账户数量上限，请联系平台管理员")
+	ErrCourseQuotaExceeded = errors.New("已达到本校课程数量上限，请联系平台管理员")
+	ErrAIQuotaExceeded     = errors.New("本校今日AI调用次数已用完，请明天再试")
+	ErrTenantCodeConflict  = errors.New("学校标识已被使用")
+	tenantOwnedTables      = []string{"users", "courses", "course_materials", "knowledge_bases", "registration_invites", "audit_logs"}
+	tenantCacheByCode      = map[string]tenantCacheEntry{}
+	tenantCacheByID        = map[uint]tenantCacheEntry{}
+	tenantCacheMu          sync.RWMutex
+)
+
+type tenantCacheEntry struct {
+	tenant   models.Tenant
+	loadedAt time.Time
+}
+
+// InvalidateTenantCache 学校信息变更后清除缓存
+func InvalidateTenantCache() {
+	tenantCacheMu.Lock()
+	tenantCacheByCode = map[string]tenantCacheEntry{}
+	tenantCacheByID = map[uint]tenantCacheEntry{}
+	tenantCacheMu.Unlock()
+}
+
+// TenantService 学校（租户）管理与配额
+type TenantService struct {
+	db  *gorm.DB
+	cfg config.TenantConfig
+}
+
+// NewTenantService 创建学校服务实例
+func NewTenantService() *TenantService {
+	cfg := config.GlobalConfig.Tenant
+	if cfg.Default == "" {
+		cfg.Default = "default"
+	}
+	return &TenantService{db: database.DB, cfg: cfg}
+}
+
+// SeedDefault 创建默认学校，把升级前没有归属的数据归入默认学校，并设置配置中的平台管理员，启动时调用
+func (s *TenantService) SeedDefault() error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var tenant models.Tenant
+		if err := tx.Where(models.Tenant{Code: s.cfg.Default}).
+			Attrs(models.Tenant{Name: defaultTenantName, Status: models.TenantStatusActive}).
+			FirstOrCreate(&tenant).Error; err != nil {
+			return err
+		}
+		for _, table := range tenantOwnedTables {
+			if err := tx.Table(table).Where("tenant_id = 0").Update("tenant_id", tenant.ID).Error; err != nil {
+				return err
+			}
+		}
+		if len(s.cfg.SuperAdmins) > 0 {
+			if err := tx.Model(&models.User{}).
+				Where("tenant_id = ? AND username IN ?", tenant.ID, s.cfg.SuperAdmins).
+				Update("role", models.RoleSuperAdmin).Error; err != nil {
+				return err
+			}
+		}
+		// 外部身份跟随所绑定账户的学校
+		return tx.Exec("UPDATE user_identities SET tenant_id = COALESCE((SELECT users.tenant_id FROM users WHERE users.id = user_identities.user_id), 0) WHERE tenant_id = 0").Error
+	})
+}
+
+// DefaultCode 默认学校标识
+func (s *TenantService) DefaultCode() string {
+	return s.cfg.Default
+}
+
+// BaseDomain 按子域名区分学校时的主域名
+func (s *TenantService) BaseDomain() string {
+	return s.cfg.BaseDomain
+}
+
+// GetByCode 按标识获取启用中的学校
+func (s *TenantService) GetByCode(code string) (*models.Tenant, error) {
+	tenantCacheMu.RLock()
+	entry, ok := tenantCacheByCode[code]
+	tenantCacheMu.RUnlock()
+	if !ok || time.Since(entry.loadedAt) >= tenantCacheTTL {
+		var tenant models.Tenant
+		if err := s.db.Where("code = ?", code).First(&tenant).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrTenantNotFound
+			}
+			return nil, err
+		}
+		entry = s.cache(tenant)
+	}
+	return s.checkActive(entry.tenant)
+}
+
+// Get 按ID获取启用中的学校
+func (s *TenantService) Get(id uint) (*models.Tenant, error) {
+	tenantCacheMu.RLock()
+	entry, ok := tenantCacheByID[id]
+	tenantCacheMu.RUnlock()
+	if !ok || time.Since(entry.loadedAt) >= tenantCacheTTL {
+		var tenant models.Tenant
+		if err := s.db.First(&tenant, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrTenantNotFound
+			}
+			return nil, err
+		}
+		entry = s.cache(tenant)
+	}
+	return s.checkActive(entry.tenant)
+}
+
+func (s *TenantService) cache(tenant models.Tenant) tenantCacheEntry {
+	entry := tenantCacheEntry{tenant: tenant, loadedAt: time.Now()}
+	tenantCacheMu.Lock()
+	tenantCacheByCode[tenant.Code] = entry
+	tenantCacheByID[tenant.ID] = entry
+	tenantCacheMu.Unlock()
+	return entry
+}
+
+func (s *TenantService) checkActive(tenant models.Tenant) (*models.Tenant, error) {
+	if tenant.Status != models.TenantStatusActive {
+		return nil, ErrTenantDisabled
+	}
+	return &tenant, nil
+}
+
+// CheckUserQuota 检查学校新增adding个账户后是否超过配额
+func (s *TenantService) CheckUserQuota(tenantID uint, adding int) error {
+	tenant, err := s.Get(tenantID)
+	if err != nil {
+		return err
+	}
+	if tenant.MaxUsers <= 0 {
+		return nil
+	}
+	var count int64
+	if err := s.db.Model(&models.User{}).Where("tenant_id = ?", tenantID).Count(&count).Error; err != nil {
+		return err
+	}
+	if int(count)+adding > tenant.MaxUsers {
+		return ErrUserQuotaExceeded
+	}
+	return nil
+}
+
+// CheckCourseQuota 检查学校是否还能创建课程
+func (s *TenantService) CheckCourseQuota(tenantID uint) error {
+	tenant, err := s.Get(tenantID)
+	if err != nil {
+		return err
+	}
+	if tenant.MaxCourses <= 0 {
+		return nil
+	}
+	var count int64
+	if err := s.db.Model(&models.Course{}).Where("tenant_id = ?", tenantID).Count(&count).Error; err != nil {
+		return err
+	}
+	if int(count) >= tenant.MaxCourses {
+		return ErrCourseQuotaExceeded
+	}
+	return nil
+}
+
+func aiQuotaKey(tenantID uint, day time.Time) string {
+	return fmt.Sprintf("tenant:ai:%d:%s", tenantID, day.Format("20060102"))
+}
+
+// ConsumeAIRequest 记录一次AI调用，超过学校每日配额时返回错误
+func (s *TenantService) ConsumeAIRequest(tenantID uint) error {
+	tenant, err := s.Get(tenantID)
+	if err != nil {
+		return err
+	}
+	if tenant.DailyAIRequests <= 0 {
+		return nil
+	}
+
+	ctx := context.Background()
+	key := aiQuotaKey(tenantID, time.Now())
+	used, err := redis.RDB.Incr(ctx, key).Result()
+	if err != nil {
+		return err
+	}
+	if used == 1 {
+		redis.RDB.Expire(ctx, key, aiQuotaKeyLifetime)
+	}
+	if used > int64(tenant.DailyAIRequests) {
+		return ErrAIQuotaExceeded
+	}
+	return nil
+}
+
+// Usage 学校当前的账户数、课程数和今日AI调用次数
+func (s *TenantService) Usage(tenantID uint) map[string]interface{} {
+	var users, courses int64
+	s.db.Model(&models.User{}).Where("tenant_id = ?", tenantID).Count(&users)
+	s.db.Model(&models.Course{}).Where("tenant_id = ?", tenantID).Count(&courses)
+	aiRequests, _ := redis.RDB.Get(context.Background(), aiQuotaKey(tenantID, time.Now())).Int64()
+	return map[string]interface{}{
+		"users":             users,
+		"courses":           courses,
+		"ai_requests_today": aiRequests,
+	}
+}
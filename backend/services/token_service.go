@@ -0,0 +1,380 @@
+package services
+
+import (
+	"backend/database"
+	"backend/models"
+	"backend/redis"
+	"backend/utils"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	goredis "github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+const (
+	sessionIDLength    = 24
+	refreshTokenLength = 48
+	lastSeenInterval   = 60 // 最后活跃时间的更新间隔(秒)，避免每个请求都写Redis
+)
+
+// rotateRefreshScript 仅当会话中的刷新令牌仍为ARGV[1]时轮换为ARGV[2]，
+// 保证同一刷新令牌并发刷新时只有一次成功。返回0表示令牌已被轮换或会话已注销
+var rotateRefreshScript = goredis.NewScript(`
+if redis.call("HGET", KEYS[1], "refresh_hash") ~= ARGV[1] then
+	return 0
+end
+redis.call("HSET", KEYS[1], "refresh_hash", ARGV[2], "previous_hash", ARGV[1], "last_seen", ARGV[3])
+if ARGV[5] ~= "" then
+	redis.call("HSET", KEYS[1], "ip", ARGV[5])
+end
+if ARGV[6] ~= "" then
+	redis.call("HSET", KEYS[1], "user_agent", ARGV[6])
+end
+redis.call("EXPIRE", KEYS[1], ARGV[4])
+return 1
+`)
+
+// touchSessionScript 读取会话最后活跃时间，超过ARGV[2]秒时更新为ARGV[1]。
+// 只在会话仍存在时写入，避免与注销并发时重新创建出没有过期时间的会话。返回0表示会话已注销
+var touchSessionScript = goredis.NewScript(`
+local lastSeen = redis.call("HGET", KEYS[1], "last_seen")
+if not lastSeen then
+	return 0
+end
+if tonumber(ARGV[1]) - tonumber(lastSeen) >= tonumber(ARGV[2]) then
+	redis.call("HSET", KEYS[1], "last_seen", ARGV[1])
+end
+return 1
+`)
+
+var (
+	ErrInvalidRefreshToken = errors.New("刷新令牌无效或已过期")
+	ErrTokenRevoked        = errors.New("令牌已失效")
+)
+
+// TokenPair 登录或刷新后返回给客户端的令牌
+type TokenPair struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"` // 访问令牌有效期(秒)
+}
+
+// SessionMeta 登录时记录的客户端信息
+type SessionMeta struct {
+	IP        string
+	UserAgent string
+}
+
+// SessionInfo 登录会话（设备）信息
+type SessionInfo struct {
+	ID        string    `json:"id"`
+	Device    string    `json:"device"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	CreatedAt time.Time `json:"created_at"`
+	LastSeen  time.Time `json:"last_seen"`
+	Current   bool      `json:"current"`
+}
+
+// TokenService 访问令牌与刷新令牌管理。
+// 每次登录创建一个会话，会话保存在Redis中；刷新令牌格式为"会话ID.随机串"，
+// 每次刷新都会轮换随机串，旧刷新令牌再次使用视为泄露并注销该会话。
+type TokenService struct {
+	db *gorm.DB
+}
+
+// NewTokenService 创建令牌服务实例
+func NewTokenService() *TokenService {
+	return &TokenService{db: database.DB}
+}
+
+func sessionKey(sessionID string) string {
+	return "auth:session:" + sessionID
+}
+
+func userSessionsKey(userID uint) string {
+	return fmt.Sprintf("auth:user_sessions:%d", userID)
+}
+
+func tokenVersionKey(userID uint) string {
+	return fmt.Sprintf("auth:token_version:%d", userID)
+}
+
+// describeDevice 根据User-Agent生成简短的设备描述，如"Windows · Chrome"
+func describeDevice(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+	if ua == "" {
+		return "未知设备"
+	}
+
+	system := "未知系统"
+	for _, item := range []struct{ keyword, name string }{
+		{"iphone", "iPhone"},
+		{"ipad", "iPad"},
+		{"android", "Android"},
+		{"harmonyos", "HarmonyOS"},
+		{"windows", "Windows"},
+		{"mac os", "macOS"},
+		{"linux", "Linux"},
+	} {
+		if strings.Contains(ua, item.keyword) {
+			system = item.name
+			break
+		}
+	}
+
+	client := "未知浏览器"
+	for _, item := range []struct{ keyword, name string }{
+		{"micromessenger", "微信"},
+		{"edg/", "Edge"},
+		{"opr/", "Opera"},
+		{"firefox/", "Firefox"},
+		{"chrome/", "Chrome"},
+		{"safari/", "Safari"},
+		{"curl/", "curl"},
+		{"postman", "Postman"},
+	} {
+		if strings.Contains(ua, item.keyword) {
+			client = item.name
+			break
+		}
+	}
+	return system + " · " + client
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// IssueTokens 为用户创建新会话并签发令牌
+func (s *TokenService) IssueTokens(user *models.User, meta SessionMeta) (*TokenPair, error) {
+	sessionID, err := utils.GenerateRandomString(sessionIDLength)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := utils.GenerateRandomString(refreshTokenLength)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	now := time.Now().Unix()
+	ttl := utils.RefreshTokenTTL()
+	key := sessionKey(sessionID)
+
+	pipe := redis.RDB.TxPipeline()
+	pipe.HSet(ctx, key, map[string]interface{}{
+		"user_id":      user.ID,
+		"refresh_hash": hashToken(secret),
+		"ip":           meta.IP,
+		"user_agent":   meta.UserAgent,
+		"created_at":   now,
+		"last_seen":    now,
+	})
+	pipe.Expire(ctx, key, ttl)
+	pipe.SAdd(ctx, userSessionsKey(user.ID), sessionID)
+	pipe.Expire(ctx, userSessionsKey(user.ID), ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	return s.signPair(user, sessionID, secret)
+}
+
+// Refresh 校验并轮换刷新令牌，签发新的访问令牌
+func (s *TokenService) Refresh(refreshToken string, meta SessionMeta) (*TokenPair, error) {
+	sessionID, secret, ok := strings.Cut(refreshToken, ".")
+	if !ok || sessionID == "" || secret == "" {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	ctx := context.Background()
+	key := sessionKey(sessionID)
+	session, err := redis.RDB.HGetAll(ctx, key).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(session) == 0 {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	userID64, _ := strconv.ParseUint(session["user_id"], 10, 64)
+	userID := uint(userID64)
+	hash := hashToken(secret)
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(session["refresh_hash"])) != 1 {
+		// 已轮换掉的刷新令牌被再次使用，可能已泄露，注销整个会话
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(session["previous_hash"])) == 1 {
+			s.RevokeSession(userID, sessionID)
+		}
+		return nil, ErrInvalidRefreshToken
+	}
+
+	var user models.User
+	if err := s.db.First(&user, userID).Error; err != nil {
+		s.RevokeSession(userID, sessionID)
+		return nil, ErrInvalidRefreshToken
+	}
+	if user.Status != models.UserStatusActive {
+		s.RevokeSession(userID, sessionID)
+		return nil, ErrTokenRevoked
+	}
+
+	newSecret, err := utils.GenerateRandomString(refreshTokenLength)
+	if err != nil {
+		return nil, err
+	}
+	ttl := utils.RefreshTokenTTL()
+	rotated, err := rotateRefreshScript.Run(ctx, redis.RDB, []string{key},
+		hash, hashToken(newSecret), time.Now().Unix(), int(ttl.Seconds()), meta.IP, meta.UserAgent).Int()
+	if err != nil {
+		return nil, err
+	}
+	if rotated == 0 {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err := redis.RDB.Expire(ctx, userSessionsKey(user.ID), ttl).Err(); err != nil {
+		return nil, err
+	}
+
+	return s.signPair(&user, sessionID, newSecret)
+}
+
+func (s *TokenService) signPair(user *models.User, sessionID, secret string) (*TokenPair, error) {
+	accessToken, err := utils.GenerateToken(user, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: sessionID + "." + secret,
+		ExpiresIn:    int(utils.AccessTokenTTL().Seconds()),
+	}, nil
+}
+
+// ValidateAccess 检查访问令牌对应的会话是否仍然有效、令牌版本是否最新
+func (s *TokenService) ValidateAccess(claims *utils.Claims) error {
+	if claims.SessionID == "" {
+		return ErrTokenRevoked
+	}
+
+	active, err := touchSessionScript.Run(context.Background(), redis.RDB, []string{sessionKey(claims.SessionID)},
+		time.Now().Unix(), lastSeenInterval).Int()
+	if err != nil {
+		return err
+	}
+	if active == 0 {
+		return ErrTokenRevoked
+	}
+
+	version, err := s.tokenVersion(claims.UserID)
+	if err != nil {
+		return err
+	}
+	if version != claims.TokenVersion {
+		return ErrTokenRevoked
+	}
+	return nil
+}
+
+// tokenVersion 读取用户当前令牌版本，优先使用Redis缓存
+func (s *TokenService) tokenVersion(userID uint) (int, error) {
+	ctx := context.Background()
+	cached, err := redis.GetCache(ctx, tokenVersionKey(userID))
+	if err == nil {
+		if version, err := strconv.Atoi(cached); err == nil {
+			return version, nil
+		}
+	} else if !errors.Is(err, goredis.Nil) {
+		return 0, err
+	}
+
+	var user models.User
+	if err := s.db.Select("id", "token_version").First(&user, userID).Error; err != nil {
+		return 0, ErrTokenRevoked
+	}
+	redis.SetCache(ctx, tokenVersionKey(userID), user.TokenVersion, utils.AccessTokenTTL())
+	return user.TokenVersion, nil
+}
+
+// ListSessions 列出用户当前有效的登录会话，按最后活跃时间倒序
+func (s *TokenService) ListSessions(userID uint, currentSessionID string) ([]SessionInfo, error) {
+	ctx := context.Background()
+	sessionIDs, err := redis.RDB.SMembers(ctx, userSessionsKey(userID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := []SessionInfo{}
+	for _, sessionID := range sessionIDs {
+		session, err := redis.RDB.HGetAll(ctx, sessionKey(sessionID)).Result()
+		if err != nil {
+			return nil, err
+		}
+		// 会话已过期，从集合中清理
+		if len(session) == 0 {
+			redis.RDB.SRem(ctx, userSessionsKey(userID), sessionID)
+			continue
+		}
+
+		createdAt, _ := strconv.ParseInt(session["created_at"], 10, 64)
+		lastSeen, _ := strconv.ParseInt(session["last_seen"], 10, 64)
+		sessions = append(sessions, SessionInfo{
+			ID:        sessionID,
+			Device:    describeDevice(session["user_agent"]),
+			IP:        session["ip"],
+			UserAgent: session["user_agent"],
+			CreatedAt: time.Unix(createdAt, 0),
+			LastSeen:  time.Unix(lastSeen, 0),
+			Current:   sessionID == currentSessionID,
+		})
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeen.After(sessions[j].LastSeen)
+	})
+	return sessions, nil
+}
+
+// HasSession 会话是否属于该用户且仍然有效
+func (s *TokenService) HasSession(userID uint, sessionID string) (bool, error) {
+	return redis.RDB.SIsMember(context.Background(), userSessionsKey(userID), sessionID).Result()
+}
+
+// RevokeSession 注销单个会话，该会话的访问令牌和刷新令牌立即失效
+func (s *TokenService) RevokeSession(userID uint, sessionID string) error {
+	ctx := context.Background()
+	pipe := redis.RDB.TxPipeline()
+	pipe.Del(ctx, sessionKey(sessionID))
+	pipe.SRem(ctx, userSessionsKey(userID), sessionID)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// RevokeAll 递增用户令牌版本并注销其所有会话，用于修改密码、禁用账户等场景
+func (s *TokenService) RevokeAll(userID uint) error {
+	if err := s.db.Model(&models.User{}).Unscoped().Where("id = ?", userID).
+		UpdateColumn("token_version", gorm.Expr("token_version + 1")).Error; err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	sessionIDs, err := redis.RDB.SMembers(ctx, userSessionsKey(userID)).Result()
+	if err != nil {
+		return err
+	}
+	keys := []string{userSessionsKey(userID), tokenVersionKey(userID)}
+	for _, sessionID := range sessionIDs {
+		keys = append(keys, sessionKey(sessionID))
+	}
+	return redis.RDB.Del(ctx, keys...).Err()
+}
@@ -0,0 +1,313 @@
+package services
+
+import (
+	"backend/config"
+	"backend/database"
+	"backend/models"
+	"backend/redis"
+	"backend/utils"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 登录挑战用途
+const (
+	ChallengeVerify = "verify" // 已启用双因素认证，需要输入验证码
+	ChallengeSetup  = "setup"  // 角色要求双因素认证但尚未绑定，需要先完成绑定
+)
+
+const (
+	challengeTokenLength   = 32
+	challengeTTL           = 5 * time.Minute
+	challengeMaxAttempts   = 5
+	recoveryCodeCount      = 10
+	recoveryCodeLength     = 10
+	defaultTwoFactorIssuer = "智能教学实训平台"
+)
+
+var (
+	ErrInvalidChallenge    = errors.New("登录验证已过期，请重新登录")
+	ErrInvalidTwoFactor    = errors.New("验证码错误")
+	ErrTwoFactorEnabled    = errors.New("已启用双因素认证")
+	ErrTwoFactorNotEnabled = errors.New("未启用双因素认证")
+	ErrTwoFactorNotStarted = errors.New("请先获取绑定密钥")
+	ErrTwoFactorEnforced   = errors.New("当前角色必须启用双因素认证")
+)
+
+// TwoFactorEnrollment 绑定身份验证器时返回的密钥
+type TwoFactorEnrollment struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+// TwoFactorService TOTP双因素认证
+type TwoFactorService struct {
+	db  *gorm.DB
+	cfg config.TwoFAConfig
+}
+
+// NewTwoFactorService 创建双因素认证服务实例
+func NewTwoFactorService() *TwoFactorService {
+	cfg := config.GlobalConfig.TwoFA
+	if cfg.Issuer == "" {
+		cfg.Issuer = defaultTwoFactorIssuer
+	}
+	return &TwoFactorService{db: database.DB, cfg: cfg}
+}
+
+func challengeKey(token string) string {
+	return "2fa:challenge:" + hashToken(token)
+}
+
+// IsEnforced 该角色是否必须启用双因素认证
+func (s *TwoFactorService) IsEnforced(role models.UserRole) bool {
+	for _, r := range s.cfg.EnforcedRoles {
+		if models.UserRole(r) == role {
+			return true
+		}
+	}
+	return false
+}
+
+// IsEnabled 用户是否已启用双因素认证
+func (s *TwoFactorService) IsEnabled(userID uint) bool {
+	var count int64
+	s.db.Model(&models.TwoFactor{}).Where("user_id = ? AND enabled = ?", userID, true).Count(&count)
+	return count > 0
+}
+
+// Status 双因素认证状态及剩余可用的恢复码数量
+func (s *TwoFactorService) Status(user *models.User) (map[string]interface{}, error) {
+	var tf models.TwoFactor
+	err := s.db.Where("user_id = ?", user.ID).First(&tf).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	var remaining int64
+	if tf.Enabled {
+		s.db.Model(&models.RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", user.ID).Count(&remaining)
+	}
+	return map[string]interface{}{
+		"enabled":                  tf.Enabled,
+		"enabled_at":               tf.EnabledAt,
+		"enforced":                 s.IsEnforced(user.Role),
+		"recovery_codes_remaining": remaining,
+	}, nil
+}
+
+// BeginEnrollment 生成新的TOTP密钥，确认验证码之前不会生效
+func (s *TwoFactorService) BeginEnrollment(user *models.User) (*TwoFactorEnrollment, error) {
+	var tf models.TwoFactor
+	err := s.db.Where("user_id = ?", user.ID).First(&tf).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if tf.Enabled {
+		return nil, ErrTwoFactorEnabled
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	tf.UserID = user.ID
+	tf.Secret = secret
+	if err := s.db.Save(&tf).Error; err != nil {
+		return nil, err
+	}
+
+	return &TwoFactorEnrollment{
+		Secret:          secret,
+		ProvisioningURI: utils.TOTPProvisioningURI(secret, s.cfg.Issuer, user.Username),
+	}, nil
+}
+
+// ConfirmEnrollment 校验身份验证器生成的验证码后启用双因素认证，返回一次性展示的恢复码
+func (s *TwoFactorService) ConfirmEnrollment(userID uint, code string) ([]string, error) {
+	var tf models.TwoFactor
+	if err := s.db.Where("user_id = ?", userID).First(&tf).Error; err != nil {
+		return nil, ErrTwoFactorNotStarted
+	}
+	if tf.Enabled {
+		return nil, ErrTwoFactorEnabled
+	}
+	if !s.checkTOTP(userID, tf.Secret, code) {
+		return nil, ErrInvalidTwoFactor
+	}
+
+	var codes []string
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Model(&tf).Updates(map[string]interface{}{"enabled": true, "enabled_at": now}).Error; err != nil {
+			return err
+		}
+		var err error
+		codes, err = s.replaceRecoveryCodes(tx, userID)
+		return err
+	})
+	return codes, err
+}
+
+// Verify 校验TOTP验证码或恢复码，恢复码使用后作废
+func (s *TwoFactorService) Verify(userID uint, code string) error {
+	var tf models.TwoFactor
+	if err := s.db.Where("user_id = ? AND enabled = ?", userID, true).First(&tf).Error; err != nil {
+		return ErrTwoFactorNotEnabled
+	}
+	if s.checkTOTP(userID, tf.Secret, code) {
+		return nil
+	}
+	if s.useRecoveryCode(userID, code) {
+		return nil
+	}
+	return ErrInvalidTwoFactor
+}
+
+// RegenerateRecoveryCodes 作废旧恢复码并生成新的一组
+func (s *TwoFactorService) RegenerateRecoveryCodes(userID uint) ([]string, error) {
+	if !s.IsEnabled(userID) {
+		return nil, ErrTwoFactorNotEnabled
+	}
+	var codes []string
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = s.replaceRecoveryCodes(tx, userID)
+		return err
+	})
+	return codes, err
+}
+
+// Disable 关闭双因素认证并删除恢复码
+func (s *TwoFactorService) Disable(userID uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.TwoFactor{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error
+	})
+}
+
+// checkTOTP 校验验证码，同一时间步的验证码只能使用一次
+func (s *TwoFactorService) checkTOTP(userID uint, secret, code string) bool {
+	step, ok := utils.ValidateTOTP(secret, code, time.Now())
+	if !ok {
+		return false
+	}
+	key := fmt.Sprintf("2fa:used:%d:%d", userID, step)
+	fresh, err := redis.RDB.SetNX(context.Background(), key, 1, 3*utils.TOTPPeriod*time.Second).Result()
+	return err == nil && fresh
+}
+
+func (s *TwoFactorService) replaceRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw, err := utils.GenerateRandomString(recoveryCodeLength)
+		if err != nil {
+			return nil, err
+		}
+		hash, err := utils.HashPassword(raw)
+		if err != nil {
+			return nil, err
+		}
+		if err := tx.Create(&models.RecoveryCode{UserID: userID, CodeHash: hash}).Error; err != nil {
+			return nil, err
+		}
+		codes = append(codes, raw[:5]+"-"+raw[5:])
+	}
+	return codes, nil
+}
+
+func (s *TwoFactorService) useRecoveryCode(userID uint, code string) bool {
+	normalized := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	if len(normalized) != recoveryCodeLength {
+		return false
+	}
+
+	var codes []models.RecoveryCode
+	s.db.Where("user_id = ? AND used_at IS NULL", userID).Find(&codes)
+	for _, rc := range codes {
+		if !utils.CheckPassword(normalized, rc.CodeHash) {
+			continue
+		}
+		result := s.db.Model(&models.RecoveryCode{}).Where("id = ? AND used_at IS NULL", rc.ID).Update("used_at", time.Now())
+		return result.Error == nil && result.RowsAffected == 1
+	}
+	return false
+}
+
+// CreateChallenge 密码验证通过后创建短期登录挑战，完成第二步验证后才签发令牌
+func (s *TwoFactorService) CreateChallenge(userID uint, purpose string) (string, error) {
+	token, err := utils.GenerateRandomString(challengeTokenLength)
+	if err != nil {
+		return "", err
+	}
+	ctx := context.Background()
+	key := challengeKey(token)
+	pipe := redis.RDB.TxPipeline()
+	pipe.HSet(ctx, key, map[string]interface{}{
+		"user_id":  userID,
+		"purpose":  purpose,
+		"attempts": 0,
+	})
+	pipe.Expire(ctx, key, challengeTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// ChallengeTTL 登录挑战有效期(秒)
+func ChallengeTTL() int {
+	return int(challengeTTL.Seconds())
+}
+
+// CheckChallenge 读取登录挑战对应的用户，超过尝试次数后挑战作废
+func (s *TwoFactorService) CheckChallenge(token, purpose string) (uint, error) {
+	ctx := context.Background()
+	key := challengeKey(token)
+	challenge, err := redis.RDB.HGetAll(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+	if len(challenge) == 0 || challenge["purpose"] != purpose {
+		return 0, ErrInvalidChallenge
+	}
+
+	attempts, err := redis.RDB.HIncrBy(ctx, key, "attempts", 1).Result()
+	if err != nil {
+		return 0, err
+	}
+	if attempts > challengeMaxAttempts {
+		redis.DeleteCache(ctx, key)
+		return 0, ErrInvalidChallenge
+	}
+
+	userID, err := strconv.ParseUint(challenge["user_id"], 10, 64)
+	if err != nil {
+		return 0, ErrInvalidChallenge
+	}
+	return uint(userID), nil
+}
+
+// CompleteChallenge 第二步验证通过后删除登录挑战，删除失败说明已被使用
+func (s *TwoFactorService) CompleteChallenge(token string) error {
+	deleted, err := redis.RDB.Del(context.Background(), challengeKey(token)).Result()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrInvalidChallenge
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	AvatarUploadDir = "uploads/tenants/%d/avatars" // 按学校分目录保存
+	LegacyAvatarDir = "uploads/avatars"
+)
+
+// 删除之前上传的头像缩略图和原图，只处理本校目录和升级前的头像目录
+func RemoveAvatarFiles(tenantID uint, avatarURL string) {
+	if !strings.HasSuffix(avatarURL, "_thumb.png") {
+		return
+	}
+	if !strings.HasPrefix(avatarURL, "/"+fmt.Sprintf(AvatarUploadDir, tenantID)+"/") &&
+		!strings.HasPrefix(avatarURL, "/"+LegacyAvatarDir+"/") {
+		return
+	}
+	thumbPath := strings.TrimPrefix(avatarURL, "/")
+	os.Remove(thumbPath)
+	originals, _ := filepath.Glob(strings.TrimSuffix(thumbPath, "_thumb.png") + ".*")
+	for _, original := range originals {
+		os.Remove(original)
+	}
+}
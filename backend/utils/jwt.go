@@ -9,22 +9,56 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// 默认令牌有效期
+const (
+	defaultAccessExpire  = 30  // 访问令牌(分钟)
+	defaultRefreshExpire = 168 // 刷新令牌(小时)
+)
+
 type Claims struct {
-	UserID   uint            `json:"user_id"`
-	Username string          `json:"username"`
-	Role     models.UserRole `json:"role"`
+	UserID             uint            `json:"user_id"`
+	TenantID           uint            `json:"tid"` // 用户所属学校，与请求解析出的学校不一致时拒绝
+	Username           string          `json:"username"`
+	Role               models.UserRole `json:"role"`
+	SessionID          string          `json:"sid"`           // 登录会话ID，注销会话后令牌失效
+	TokenVersion       int             `json:"ver"`           // 用户令牌版本，修改密码或禁用账户后递增
+	MustChangePassword bool            `json:"mcp,omitempty"` // 需要先修改初始密码，只能访问修改密码等少数接口
 	jwt.RegisteredClaims
 }
 
-// 生成JWT token
-func GenerateToken(user *models.User) (string, error) {
+// 访问令牌有效期
+func AccessTokenTTL() time.Duration {
+	if minutes := config.GlobalConfig.JWT.AccessExpire; minutes > 0 {
+		return time.Duration(minutes) * time.Minute
+	}
+	return defaultAccessExpire * time.Minute
+}
+
+// 刷新令牌有效期，未配置refresh_expire时沿用expire
+func RefreshTokenTTL() time.Duration {
+	cfg := config.GlobalConfig.JWT
+	if cfg.RefreshExpire > 0 {
+		return time.Duration(cfg.RefreshExpire) * time.Hour
+	}
+	if cfg.Expire > 0 {
+		return time.Duration(cfg.Expire) * time.Hour
+	}
+	return defaultRefreshExpire * time.Hour
+}
+
+// 生成短期访问令牌
+func GenerateToken(user *models.User, sessionID string) (string, error) {
 	cfg := config.GlobalConfig.JWT
-	expirationTime := time.Now().Add(time.Duration(cfg.Expire) * time.Hour)
+	expirationTime := time.Now().Add(AccessTokenTTL())
 
 	claims := &Claims{
-		UserID:   user.ID,
-		Username: user.Username,
-		Role:     user.Role,
+		UserID:             user.ID,
+		TenantID:           user.TenantID,
+		Username:           user.Username,
+		Role:               user.Role,
+		SessionID:          sessionID,
+		TokenVersion:       user.TokenVersion,
+		MustChangePassword: user.MustChangePassword,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
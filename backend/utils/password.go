@@ -1,6 +1,10 @@
 package utils
 
 import (
+	"fmt"
+	"strings"
+	"unicode"
+
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -15,3 +19,66 @@ func CheckPassword(password, hash string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 	return err == nil
 }
+
+// 密码强度要求
+const (
+	MinPasswordLength = 8
+	MaxPasswordLength = 72 // bcrypt只使用前72字节
+)
+
+// WeakPasswordError 密码不符合强度要求
+type WeakPasswordError string
+
+func (e WeakPasswordError) Error() string {
+	return string(e)
+}
+
+// 生成符合强度要求的随机初始密码，随机结果恰好只有一类字符时重新生成
+func GeneratePassword(length int, username string) (string, error) {
+	for {
+		password, err := GenerateRandomString(length)
+		if err != nil {
+			return "", err
+		}
+		if ValidatePasswordStrength(password, username) == nil {
+			return password, nil
+		}
+	}
+}
+
+// 校验密码强度：长度8-72，至少包含字母、数字、符号中的两类，且不能与用户名相同
+func ValidatePasswordStrength(password, username string) error {
+	if len(password) < MinPasswordLength {
+		return WeakPasswordError(fmt.Sprintf("密码长度不能少于%d位", MinPasswordLength))
+	}
+	if len(password) > MaxPasswordLength {
+		return WeakPasswordError(fmt.Sprintf("密码长度不能超过%d位", MaxPasswordLength))
+	}
+	if username != "" && strings.EqualFold(password, username) {
+		return WeakPasswordError("密码不能与用户名相同")
+	}
+
+	var hasLetter, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLetter(r):
+			hasLetter = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsSpace(r):
+			return WeakPasswordError("密码不能包含空白字符")
+		default:
+			hasSymbol = true
+		}
+	}
+	kinds := 0
+	for _, ok := range []bool{hasLetter, hasDigit, hasSymbol} {
+		if ok {
+			kinds++
+		}
+	}
+	if kinds < 2 {
+		return WeakPasswordError("密码需至少包含字母、数字、符号中的两类")
+	}
+	return nil
+}
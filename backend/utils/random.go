@@ -0,0 +1,22 @@
+package utils
+
+import (
+	"crypto/rand"
+	"math/big"
+)
+
+const randomAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// 生成指定长度的随机字符串（去除易混淆字符）
+func GenerateRandomString(length int) (string, error) {
+	result := make([]byte, length)
+	max := big.NewInt(int64(len(randomAlphabet)))
+	for i := range result {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		result[i] = randomAlphabet[n.Int64()]
+	}
+	return string(result), nil
+}
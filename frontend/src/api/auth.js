@@ -6,6 +6,16 @@ export const authApi = {
     return api.post('/auth/login', credentials)
   },
 
+  // 刷新令牌
+  refresh: (refreshToken) => {
+    return api.post('/auth/refresh', { refresh_token: refreshToken })
+  },
+
+  // 退出登录
+  logout: () => {
+    return api.post('/auth/logout')
+  },
+
   // 用户注册
   register: (userData) => {
     return api.post('/auth/register', userData)
@@ -51,6 +51,14 @@ export const courseApi = {
     })
   },
 
+  // 下载课程材料，需要携带登录令牌
+  downloadCourseMaterial: (courseId, materialId) => {
+    return api.get(`/course-materials/${courseId}/files/${materialId}`, {
+      responseType: 'blob',
+      timeout: 0
+    })
+  },
+
   // 删除课程材料（教师）
   deleteCourseMaterial: (materialId) => {
     return api.delete(`/course-materials/${materialId}`)
@@ -22,12 +22,31 @@ api.interceptors.request.use(
   }
 )
 
+// 正在进行的刷新请求，避免并发请求重复刷新
+let refreshing = null
+
 // 响应拦截器
 api.interceptors.response.use(
   (response) => {
     return response
   },
-  (error) => {
+  async (error) => {
+    const original = error.config
+    // 访问令牌过期时先尝试刷新一次，成功后重发原请求
+    if (error.response?.status === 401 && original && !original._retried && !original.url.startsWith('/auth/')) {
+      original._retried = true
+      try {
+        refreshing = refreshing || useAuthStore().refresh()
+        const token = await refreshing
+        original.headers.Authorization = `Bearer ${token}`
+        return api(original)
+      } catch (e) {
+        // 刷新失败，按登录过期处理
+      } finally {
+        refreshing = null
+      }
+    }
+
     if (error.response) {
       const { status, data } = error.response
       
@@ -35,7 +54,7 @@ api.interceptors.response.use(
         case 401:
           ElMessage.error('登录已过期，请重新登录')
           const authStore = useAuthStore()
-          authStore.logout()
+          authStore.clearSession()
           window.location.href = '/login'
           break
         case 403:
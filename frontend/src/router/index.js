@@ -172,6 +172,9 @@ router.beforeEach((to, from, next) => {
   
   if (to.meta.requiresAuth && !authStore.isAuthenticated) {
     next('/login')
+  } else if (to.meta.requiresAuth && authStore.user?.must_change_password && to.name !== 'Profile') {
+    // 使用初始密码登录，修改密码前只能停留在个人资料页
+    next({ name: 'Profile' })
   } else if (to.meta.requiresAdmin && authStore.user?.role !== 'admin') {
     next('/dashboard')
   } else if (to.meta.requiresTeacher && authStore.user?.role !== 'teacher') {
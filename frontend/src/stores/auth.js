@@ -4,19 +4,36 @@ import { authApi } from '@/api/auth'
 
 export const useAuthStore = defineStore('auth', () => {
   const token = ref(localStorage.getItem('token') || '')
+  const refreshToken = ref(localStorage.getItem('refresh_token') || '')
   const user = ref(JSON.parse(localStorage.getItem('user') || 'null'))
 
   const isAuthenticated = computed(() => !!token.value)
 
+  const setTokens = (tokens) => {
+    token.value = tokens.token
+    refreshToken.value = tokens.refresh_token
+    localStorage.setItem('token', tokens.token)
+    localStorage.setItem('refresh_token', tokens.refresh_token)
+  }
+
+  // 使用刷新令牌换取新的访问令牌
+  const refresh = async () => {
+    if (!refreshToken.value) {
+      throw new Error('no refresh token')
+    }
+    const response = await authApi.refresh(refreshToken.value)
+    setTokens(response.data.data)
+    return token.value
+  }
+
   const login = async (credentials) => {
     try {
       const response = await authApi.login(credentials)
-      const { token: newToken, user: userData } = response.data.data
+      const { user: userData, ...tokens } = response.data.data
       
-      token.value = newToken
+      setTokens(tokens)
       user.value = userData
       
-      localStorage.setItem('token', newToken)
       localStorage.setItem('user', JSON.stringify(userData))
       
       return { success: true }
@@ -40,13 +57,26 @@ export const useAuthStore = defineStore('auth', () => {
     }
   }
 
-  const logout = () => {
+  const clearSession = () => {
     token.value = ''
+    refreshToken.value = ''
     user.value = null
     localStorage.removeItem('token')
+    localStorage.removeItem('refresh_token')
     localStorage.removeItem('user')
   }
 
+  const logout = async () => {
+    if (token.value) {
+      try {
+        await authApi.logout()
+      } catch (error) {
+        // 令牌已失效时忽略错误
+      }
+    }
+    clearSession()
+  }
+
   const updateProfile = async (profileData) => {
     try {
       const response = await authApi.updateProfile(profileData)
@@ -63,7 +93,14 @@ export const useAuthStore = defineStore('auth', () => {
 
   const changePassword = async (passwordData) => {
     try {
-      await authApi.changePassword(passwordData)
+      const response = await authApi.changePassword(passwordData)
+      if (response.data.data?.token) {
+        setTokens(response.data.data)
+      }
+      if (user.value?.must_change_password) {
+        user.value = { ...user.value, must_change_password: false }
+        localStorage.setItem('user', JSON.stringify(user.value))
+      }
       return { success: true }
     } catch (error) {
       return { 
@@ -75,11 +112,14 @@ export const useAuthStore = defineStore('auth', () => {
 
   return {
     token,
+    refreshToken,
     user,
     isAuthenticated,
     login,
     register,
+    refresh,
     logout,
+    clearSession,
     updateProfile,
     changePassword
   }